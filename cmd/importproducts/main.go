@@ -0,0 +1,102 @@
+// Command importproducts creates or updates laptops by SKU from a CSV or JSON lines file.
+// Every row is checked first; if any is invalid nothing is imported and it exits 1.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"lapbytes/internal/productimport"
+	"lapbytes/internal/store/queries"
+	"log"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func main() {
+	file := flag.String("file", "", "file to import, - reads standard input")
+	format := flag.String("format", "", "csv or jsonl, guessed from the file name if empty")
+	dryRun := flag.Bool("dry-run", false, "validate and show what would change without writing")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("-file is required")
+	}
+	f, err := productimport.FormatFor(*format, "", *file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		fh, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Unable to open %s: %+v", *file, err)
+		}
+		defer fh.Close()
+		in = fh
+	}
+	rows, rowErrs, err := productimport.Parse(in, f)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %+v", *file, err)
+	}
+	for _, e := range rowErrs {
+		fmt.Printf("line %d (%s): %v\n", e.Line, e.Sku, e.Errors)
+	}
+
+	dsn := os.Getenv("PG_DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("No database")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		log.Fatalf("Unable to Connect to the database: %+v", err)
+	}
+	defer pool.Close()
+
+	if *dryRun {
+		planned, conflicts, err := productimport.Plan(pool, rows)
+		if err != nil {
+			log.Fatalf("Unable to look up skus: %+v", err)
+		}
+		var summary productimport.Summary
+		for _, row := range planned {
+			fmt.Printf("line %d (%s): %s\n", row.Line, row.Sku, row.Action)
+			switch row.Action {
+			case queries.ImportCreated:
+				summary.Created++
+			case queries.ImportUpdated:
+				summary.Updated++
+			default:
+				summary.Unchanged++
+			}
+		}
+		for _, e := range conflicts {
+			fmt.Printf("line %d (%s): %v\n", e.Line, e.Sku, e.Errors)
+		}
+		invalid := len(rowErrs) + len(conflicts)
+		fmt.Printf("dry run: %d to create, %d to update, %d unchanged, %d invalid\n",
+			summary.Created, summary.Updated, summary.Unchanged, invalid)
+		if invalid > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(rowErrs) > 0 {
+		fmt.Printf("%d invalid rows, nothing was imported\n", len(rowErrs))
+		os.Exit(1)
+	}
+
+	summary, rowErr, err := productimport.Import(pool, rows, func(done int) {
+		fmt.Printf("%d/%d rows\n", done, len(rows))
+	})
+	if rowErr != nil {
+		fmt.Printf("line %d (%s): %v\n", rowErr.Line, rowErr.Sku, rowErr.Errors)
+	}
+	if err != nil {
+		fmt.Printf("import failed, nothing was imported: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("imported: %d created, %d updated, %d unchanged\n", summary.Created, summary.Updated, summary.Unchanged)
+}
@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
 	"lapbytes/internal/api"
+	"lapbytes/internal/diskcache"
+	"lapbytes/internal/keys"
+	"lapbytes/internal/lockout"
+	"lapbytes/internal/mail"
+	"lapbytes/internal/payment"
+	"lapbytes/internal/storage"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func main() {
-	mux := http.NewServeMux()
-	staticDir := "./static"
-	fileServer := http.FileServer(http.Dir(staticDir))
-	mux.Handle("/static/", http.StripPrefix("/static/", fileServer))
 	dsn := os.Getenv("PG_DATABASE_URL")
 
 	if dsn == "" {
@@ -29,61 +39,210 @@ func main() {
 	jsonlogger := slog.NewJSONHandler(os.Stdout, nil)
 	logger := slog.New(jsonlogger)
 
+	cookieSecret := []byte(os.Getenv("COOKIE_SECRET"))
+	if len(cookieSecret) == 0 {
+		logger.Warn("COOKIE_SECRET not set, using a random key; guest carts will not survive a restart")
+		cookieSecret = make([]byte, 32)
+		if _, err := rand.Read(cookieSecret); err != nil {
+			log.Fatalf("Unable to generate cookie secret: %+v", err)
+		}
+	}
+
+	payments := map[string]payment.Provider{}
+	defaultProvider := os.Getenv("PAYMENT_PROVIDER")
+	if key := os.Getenv("MPESA_CONSUMER_KEY"); key != "" {
+		payments["mpesa"] = &payment.MPesa{
+			BaseURL:            os.Getenv("MPESA_BASE_URL"),
+			ConsumerKey:        key,
+			ConsumerSecret:     os.Getenv("MPESA_CONSUMER_SECRET"),
+			ShortCode:          os.Getenv("MPESA_SHORTCODE"),
+			PassKey:            os.Getenv("MPESA_PASSKEY"),
+			CallbackURL:        os.Getenv("MPESA_CALLBACK_URL"),
+			CallbackToken:      os.Getenv("MPESA_CALLBACK_TOKEN"),
+			Initiator:          os.Getenv("MPESA_INITIATOR"),
+			SecurityCredential: os.Getenv("MPESA_SECURITY_CREDENTIAL"),
+			ReversalResultURL:  os.Getenv("MPESA_REVERSAL_RESULT_URL"),
+		}
+		if defaultProvider == "" {
+			defaultProvider = "mpesa"
+		}
+	}
+	// the fake provider settles every payment, so it only runs when asked for
+	if os.Getenv("PAYMENTS_FAKE") == "true" {
+		logger.Warn("fake payment provider enabled, orders can be paid without money changing hands")
+		payments["fake"] = payment.NewFake(cookieSecret, true)
+		if defaultProvider == "" {
+			defaultProvider = "fake"
+		}
+	}
+	if len(payments) == 0 {
+		log.Fatal("No payment provider configured: set MPESA_CONSUMER_KEY, or PAYMENTS_FAKE=true for development")
+	}
+	if _, ok := payments[defaultProvider]; !ok {
+		log.Fatalf("PAYMENT_PROVIDER %q is not configured", defaultProvider)
+	}
+
+	// Signing keys, one *.pem per key id. SIGHUP reloads the directory to rotate keys.
+	// Deployments from before key directories keep working off ./private_key.pem until it is moved.
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = "./keys"
+	}
+	var keyProvider *keys.Provider
+	if _, statErr := os.Stat(keysDir); errors.Is(statErr, fs.ErrNotExist) && os.Getenv("JWT_KEYS_DIR") == "" {
+		keyProvider, err = keys.LoadLegacy("./private_key.pem")
+		if err != nil {
+			log.Fatalf("Unable to load signing keys: put <kid>.pem private keys in %s (or set JWT_KEYS_DIR); "+
+				"the old ./private_key.pem could not be used either: %+v", keysDir, err)
+		}
+		logger.Warn("signing with the legacy ./private_key.pem, move it into the keys directory to rotate keys",
+			"dir", keysDir, "file", keyProvider.ActiveId()+".pem")
+	} else if keyProvider, err = keys.LoadDir(keysDir, os.Getenv("JWT_ACTIVE_KID")); err != nil {
+		log.Fatalf("Unable to load signing keys from %s, expected <kid>.pem private keys there: %+v", keysDir, err)
+	}
+	logger.Info("signing keys loaded", "dir", keysDir, "active", keyProvider.ActiveId())
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := keyProvider.Reload(); err != nil {
+				logger.Error("signing key reload failed, keeping current keys", "error", err)
+				continue
+			}
+			logger.Info("signing keys reloaded", "active", keyProvider.ActiveId())
+		}
+	}()
+
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:5050"
+	}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "LapBytes <no-reply@lapbytes.com>"
+	}
+	var mailer mail.Sender
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		mailer = &mail.SMTP{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	} else {
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "./mail_outbox"
+		}
+		logger.Warn("SMTP_HOST not set, emails are written to files instead of sent", "dir", mailDir)
+		mailer = &mail.File{Dir: mailDir, From: mailFrom}
+	}
+
+	revocations := api.NewRevocationList(pool)
+	if err := revocations.Sync(); err != nil {
+		log.Fatalf("Unable to load token revocations: %+v", err)
+	}
+	go revocations.Run(30*time.Second, logger)
+
+	// failed login counters are shared through Postgres unless a single instance opts for memory
+	var throttleStore lockout.Store = &lockout.Postgres{Pool: pool}
+	if os.Getenv("LOGIN_THROTTLE_STORE") == "memory" {
+		throttleStore = lockout.NewMemory()
+	}
+	limiter := lockout.New(throttleStore)
+	go limiter.Run(10*time.Minute, logger)
+	resetLimiter := lockout.NewReset(throttleStore) //pruned by limiter, the windows are the same
+
+	// product images go to an S3 compatible bucket when S3_ENDPOINT is set, otherwise to MEDIA_DIR
+	var media storage.Store
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		media = &storage.S3{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    region,
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		logger.Info("media stored in s3", "endpoint", endpoint, "bucket", os.Getenv("S3_BUCKET"))
+	} else {
+		mediaDir := os.Getenv("MEDIA_DIR")
+		if mediaDir == "" {
+			mediaDir = "./media"
+		}
+		media = &storage.Local{Dir: mediaDir}
+		logger.Info("media stored on disk", "dir", mediaDir)
+	}
+
+	// deleted products and users are archived, and purged after ARCHIVE_RETENTION_DAYS once unreferenced
+	retentionDays := 90
+	if v := os.Getenv("ARCHIVE_RETENTION_DAYS"); v != "" {
+		if retentionDays, err = strconv.Atoi(v); err != nil || retentionDays < 0 {
+			log.Fatalf("ARCHIVE_RETENTION_DAYS must be a whole number of days, got %q", v)
+		}
+	}
+	if retentionDays > 0 {
+		retention := &api.Retention{Pool: pool, Store: media, Logger: logger, After: time.Duration(retentionDays) * 24 * time.Hour}
+		go retention.Run(time.Hour)
+	} else {
+		logger.Warn("ARCHIVE_RETENTION_DAYS is 0, archived records are kept forever")
+	}
+
+	thumbnails := api.NewThumbnailer(pool, media, logger)
+	go thumbnails.Run(2, time.Minute)
+
+	// resized covers for /img are kept in IMAGE_CACHE_DIR up to IMAGE_CACHE_MB, made by IMAGE_WORKERS workers
+	imageCacheDir := os.Getenv("IMAGE_CACHE_DIR")
+	if imageCacheDir == "" {
+		imageCacheDir = "./image_cache"
+	}
+	imageCacheMB := 512
+	if v := os.Getenv("IMAGE_CACHE_MB"); v != "" {
+		if imageCacheMB, err = strconv.Atoi(v); err != nil || imageCacheMB < 1 {
+			log.Fatalf("IMAGE_CACHE_MB must be a whole number of megabytes, got %q", v)
+		}
+	}
+	imageWorkers := runtime.NumCPU()
+	if v := os.Getenv("IMAGE_WORKERS"); v != "" {
+		if imageWorkers, err = strconv.Atoi(v); err != nil || imageWorkers < 1 {
+			log.Fatalf("IMAGE_WORKERS must be a positive number, got %q", v)
+		}
+	}
+	imageCache, err := diskcache.Open(imageCacheDir, int64(imageCacheMB)<<20)
+	if err != nil {
+		log.Fatalf("Unable to open the image cache in %s: %+v", imageCacheDir, err)
+	}
+	variants := api.NewImageVariants(media, imageCache, logger, 4*imageWorkers)
+	go variants.Run(imageWorkers)
+
 	app := &api.App{
-		DB:     pool,
-		Logger: logger,
-	}
-	// Public Routes
-	mux.Handle("GET /{$}", http.HandlerFunc(app.RenderHome))
-	mux.Handle("GET /register", http.HandlerFunc(app.RenderRegister))
-	mux.Handle("GET /login", http.HandlerFunc(app.RenderLogin))
-	mux.Handle("GET /products", http.HandlerFunc(app.RenderProducts))
-	mux.Handle("GET /product/{id}", http.HandlerFunc(app.RenderProduct))
-
-	// Auth APIs
-	mux.Handle("POST /api/login", http.HandlerFunc(app.LoginUser))
-	mux.Handle("POST /api/register", http.HandlerFunc(app.RegisterUser))
-
-	// Protected User API
-	mux.Handle("GET /api/product/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		http.HandlerFunc(app.ListProduct),
-	)))
-	mux.Handle("GET /api/products/{limit}/{page}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		http.HandlerFunc(app.ListProducts),
-	)))
-
-	// Admin-only Routes
-	mux.Handle("GET /api/admin/listusers/{limit}/{page}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		app.IsAdminJwtVerifierMW(
-			http.HandlerFunc(app.ListUsers),
-		),
-	)))
-	mux.Handle("GET /api/admin/listuser/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		app.IsAdminJwtVerifierMW(
-			http.HandlerFunc(app.ListSingleUser),
-		),
-	)))
-	mux.Handle("POST /api/admin/deleteuser/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		app.IsAdminJwtVerifierMW(
-			http.HandlerFunc(app.DeleteUser),
-		),
-	)))
-	mux.Handle("POST /api/admin/deleteproduct/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		app.IsAdminJwtVerifierMW(
-			http.HandlerFunc(app.DeleteProduct),
-		),
-	)))
-	mux.Handle("POST /api/admin/addproduct", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
-		app.IsAdminJwtVerifierMW(
-			http.HandlerFunc(app.AddNewProduct),
-		),
-	)))
-
-	// mux.HandleFunc("GET /api/admin/listusers/{limit}/{page}", app.ListUsers)
-	// mux.HandleFunc("GET /api/admin/listuser/{id}", app.ListSingleUser)
-	// mux.HandleFunc("POST /api/admin/deleteuser/{id}", app.DeleteUser)
-	// mux.HandleFunc("POST /api/admin/deleteproduct/{id}", app.DeleteProduct)
-	// mux.HandleFunc("POST /api/admin/addproduct", app.AddNewProduct)
+		DB:                     pool,
+		Logger:                 logger,
+		CookieSecret:           cookieSecret,
+		Payments:               payments,
+		DefaultPaymentProvider: defaultProvider,
+		Revocations:            revocations,
+		Keys:                   keyProvider,
+		Limiter:                limiter,
+		ResetLimiter:           resetLimiter,
+		PasswordResets:         make(chan string, 100),
+		Mailer:                 mailer,
+		BaseURL:                baseURL,
+		Imports:                api.NewImportJobs(),
+		Media:                  media,
+		Thumbnails:             thumbnails,
+		Variants:               variants,
+	}
+	go app.RunPasswordResets(2)
+	mux := routes(app)
 
 	log.Print("Starting Server")
 	err = http.ListenAndServe(":5050", mux)
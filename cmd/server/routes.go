@@ -0,0 +1,262 @@
+package main
+
+import (
+	"lapbytes/internal/api"
+	"net/http"
+)
+
+// routes maps every path the server answers to its handler
+func routes(app *api.App) *http.ServeMux {
+	mux := http.NewServeMux()
+	staticDir := "./static"
+	fileServer := http.FileServer(http.Dir(staticDir))
+	mux.Handle("/static/", http.StripPrefix("/static/", fileServer))
+
+	// Public Routes
+	mux.Handle("GET /{$}", http.HandlerFunc(app.RenderHome))
+	mux.Handle("GET /register", http.HandlerFunc(app.RenderRegister))
+	mux.Handle("GET /login", http.HandlerFunc(app.RenderLogin))
+	mux.Handle("GET "+api.ResetPasswordPath, http.HandlerFunc(app.RenderResetPassword))
+	mux.Handle("GET /products", http.HandlerFunc(app.RenderProducts))
+	mux.Handle("GET /product/{id}", http.HandlerFunc(app.RenderProduct))
+	mux.Handle("GET /media/{key...}", http.HandlerFunc(app.ServeMedia))
+	mux.Handle("GET /img/{id}/{variant}", http.HandlerFunc(app.ServeImageVariant))
+
+	// Auth APIs
+	mux.Handle("GET /.well-known/jwks.json", http.HandlerFunc(app.JWKS))
+	mux.Handle("POST /api/login", http.HandlerFunc(app.LoginUser))
+	mux.Handle("POST /api/login/totp", app.ReqLoggingMW(
+		http.HandlerFunc(app.LoginTotp),
+	))
+	mux.Handle("POST /api/register", http.HandlerFunc(app.RegisterUser))
+	mux.Handle("POST /api/token/refresh", app.ReqLoggingMW(
+		http.HandlerFunc(app.RefreshToken),
+	))
+	mux.Handle("GET /api/verify-email", app.ReqLoggingMW(
+		http.HandlerFunc(app.VerifyEmail),
+	))
+	mux.Handle("POST /api/verify-email/resend", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.ResendVerification),
+	)))
+	mux.Handle("POST /api/password/forgot", app.ReqLoggingMW(
+		http.HandlerFunc(app.ForgotPassword),
+	))
+	mux.Handle("POST /api/password/reset", app.ReqLoggingMW(
+		http.HandlerFunc(app.ResetPassword),
+	))
+	mux.Handle("POST /api/logout", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.Logout),
+	)))
+	mux.Handle("POST /api/logout-all", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.LogoutAll),
+	)))
+	mux.Handle("POST /api/account/totp/setup", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.SetupTotp),
+	)))
+	mux.Handle("POST /api/account/totp/enable", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.EnableTotp),
+	)))
+	mux.Handle("POST /api/account/totp/disable", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.DisableTotp),
+	)))
+
+	// Public Catalog APIs
+	mux.Handle("GET /api/products/search", app.ReqLoggingMW(
+		http.HandlerFunc(app.SearchProducts),
+	))
+	mux.Handle("GET /api/products/search/text", app.ReqLoggingMW(
+		http.HandlerFunc(app.TextSearchProducts),
+	))
+	mux.Handle("GET /api/products/facets", app.ReqLoggingMW(
+		http.HandlerFunc(app.ListProductFacets),
+	))
+
+	// Protected User API
+	mux.Handle("GET /api/product/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.ListProduct),
+	)))
+	mux.Handle("GET /api/products/{limit}/{page}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.ListProducts),
+	)))
+
+	// Cart API
+	mux.Handle("GET /api/cart", app.ReqLoggingMW(app.OptionalJwtVerifierMW(
+		http.HandlerFunc(app.GetCart),
+	)))
+	mux.Handle("POST /api/cart", app.ReqLoggingMW(app.OptionalJwtVerifierMW(
+		http.HandlerFunc(app.AddToCart),
+	)))
+	mux.Handle("PUT /api/cart/{product_id}", app.ReqLoggingMW(app.OptionalJwtVerifierMW(
+		http.HandlerFunc(app.UpdateCartItem),
+	)))
+	mux.Handle("DELETE /api/cart/{product_id}", app.ReqLoggingMW(app.OptionalJwtVerifierMW(
+		http.HandlerFunc(app.RemoveFromCart),
+	)))
+
+	// Checkout / Orders API
+	mux.Handle("POST /api/checkout", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.Checkout),
+	)))
+	mux.Handle("GET /api/orders", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.ListMyOrders),
+	)))
+	mux.Handle("GET /api/orders/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.GetMyOrder),
+	)))
+
+	// Payments API
+	mux.Handle("POST /api/orders/{id}/pay", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.PayOrder),
+	)))
+	mux.Handle("GET /api/orders/{id}/payment", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		http.HandlerFunc(app.GetOrderPayment),
+	)))
+	// Called by the payment gateways, verified by each provider
+	mux.Handle("POST /api/payments/{provider}/callback", app.ReqLoggingMW(
+		http.HandlerFunc(app.PaymentCallback),
+	))
+
+	// Admin-only Routes
+	mux.Handle("GET /api/admin/listusers/{limit}/{page}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersRead)(
+			http.HandlerFunc(app.ListUsers),
+		)),
+	)))
+	mux.Handle("GET /api/admin/listuser/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersRead)(
+			http.HandlerFunc(app.ListSingleUser),
+		)),
+	)))
+	mux.Handle("POST /api/admin/deleteuser/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersDelete)(
+			http.HandlerFunc(app.DeleteUser),
+		)),
+	)))
+	mux.Handle("POST /api/admin/users/{id}/logout", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersManage)(
+			http.HandlerFunc(app.AdminForceLogout),
+		)),
+	)))
+	mux.Handle("POST /api/admin/users/{id}/unlock", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersManage)(
+			http.HandlerFunc(app.AdminUnlockUser),
+		)),
+	)))
+	mux.Handle("GET /api/admin/users/search", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersRead)(
+			http.HandlerFunc(app.AdminSearchUsers),
+		)),
+	)))
+	mux.Handle("POST /api/admin/users/{id}/access-level", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersRoles)(
+			http.HandlerFunc(app.AdminSetAccessLevel),
+		)),
+	)))
+	mux.Handle("POST /api/admin/users/{id}/suspend", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersSuspend)(
+			http.HandlerFunc(app.AdminSuspendUser),
+		)),
+	)))
+	mux.Handle("POST /api/admin/users/{id}/reactivate", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersSuspend)(
+			http.HandlerFunc(app.AdminReactivateUser),
+		)),
+	)))
+	mux.Handle("POST /api/admin/deleteproduct/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsDelete)(
+			http.HandlerFunc(app.DeleteProduct),
+		)),
+	)))
+	mux.Handle("PATCH /api/admin/product/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.UpdateProduct),
+		)),
+	)))
+	mux.Handle("POST /api/admin/product/{id}/images", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AdminUploadProductImages),
+		)),
+	)))
+	mux.Handle("PUT /api/admin/product/{id}/images/order", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AdminReorderProductImages),
+		)),
+	)))
+	mux.Handle("DELETE /api/admin/product/{id}/images/{image_id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AdminDeleteProductImage),
+		)),
+	)))
+	mux.Handle("POST /api/admin/addproduct", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AddNewProduct),
+		)),
+	)))
+	mux.Handle("GET /api/admin/products/export", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsExport)(
+			http.HandlerFunc(app.AdminExportProducts),
+		)),
+	)))
+	mux.Handle("POST /api/admin/products/import", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AdminImportProducts),
+		)),
+	)))
+	mux.Handle("GET /api/admin/products/import/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsWrite)(
+			http.HandlerFunc(app.AdminImportStatus),
+		)),
+	)))
+	mux.Handle("GET /api/admin/orders", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermOrdersRead)(
+			http.HandlerFunc(app.AdminListOrders),
+		)),
+	)))
+	mux.Handle("GET /api/admin/orders/{id}", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermOrdersRead)(
+			http.HandlerFunc(app.AdminGetOrder),
+		)),
+	)))
+	mux.Handle("POST /api/admin/orders/{id}/status", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermOrdersWrite)(
+			http.HandlerFunc(app.AdminUpdateOrderStatus),
+		)),
+	)))
+	mux.Handle("POST /api/admin/orders/{id}/refund", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermOrdersRefund)(
+			http.HandlerFunc(app.AdminRefundOrder),
+		)),
+	)))
+	mux.Handle("GET /api/admin/archive/products", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsDelete)(
+			http.HandlerFunc(app.AdminListArchivedProducts),
+		)),
+	)))
+	mux.Handle("POST /api/admin/archive/products/{id}/restore", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermProductsDelete)(
+			http.HandlerFunc(app.AdminRestoreProduct),
+		)),
+	)))
+	mux.Handle("GET /api/admin/archive/users", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersDelete)(
+			http.HandlerFunc(app.AdminListArchivedUsers),
+		)),
+	)))
+	mux.Handle("POST /api/admin/archive/users/{id}/restore", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermUsersDelete)(
+			http.HandlerFunc(app.AdminRestoreUser),
+		)),
+	)))
+	mux.Handle("GET /api/admin/audit", app.ReqLoggingMW(app.GeneralJwtVerifierMW(
+		app.IsAdminJwtVerifierMW(app.RequirePermission(api.PermAuditRead)(
+			http.HandlerFunc(app.AdminListAudit),
+		)),
+	)))
+
+	// mux.HandleFunc("GET /api/admin/listusers/{limit}/{page}", app.ListUsers)
+	// mux.HandleFunc("GET /api/admin/listuser/{id}", app.ListSingleUser)
+	// mux.HandleFunc("POST /api/admin/deleteuser/{id}", app.DeleteUser)
+	// mux.HandleFunc("POST /api/admin/deleteproduct/{id}", app.DeleteProduct)
+	// mux.HandleFunc("POST /api/admin/addproduct", app.AddNewProduct)
+	return mux
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"lapbytes/internal/api"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestResetPasswordLinkIsRouted(t *testing.T) {
+	mux := routes(&api.App{})
+	link, err := url.Parse(api.ResetPasswordLink("https://shop.example/", "a b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Query().Get("token") != "a b" {
+		t.Errorf("expected the token in the link, got %s", link)
+	}
+
+	if _, pattern := mux.Handler(httptest.NewRequest("GET", link.RequestURI(), nil)); pattern != "GET "+link.Path {
+		t.Errorf("expected %s to be served by its own route, got %q", link.Path, pattern)
+	}
+}
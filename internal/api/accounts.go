@@ -0,0 +1,299 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAccessLevel      = 4
+	maxSuspensionReason = 500
+)
+
+// rejectSuspended answers 403 and returns true when the user is suspended
+func (a *App) rejectSuspended(w http.ResponseWriter, r *http.Request, user model.User, handler string) bool {
+	if !user.Suspended {
+		return false
+	}
+	a.Logger.Warn("suspended user rejected",
+		"handler", handler,
+		"status", 403,
+		"userid", user.Id,
+		"ip", r.RemoteAddr,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "account suspended",
+	})
+	return true
+}
+
+// adminUserChangeError answers the errors shared by access level and suspension changes
+func (a *App) adminUserChangeError(w http.ResponseWriter, r *http.Request, err error, handler string) {
+	status, msg := http.StatusInternalServerError, "internal server error"
+	switch {
+	case errors.Is(err, queries.ErrUserNotFound):
+		status, msg = http.StatusNotFound, "user not found"
+	case errors.Is(err, queries.ErrUserOutranks):
+		status, msg = http.StatusForbidden, "user has a higher access level than you"
+	case errors.Is(err, queries.ErrLastSuperuser):
+		status, msg = http.StatusConflict, "the last active superuser can't be demoted or suspended"
+	default:
+		a.LogDatabaseError(r, "admin user change error", handler, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
+
+// adminTarget reads the user id from the path and the acting admin, rejecting admins acting on themselves
+func (a *App) adminTarget(w http.ResponseWriter, r *http.Request, handler string) (id int, admin *Principal, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		a.LogBadRequest(r, "invalid user id", handler, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid user id",
+		})
+		return 0, nil, false
+	}
+	admin, ok = principalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return 0, nil, false
+	}
+	if admin.User_id == id {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "you can't change your own account this way",
+		})
+		return 0, nil, false
+	}
+	return id, admin, true
+}
+
+// AdminSetAccessLevel promotes or demotes a user. Admins can't grant a level above their
+// own, touch users above them, or demote the last superuser (admin only)
+func (a *App) AdminSetAccessLevel(w http.ResponseWriter, r *http.Request) {
+	id, admin, ok := a.adminTarget(w, r, "adminsetaccesslevel")
+	if !ok {
+		return
+	}
+	var req struct {
+		Access_level *int `json:"access_level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Access_level == nil {
+		a.LogBadRequest(r, "missing access level", "adminsetaccesslevel", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "access_level is required",
+		})
+		return
+	}
+	level := *req.Access_level
+	if level < 0 || level > maxAccessLevel {
+		a.LogBadRequest(r, "invalid access level", "adminsetaccesslevel", fmt.Errorf("access level %d", level))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("access_level must be between 0 and %d", maxAccessLevel),
+		})
+		return
+	}
+	if level < admin.Access_level {
+		a.Logger.Warn("access level escalation refused",
+			"time", time.Now(),
+			"userid", id,
+			"adminid", admin.User_id,
+			"adminlevel", admin.Access_level,
+			"requestedlevel", level,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "you can't grant an access level above your own",
+		})
+		return
+	}
+
+	previous, err := queries.SetAccessLevel(a.DB, id, level, admin.Access_level)
+	if err != nil {
+		a.adminUserChangeError(w, r, err, "setaccesslevel")
+		return
+	}
+	if previous != level {
+		// tokens carry the access level, the next refresh picks up the new one
+		if err = a.Revocations.RevokeUser(id); err != nil {
+			a.LogDatabaseError(r, "revoke user tokens error", "revokeusertokens", err)
+		}
+	}
+	a.recordAudit(r, auditUserAccessLevel, "user", id,
+		map[string]int{"access_level": previous}, map[string]int{"access_level": level})
+	a.Logger.Info("access level changed",
+		"time", time.Now(),
+		"userid", id,
+		"adminid", admin.User_id,
+		"from", previous,
+		"to", level,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "access level updated",
+		"userid":       id,
+		"access_level": level,
+		"role":         RoleForLevel(level).Name,
+	})
+}
+
+// AdminSuspendUser blocks a user from logging in and ends all their sessions (admin only)
+func (a *App) AdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	id, admin, ok := a.adminTarget(w, r, "adminsuspenduser")
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Reason) > maxSuspensionReason {
+			a.LogBadRequest(r, "invalid suspension request", "adminsuspenduser", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("reason must be at most %d characters", maxSuspensionReason),
+			})
+			return
+		}
+	}
+	if err := queries.SetUserSuspended(a.DB, id, true, req.Reason, admin.Access_level); err != nil {
+		a.adminUserChangeError(w, r, err, "setusersuspended")
+		return
+	}
+	a.recordAudit(r, auditUserSuspend, "user", id,
+		map[string]bool{"suspended": false}, map[string]interface{}{"suspended": true, "reason": req.Reason})
+	// revoking every token is what makes GeneralJwtVerifierMW reject the suspended user
+	sessions, err := a.logoutEverywhere(id)
+	if err != nil {
+		a.LogDatabaseError(r, "logout suspended user error", "revokeusersessions", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "user suspended but their sessions could not be ended, retry",
+		})
+		return
+	}
+	a.Logger.Info("user suspended",
+		"time", time.Now(),
+		"userid", id,
+		"adminid", admin.User_id,
+		"reason", req.Reason,
+		"sessions", sessions,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "user suspended",
+		"userid":   id,
+		"sessions": sessions,
+	})
+}
+
+// AdminReactivateUser lifts a suspension, the user can log in again (admin only)
+func (a *App) AdminReactivateUser(w http.ResponseWriter, r *http.Request) {
+	id, admin, ok := a.adminTarget(w, r, "adminreactivateuser")
+	if !ok {
+		return
+	}
+	if err := queries.SetUserSuspended(a.DB, id, false, "", admin.Access_level); err != nil {
+		a.adminUserChangeError(w, r, err, "setusersuspended")
+		return
+	}
+	a.recordAudit(r, auditUserReactivate, "user", id,
+		map[string]bool{"suspended": true}, map[string]bool{"suspended": false})
+	a.Logger.Info("user reactivated",
+		"time", time.Now(),
+		"userid", id,
+		"adminid", admin.User_id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "user reactivated",
+		"userid":  id,
+	})
+}
+
+// AdminSearchUsers finds users by email or username, optionally by access level and suspension (admin only)
+func (a *App) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	search := queries.UserSearch{Query: q.Get("q")}
+	badRequest := func(msg string, err error) {
+		a.LogBadRequest(r, msg, "adminsearchusers", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+	}
+	if len(search.Query) > 320 {
+		badRequest("search query is too long", fmt.Errorf("query of %d bytes", len(search.Query)))
+		return
+	}
+	if v := q.Get("access_level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 0 || level > maxAccessLevel {
+			badRequest("invalid access_level", err)
+			return
+		}
+		search.Access_level = &level
+	}
+	if v := q.Get("suspended"); v != "" {
+		suspended, err := strconv.ParseBool(v)
+		if err != nil {
+			badRequest("invalid suspended, use true or false", err)
+			return
+		}
+		search.Suspended = &suspended
+	}
+	limit, offset, err := parsePaging(q)
+	if err != nil {
+		badRequest(err.Error(), err)
+		return
+	}
+
+	users, total, err := queries.SearchUsers(a.DB, search, limit, offset)
+	if err != nil {
+		a.LogDatabaseError(r, "search users query error", "searchusers", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": total,
+		"page":  offset/limit + 1,
+		"limit": limit,
+	})
+}
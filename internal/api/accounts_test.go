@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"lapbytes/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminRequest(method, target, id, body string, principal *Principal) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.SetPathValue("id", id)
+	if principal != nil {
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, principal))
+	}
+	return req
+}
+
+func TestAdminSetAccessLevelValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 1, Mfa: true}
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		principal      *Principal
+		expectedStatus int
+	}{
+		{"invalid id", "abc", `{"access_level":2}`, admin, http.StatusBadRequest},
+		{"no principal", "5", `{"access_level":2}`, nil, http.StatusUnauthorized},
+		{"own account", "1", `{"access_level":2}`, admin, http.StatusForbidden},
+		{"missing level", "5", `{}`, admin, http.StatusBadRequest},
+		{"level out of range", "5", `{"access_level":7}`, admin, http.StatusBadRequest},
+		{"escalation above own level", "5", `{"access_level":0}`, admin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.AdminSetAccessLevel(w, adminRequest("POST", "/api/admin/users/"+tt.id+"/access-level", tt.id, tt.body, tt.principal))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminSuspendUserValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 0, Mfa: true}
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		expectedStatus int
+	}{
+		{"own account", "1", ``, http.StatusForbidden},
+		{"reason too long", "5", `{"reason":"` + strings.Repeat("x", maxSuspensionReason+1) + `"}`, http.StatusBadRequest},
+		{"malformed body", "5", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.AdminSuspendUser(w, adminRequest("POST", "/api/admin/users/"+tt.id+"/suspend", tt.id, tt.body, admin))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminSearchUsersValidation(t *testing.T) {
+	tests := []string{
+		"?access_level=9",
+		"?access_level=admin",
+		"?suspended=maybe",
+		"?limit=0",
+		"?limit=500",
+		"?page=0",
+		"?q=" + strings.Repeat("a", 321),
+	}
+	for _, query := range tests {
+		app := setupTestApp()
+		w := httptest.NewRecorder()
+
+		app.AdminSearchUsers(w, httptest.NewRequest("GET", "/api/admin/users/search"+query, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", query[:min(len(query), 20)], w.Code)
+		}
+	}
+}
+
+func TestRejectSuspended(t *testing.T) {
+	app := setupTestApp()
+	w := httptest.NewRecorder()
+	if app.rejectSuspended(w, httptest.NewRequest("POST", "/api/login", nil), model.User{Id: 3}, "loginuser") {
+		t.Error("expected an active user to pass")
+	}
+	if !app.rejectSuspended(w, httptest.NewRequest("POST", "/api/login", nil), model.User{Id: 3, Suspended: true}, "loginuser") {
+		t.Error("expected a suspended user to be rejected")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 but got %d", w.Code)
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"lapbytes/internal/storage"
+	"lapbytes/internal/store/queries"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Retention purges archived products and users once they have been archived for After
+// and nothing references them any more, along with the image files of the products
+type Retention struct {
+	Pool   *pgxpool.Pool
+	Store  storage.Store
+	Logger *slog.Logger
+	After  time.Duration
+}
+
+// Purge deletes what was archived before now minus the retention period
+func (r *Retention) Purge(now time.Time) (products int64, users int64, err error) {
+	cutoff := now.Add(-r.After)
+	products, imageKeys, err := queries.PurgeArchivedLaptops(r.Pool, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, key := range imageKeys { //the rows are gone, so a file left behind here would never be cleaned up
+		deleteImageFiles(r.Store, r.Logger, key)
+	}
+	if users, err = queries.PurgeArchivedUsers(r.Pool, cutoff); err != nil {
+		return products, 0, err
+	}
+	return products, users, nil
+}
+
+// Run purges every interval; it never returns
+func (r *Retention) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		products, users, err := r.Purge(time.Now())
+		if err != nil {
+			r.Logger.Error("archive purge failed", "error", err)
+		}
+		if products > 0 || users > 0 {
+			r.Logger.Info("purged archived records", "products", products, "users", users)
+		}
+	}
+}
+
+// AdminListArchivedProducts returns deleted laptops that can still be restored (admin only)
+func (a *App) AdminListArchivedProducts(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePaging(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid paging", "adminlistarchivedproducts", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	products, total, err := queries.ListArchivedLaptops(a.DB, limit, offset)
+	if err != nil {
+		a.LogDatabaseError(r, "list archived laptops query error", "listarchivedlaptops", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"products": products,
+		"total":    total,
+		"page":     offset/limit + 1,
+		"limit":    limit,
+	})
+}
+
+// AdminRestoreProduct puts a deleted laptop back in the catalog (admin only)
+func (a *App) AdminRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productId < 1 {
+		a.LogBadRequest(r, "invalid id", "adminrestoreproduct", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid id",
+		})
+		return
+	}
+	product, err := queries.RestoreLaptop(a.DB, productId)
+	if err != nil {
+		a.writeRestoreError(w, r, "restorelaptop", err)
+		return
+	}
+	a.recordAudit(r, auditProductRestore, "product", productId, nil, product)
+	a.Logger.Info("product restored",
+		"time", time.Now(),
+		"id", productId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(product.Version))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(product)
+}
+
+// AdminListArchivedUsers returns deleted users that can still be restored (admin only)
+func (a *App) AdminListArchivedUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePaging(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid paging", "adminlistarchivedusers", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	users, total, err := queries.ListArchivedUsers(a.DB, limit, offset)
+	if err != nil {
+		a.LogDatabaseError(r, "list archived users query error", "listarchivedusers", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": total,
+		"page":  offset/limit + 1,
+		"limit": limit,
+	})
+}
+
+// AdminRestoreUser brings back a deleted user, who then logs in as before (admin only)
+func (a *App) AdminRestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		a.LogBadRequest(r, "invalid user id", "adminrestoreuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid user id",
+		})
+		return
+	}
+	if err = queries.RestoreUser(a.DB, id); err != nil {
+		a.writeRestoreError(w, r, "restoreuser", err)
+		return
+	}
+	var after any
+	if user, err := queries.GetUser(a.DB, id); err == nil {
+		after = userSummary(user)
+	}
+	a.recordAudit(r, auditUserRestore, "user", id, nil, after)
+	a.Logger.Info("user restored",
+		"time", time.Now(),
+		"userid", id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "user restored",
+		"userid":  id,
+	})
+}
+
+// writeRestoreError maps restore errors to responses
+func (a *App) writeRestoreError(w http.ResponseWriter, r *http.Request, query string, err error) {
+	status, msg := http.StatusInternalServerError, "internal server error"
+	switch {
+	case errors.Is(err, queries.ErrProductNotFound), errors.Is(err, queries.ErrUserNotFound):
+		status, msg = http.StatusNotFound, err.Error()
+	case errors.Is(err, queries.ErrNotArchived):
+		status, msg = http.StatusConflict, err.Error()
+	case errors.Is(err, queries.ErrDuplicateProduct), errors.Is(err, queries.ErrDuplicateSku):
+		status, msg = http.StatusConflict, err.Error()
+	default:
+		a.LogDatabaseError(r, "restore query error", query, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
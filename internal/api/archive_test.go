@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestArchiveHandlersValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 0, Mfa: true}
+	tests := []struct {
+		name    string
+		handler func(*App) http.HandlerFunc
+		target  string
+		id      string
+	}{
+		{"products bad limit", func(a *App) http.HandlerFunc { return a.AdminListArchivedProducts }, "/api/admin/archive/products?limit=0", ""},
+		{"products bad page", func(a *App) http.HandlerFunc { return a.AdminListArchivedProducts }, "/api/admin/archive/products?page=x", ""},
+		{"users bad limit", func(a *App) http.HandlerFunc { return a.AdminListArchivedUsers }, "/api/admin/archive/users?limit=500", ""},
+		{"restore product bad id", func(a *App) http.HandlerFunc { return a.AdminRestoreProduct }, "/api/admin/archive/products/x/restore", "x"},
+		{"restore user bad id", func(a *App) http.HandlerFunc { return a.AdminRestoreUser }, "/api/admin/archive/users/0/restore", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			tt.handler(app)(w, adminRequest("GET", tt.target, tt.id, "", admin))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d but got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestWriteRestoreError(t *testing.T) {
+	tests := []struct {
+		err            error
+		expectedStatus int
+	}{
+		{queries.ErrProductNotFound, http.StatusNotFound},
+		{queries.ErrUserNotFound, http.StatusNotFound},
+		{queries.ErrNotArchived, http.StatusConflict},
+		{queries.ErrDuplicateProduct, http.StatusConflict},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.writeRestoreError(w, httptest.NewRequest("POST", "/", nil), "restorelaptop", tt.err)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"lapbytes/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminListAuditValidation(t *testing.T) {
+	tests := []string{
+		"?actor_id=abc",
+		"?actor_id=0",
+		"?since=yesterday",
+		"?until=2026-01-02",
+		"?limit=0",
+		"?page=-1",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.AdminListAudit(w, httptest.NewRequest("GET", "/api/admin/audit"+query, nil))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d but got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestUserSummaryOmitsCredentials(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := model.User{
+		Id:            7,
+		Username:      "alice",
+		Email:         "alice@example.com",
+		Password_hash: "$2a$08$hash",
+		Access_level:  1,
+		Totp_enabled:  true,
+		Created_at:    created,
+	}
+
+	got := userSummary(user)
+
+	want := model.UserSummary{
+		Id:           7,
+		Username:     "alice",
+		Email:        "alice@example.com",
+		Access_level: 1,
+		Totp_enabled: true,
+		Created_at:   created,
+	}
+	if got != want {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+}
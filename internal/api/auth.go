@@ -1,48 +1,58 @@
 package api
 
 import (
-	"crypto/rsa"
-	"log"
-	"os"
+	"encoding/json"
+	"lapbytes/internal/model"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-type JwtClaims struct {
-	jwt.RegisteredClaims
-	Access_level int `json:"access_level,omitempty"`
-}
-
-var (
-	privateKey *rsa.PrivateKey
+const (
+	tokenIssuer         = "lapbytes"
+	tokenAudience       = "lapbytes-api"
+	accessTokenLifetime = time.Hour
 )
 
-func InitKeys() {
-	privateKeyData, err := os.ReadFile("./private_key.pem")
-	if err != nil {
-		log.Fatal(err)
-	}
-	privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privateKeyData)
-	if err != nil {
-		log.Fatal(err)
-	}
+type JwtClaims struct {
+	jwt.RegisteredClaims
+	Access_level int `json:"accesslevel"`
+	// Mfa is set when the login behind the token passed a second factor
+	Mfa bool `json:"mfa,omitempty"`
 }
 
-func IssueKeys() (jwtToken string, err error) {
-
-	claims := &JwtClaims{
+// newAccessClaims builds the claims of an access token for a user
+func newAccessClaims(userId int, accessLevel int) *JwtClaims {
+	now := time.Now()
+	return &JwtClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   "authentication",
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(userId),
+			Audience:  jwt.ClaimStrings{tokenAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenLifetime)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
 		},
-		Access_level: 4, //work to do
+		Access_level: accessLevel,
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	t, err := token.SignedString(privateKey)
-	if err != nil {
-		return "", err
-	}
-	return t, nil
+// IssueKeys signs an access token carrying the user's id and access level with the active key,
+// mfa records whether the login passed two-factor authentication
+func (a *App) IssueKeys(user model.User, mfa bool) (jwtToken string, err error) {
+	claims := newAccessClaims(user.Id, user.Access_level)
+	claims.Mfa = mfa
+	return a.Keys.Sign(claims)
+}
+
+// JWKS publishes the public keys access tokens can be verified with
+func (a *App) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Keys.JWKS())
 }
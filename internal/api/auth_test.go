@@ -4,8 +4,14 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"lapbytes/internal/keys"
+	"lapbytes/internal/model"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -58,56 +64,6 @@ func createTestKeyFiles(t *testing.T) (string, string) {
 	return privateKeyFile.Name(), publicKeyFile.Name()
 }
 
-func TestInitKeys(t *testing.T) {
-	tests := []struct {
-		name         string
-		setupKeyFile func(t *testing.T) string
-		expectError  bool
-	}{
-		{
-			name: "valid private key file",
-			setupKeyFile: func(t *testing.T) string {
-				privateKeyPath, _ := createTestKeyFiles(t)
-				return privateKeyPath
-			},
-			expectError: false,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			originalPrivateKey := privateKey
-			privateKey = nil
-			defer func() { privateKey = originalPrivateKey }()
-
-			keyFile := tt.setupKeyFile(t)
-			defer os.Remove(keyFile)
-
-			originalWD, _ := os.Getwd()
-			defer os.Chdir(originalWD)
-
-			defer os.Remove("./private_key.pem")
-			err := os.Link(keyFile, "./private_key.pem")
-			if err != nil {
-				data, readErr := os.ReadFile(keyFile)
-				if readErr != nil {
-					t.Fatalf("failed to read key file: %v", readErr)
-				}
-				writeErr := os.WriteFile("./private_key.pem", data, 0600)
-				if writeErr != nil {
-					t.Fatalf("failed to write key file: %v", writeErr)
-				}
-			}
-
-			InitKeys()
-
-			if privateKey == nil {
-				t.Error("expected privateKey to be set but it was nil")
-			}
-		})
-	}
-}
-
 func TestIssueKeys(t *testing.T) {
 	privateKeyPath, _ := createTestKeyFiles(t)
 	defer os.Remove(privateKeyPath)
@@ -116,103 +72,59 @@ func TestIssueKeys(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to read test private key: %v", err)
 	}
-
-	testPrivateKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	key, err := keys.ParsePEM("2026-01", keyData)
 	if err != nil {
 		t.Fatalf("failed to parse test private key: %v", err)
 	}
-
-	tests := []struct {
-		name        string
-		setupKey    func()
-		expectError bool
-	}{
-		{
-			name: "valid private key",
-			setupKey: func() {
-				privateKey = testPrivateKey
-			},
-			expectError: false,
-		},
-		{
-			name: "nil private key",
-			setupKey: func() {
-				privateKey = nil
-			},
-			expectError: true,
-		},
+	provider, err := keys.New("", key)
+	if err != nil {
+		t.Fatalf("failed to create key provider: %v", err)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			originalPrivateKey := privateKey
-			defer func() { privateKey = originalPrivateKey }()
-
-			tt.setupKey()
-
-			var token string
-			var err error
+	app := setupTestApp()
+	app.Keys = provider
+	token, err := app.IssueKeys(model.User{Id: 12, Access_level: 1}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-			if tt.expectError && tt.name == "nil private key" {
-				defer func() {
-					if r := recover(); r == nil {
-						t.Error("expected panic but didn't get one")
-					}
-				}()
-			}
+	parsedToken, err := jwt.ParseWithClaims(token, &JwtClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return key.Public, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to parse issued token: %v", err)
+	}
+	if kid := parsedToken.Header["kid"]; kid != "2026-01" {
+		t.Errorf("expected kid header '2026-01' but got %v", kid)
+	}
 
-			token, err = IssueKeys()
-
-			if tt.expectError {
-				if err == nil {
-					t.Error("expected error but got nil")
-				}
-				if token != "" {
-					t.Errorf("expected empty token but got %s", token)
-				}
-			} else {
-				if err != nil {
-					t.Errorf("unexpected error: %v", err)
-				}
-				if token == "" {
-					t.Error("expected token but got empty string")
-				}
-
-				parsedToken, parseErr := jwt.ParseWithClaims(token, &JwtClaims{}, func(token *jwt.Token) (interface{}, error) {
-					return &testPrivateKey.PublicKey, nil
-				})
-
-				if parseErr != nil {
-					t.Errorf("failed to parse issued token: %v", parseErr)
-				}
-
-				if !parsedToken.Valid {
-					t.Error("issued token is not valid")
-				}
-
-				claims, ok := parsedToken.Claims.(*JwtClaims)
-				if !ok {
-					t.Error("failed to extract claims from token")
-				} else {
-					if claims.Access_level != 4 {
-						t.Errorf("expected access level 4 but got %d", claims.Access_level)
-					}
-					if claims.Subject != "authentication" {
-						t.Errorf("expected subject 'authentication' but got '%s'", claims.Subject)
-					}
-					if claims.ExpiresAt == nil {
-						t.Error("expected expiration time but got nil")
-					} else {
-						expectedExpiry := time.Now().Add(time.Hour)
-						actualExpiry := claims.ExpiresAt.Time
-						timeDiff := actualExpiry.Sub(expectedExpiry)
-						if timeDiff < -time.Minute || timeDiff > time.Minute {
-							t.Errorf("expected expiry around %v but got %v", expectedExpiry, actualExpiry)
-						}
-					}
-				}
-			}
-		})
+	claims, ok := parsedToken.Claims.(*JwtClaims)
+	if !ok {
+		t.Fatal("failed to extract claims from token")
+	}
+	if claims.Access_level != 1 {
+		t.Errorf("expected access level 1 but got %d", claims.Access_level)
+	}
+	if !claims.Mfa {
+		t.Error("expected mfa claim to be set")
+	}
+	if claims.Subject != "12" {
+		t.Errorf("expected subject '12' but got '%s'", claims.Subject)
+	}
+	if claims.Issuer != tokenIssuer || len(claims.Audience) != 1 || claims.Audience[0] != tokenAudience {
+		t.Errorf("unexpected issuer %q or audience %v", claims.Issuer, claims.Audience)
+	}
+	if claims.ID == "" || claims.IssuedAt == nil || claims.NotBefore == nil {
+		t.Error("expected jti, iat and nbf to be set")
+	}
+	if claims.ExpiresAt == nil {
+		t.Error("expected expiration time but got nil")
+	} else {
+		expectedExpiry := time.Now().Add(time.Hour)
+		timeDiff := claims.ExpiresAt.Time.Sub(expectedExpiry)
+		if timeDiff < -time.Minute || timeDiff > time.Minute {
+			t.Errorf("expected expiry around %v but got %v", expectedExpiry, claims.ExpiresAt.Time)
+		}
 	}
 }
 
@@ -271,6 +183,13 @@ func TestAuthJwtClaims(t *testing.T) {
 	}
 }
 
+func TestIssueKeysUniqueTokenIds(t *testing.T) {
+	a, b := newAccessClaims(1, 4), newAccessClaims(1, 4)
+	if a.ID == b.ID {
+		t.Errorf("expected unique jti values but both were %q", a.ID)
+	}
+}
+
 func TestAuthJwtClaimsJSON(t *testing.T) {
 	claims := &JwtClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -308,64 +227,64 @@ func TestAuthJwtClaimsJSON(t *testing.T) {
 
 // Test edge cases and integration scenarios
 func TestAuthIntegration(t *testing.T) {
-	privateKeyPath, publicKeyPath := createTestKeyFiles(t)
+	privateKeyPath, _ := createTestKeyFiles(t)
 	defer os.Remove(privateKeyPath)
-	defer os.Remove(publicKeyPath)
 
 	privateKeyData, err := os.ReadFile(privateKeyPath)
 	if err != nil {
 		t.Fatalf("failed to read private key: %v", err)
 	}
-
-	publicKeyData, err := os.ReadFile(publicKeyPath)
-	if err != nil {
-		t.Fatalf("failed to read public key: %v", err)
-	}
-
-	err = os.WriteFile("./private_key.pem", privateKeyData, 0600)
-	if err != nil {
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "2026-01.pem"), privateKeyData, 0600); err != nil {
 		t.Fatalf("failed to write private key: %v", err)
 	}
-	defer os.Remove("./private_key.pem")
-
-	err = os.WriteFile("./public_key.pem", publicKeyData, 0644)
+	provider, err := keys.LoadDir(dir, "")
 	if err != nil {
-		t.Fatalf("failed to write public key: %v", err)
+		t.Fatalf("failed to load keys: %v", err)
 	}
-	defer os.Remove("./public_key.pem")
-
-	originalPrivateKey := privateKey
-	defer func() { privateKey = originalPrivateKey }()
 
-	InitKeys()
-
-	token, err := IssueKeys()
+	app := setupTestApp()
+	app.Keys = provider
+	token, err := app.IssueKeys(model.User{Id: 3, Access_level: 4}, false)
 	if err != nil {
 		t.Fatalf("failed to issue token: %v", err)
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyData)
-	if err != nil {
-		t.Fatalf("failed to parse public key: %v", err)
+	var principal *Principal
+	handler := app.GeneralJwtVerifierMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = principalFromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if principal == nil {
+		t.Fatal("token should be valid but isn't")
+	}
+	if principal.User_id != 3 || principal.Access_level != 4 || principal.Mfa {
+		t.Errorf("expected user 3 with access level 4 but got %+v", principal)
 	}
+}
 
-	parsedToken, err := jwt.ParseWithClaims(token, &JwtClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return publicKey, nil
-	})
+func TestJWKS(t *testing.T) {
+	privateKey, _, err := generateTestKeys()
 	if err != nil {
-		t.Fatalf("failed to verify token: %v", err)
+		t.Fatalf("failed to generate test keys: %v", err)
 	}
+	app := setupTestApp()
+	app.Keys = testKeyProvider(t, privateKey)
+	w := httptest.NewRecorder()
 
-	if !parsedToken.Valid {
-		t.Error("token should be valid but isn't")
-	}
+	app.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
 
-	claims, ok := parsedToken.Claims.(*JwtClaims)
-	if !ok {
-		t.Fatal("failed to extract claims")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
 	}
-
-	if claims.Access_level != 4 {
-		t.Errorf("expected access level 4 but got %d", claims.Access_level)
+	var set keys.JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("failed to decode jwks: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "test" || set.Keys[0].Kty != "RSA" {
+		t.Errorf("unexpected key set %+v", set)
 	}
 }
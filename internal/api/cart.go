@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const maxCartItemQuantity = 99
+
+type cartItemRequest struct {
+	Product_id int `json:"product_id"`
+	Quantity   int `json:"quantity"`
+}
+
+// cartIdForRequest resolves the cart of the authenticated user, or of the guest cookie for anonymous
+// requests. With create set a missing cart is created (and a guest cookie issued), otherwise
+// a cart id of 0 means the shopper has no cart yet.
+func (a *App) cartIdForRequest(w http.ResponseWriter, r *http.Request, handler string, create bool) (int, bool) {
+	var cartId int
+	var err error
+	if _, authenticated := principalFromContext(r.Context()); authenticated {
+		userId, uerr := userIdFromContext(r.Context())
+		if uerr != nil {
+			a.Logger.Error("cart access without user",
+				"handler", handler,
+				"path", r.URL.Path,
+				"error", uerr,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "not authorized",
+			})
+			return 0, false
+		}
+		cartId, err = queries.GetOrCreateUserCart(a.DB, userId)
+	} else {
+		guestId, ok := a.guestIdFromRequest(r)
+		switch {
+		case ok && create:
+			cartId, err = queries.GetOrCreateGuestCart(a.DB, guestId)
+		case ok:
+			cartId, err = queries.FindGuestCart(a.DB, guestId)
+			if errors.Is(err, queries.ErrCartNotFound) {
+				cartId, err = 0, nil
+			}
+		case create:
+			guestId = uuid.NewString()
+			if cartId, err = queries.GetOrCreateGuestCart(a.DB, guestId); err == nil {
+				a.setGuestCartCookie(w, guestId)
+			}
+		}
+	}
+	if err != nil {
+		a.LogDatabaseError(r, "cart lookup error", "cartidforrequest", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return 0, false
+	}
+	return cartId, true
+}
+
+// writeCartError maps cart store errors to responses
+func (a *App) writeCartError(w http.ResponseWriter, r *http.Request, query string, err error) {
+	status, msg := http.StatusInternalServerError, "internal server error"
+	switch {
+	case errors.Is(err, queries.ErrProductNotFound):
+		status, msg = http.StatusNotFound, "product not found"
+	case errors.Is(err, queries.ErrCartItemNotFound):
+		status, msg = http.StatusNotFound, "item not in cart"
+	case errors.Is(err, queries.ErrInsufficientStock):
+		status, msg = http.StatusConflict, "not enough stock for the requested quantity"
+	case errors.Is(err, queries.ErrCartItemLimit):
+		status, msg = http.StatusConflict, fmt.Sprintf("at most %d units of a product fit in the cart", maxCartItemQuantity)
+	default:
+		a.LogDatabaseError(r, "cart query error", query, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
+
+// writeCart responds with the current state of a cart
+func (a *App) writeCart(w http.ResponseWriter, r *http.Request, cartId int, status int) {
+	if cartId == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cart":    model.Cart{Items: []model.CartItem{}},
+			"message": "request successful",
+		})
+		return
+	}
+	cart, err := queries.GetCart(a.DB, cartId)
+	if err != nil {
+		a.writeCartError(w, r, "getcart", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cart":    cart,
+		"message": "request successful",
+	})
+}
+
+func parseCartProductId(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("product_id"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid product id %q", r.PathValue("product_id"))
+	}
+	return id, nil
+}
+
+// GetCart lists the items in the user's or guest's cart with current prices and stock
+func (a *App) GetCart(w http.ResponseWriter, r *http.Request) {
+	cartId, ok := a.cartIdForRequest(w, r, "getcart", false)
+	if !ok {
+		return
+	}
+	a.writeCart(w, r, cartId, http.StatusOK)
+}
+
+// AddToCart adds a product to the cart, starting a guest cart for anonymous shoppers
+func (a *App) AddToCart(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "addtocart", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	var item cartItemRequest
+	err := json.NewDecoder(r.Body).Decode(&item)
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	if err != nil || item.Product_id < 1 || item.Quantity < 1 || item.Quantity > maxCartItemQuantity {
+		a.LogBadRequest(r, "invalid cart item", "addtocart", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("product_id is required and quantity must be between 1 and %d", maxCartItemQuantity),
+		})
+		return
+	}
+
+	cartId, ok := a.cartIdForRequest(w, r, "addtocart", true)
+	if !ok {
+		return
+	}
+	if err = queries.AddCartItem(a.DB, cartId, item.Product_id, item.Quantity, maxCartItemQuantity); err != nil {
+		a.writeCartError(w, r, "addcartitem", err)
+		return
+	}
+	a.writeCart(w, r, cartId, http.StatusOK)
+}
+
+// UpdateCartItem sets the quantity of a product already in the cart
+func (a *App) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	productId, err := parseCartProductId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid product id", "updatecartitem", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid product id",
+		})
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "updatecartitem", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	var item cartItemRequest
+	err = json.NewDecoder(r.Body).Decode(&item)
+	if err != nil || item.Quantity < 1 || item.Quantity > maxCartItemQuantity {
+		a.LogBadRequest(r, "invalid quantity", "updatecartitem", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("quantity must be between 1 and %d", maxCartItemQuantity),
+		})
+		return
+	}
+
+	cartId, ok := a.cartIdForRequest(w, r, "updatecartitem", false)
+	if !ok {
+		return
+	}
+	if cartId == 0 {
+		a.writeCartError(w, r, "setcartitemquantity", queries.ErrCartItemNotFound)
+		return
+	}
+	if err = queries.SetCartItemQuantity(a.DB, cartId, productId, item.Quantity); err != nil {
+		a.writeCartError(w, r, "setcartitemquantity", err)
+		return
+	}
+	a.writeCart(w, r, cartId, http.StatusOK)
+}
+
+// RemoveFromCart deletes a product from the cart
+func (a *App) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	productId, err := parseCartProductId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid product id", "removefromcart", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid product id",
+		})
+		return
+	}
+	cartId, ok := a.cartIdForRequest(w, r, "removefromcart", false)
+	if !ok {
+		return
+	}
+	if cartId == 0 {
+		a.writeCartError(w, r, "removecartitem", queries.ErrCartItemNotFound)
+		return
+	}
+	if err = queries.RemoveCartItem(a.DB, cartId, productId); err != nil {
+		a.writeCartError(w, r, "removecartitem", err)
+		return
+	}
+	a.writeCart(w, r, cartId, http.StatusOK)
+}
+
+// mergeGuestCartOnLogin folds the guest cart cookie into the user's cart.
+// Failures are logged and leave the guest cart in place so login still succeeds.
+func (a *App) mergeGuestCartOnLogin(w http.ResponseWriter, r *http.Request, userId int) *model.CartMergeResult {
+	guestId, ok := a.guestIdFromRequest(r)
+	if !ok {
+		return nil
+	}
+	result, err := queries.MergeGuestCart(a.DB, guestId, userId, maxCartItemQuantity)
+	if err != nil {
+		a.LogDatabaseError(r, "guest cart merge error", "mergeguestcart", err)
+		return nil
+	}
+	clearGuestCartCookie(w)
+	a.Logger.Info("guest cart merged",
+		"userid", userId,
+		"items", len(result.Items),
+		"changed", result.Changed,
+	)
+	return &result
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserIdFromContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		expectId  int
+		expectErr bool
+	}{
+		{"authenticated user", context.WithValue(context.Background(), principalKey, &Principal{User_id: 42}), 42, false},
+		{"principal without user", context.WithValue(context.Background(), principalKey, &Principal{}), 0, true},
+		{"nil principal", context.WithValue(context.Background(), principalKey, (*Principal)(nil)), 0, true},
+		{"missing principal", context.Background(), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := userIdFromContext(tt.ctx)
+			if tt.expectErr && err == nil {
+				t.Error("expected error but got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if id != tt.expectId {
+				t.Errorf("expected id %d but got %d", tt.expectId, id)
+			}
+		})
+	}
+}
+
+func TestAddToCartInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"invalid content type", "text/plain", `{"product_id":1}`},
+		{"invalid json", "application/json", "not json"},
+		{"missing product", "application/json", `{"quantity":1}`},
+		{"negative quantity", "application/json", `{"product_id":1,"quantity":-2}`},
+		{"quantity too large", "application/json", `{"product_id":1,"quantity":1000}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/cart", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			app.AddToCart(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAddToCartTokenWithoutUserId(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/cart", strings.NewReader(`{"product_id":1,"quantity":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), principalKey, &Principal{}))
+	w := httptest.NewRecorder()
+
+	app.AddToCart(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 but got %d", w.Code)
+	}
+}
+
+func TestUpdateCartItemInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		productId string
+		body      string
+	}{
+		{"invalid product id", "abc", `{"quantity":2}`},
+		{"zero product id", "0", `{"quantity":2}`},
+		{"zero quantity", "1", `{"quantity":0}`},
+		{"invalid json", "1", "nope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("PUT", "/api/cart/"+tt.productId, strings.NewReader(tt.body))
+			req.SetPathValue("product_id", tt.productId)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			app.UpdateCartItem(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRemoveFromCartInvalidID(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("DELETE", "/api/cart/invalid", nil)
+	req.SetPathValue("product_id", "invalid")
+	w := httptest.NewRecorder()
+
+	app.RemoveFromCart(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestGetCartGuestWithoutCookie(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("GET", "/api/cart", nil)
+	w := httptest.NewRecorder()
+
+	app.GetCart(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", w.Code)
+	}
+	var response struct {
+		Cart model.Cart `json:"cart"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Cart.Items) != 0 || response.Cart.Item_count != 0 {
+		t.Errorf("expected an empty cart but got %+v", response.Cart)
+	}
+}
+
+func TestUpdateCartItemGuestWithoutCart(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("PUT", "/api/cart/1", strings.NewReader(`{"quantity":2}`))
+	req.SetPathValue("product_id", "1")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	app.UpdateCartItem(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", w.Code)
+	}
+}
+
+func TestWriteCartError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{queries.ErrProductNotFound, http.StatusNotFound},
+		{queries.ErrCartItemNotFound, http.StatusNotFound},
+		{queries.ErrInsufficientStock, http.StatusConflict},
+		{queries.ErrCartItemLimit, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		app := setupTestApp()
+		w := httptest.NewRecorder()
+
+		app.writeCartError(w, httptest.NewRequest("POST", "/api/cart", nil), "addcartitem", tt.err)
+
+		if w.Code != tt.expected {
+			t.Errorf("%v: expected status %d but got %d", tt.err, tt.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	guestCartCookie   = "guest_cart"
+	guestCartLifetime = time.Hour * 24 * 30
+)
+
+// signValue appends an HMAC-SHA256 signature to value as "value.signature"
+func signValue(secret []byte, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedValue returns the original value if the signature made by signValue is valid
+func verifySignedValue(secret []byte, signed string) (string, bool) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 1 || len(secret) == 0 {
+		return "", false
+	}
+	value := signed[:i]
+	if !hmac.Equal([]byte(signValue(secret, value)), []byte(signed)) {
+		return "", false
+	}
+	return value, true
+}
+
+// guestIdFromRequest returns the guest id of a correctly signed guest cart cookie
+func (a *App) guestIdFromRequest(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(guestCartCookie)
+	if err != nil {
+		return "", false
+	}
+	return verifySignedValue(a.CookieSecret, cookie.Value)
+}
+
+func (a *App) setGuestCartCookie(w http.ResponseWriter, guestId string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    signValue(a.CookieSecret, guestId),
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  time.Now().Add(guestCartLifetime),
+	})
+}
+
+func clearGuestCartCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    "",
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
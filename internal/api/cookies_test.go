@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignAndVerifyValue(t *testing.T) {
+	secret := []byte("test-secret")
+	signed := signValue(secret, "guest-123")
+
+	value, ok := verifySignedValue(secret, signed)
+	if !ok || value != "guest-123" {
+		t.Errorf("expected guest-123 to verify but got %q %v", value, ok)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		signed string
+	}{
+		{"tampered value", secret, "guest-124" + signed[len("guest-123"):]},
+		{"wrong secret", []byte("other-secret"), signed},
+		{"empty secret", nil, signed},
+		{"no signature", secret, "guest-123"},
+		{"empty", secret, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := verifySignedValue(tt.secret, tt.signed); ok {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestGuestIdFromRequest(t *testing.T) {
+	app := setupTestApp()
+	app.CookieSecret = []byte("test-secret")
+
+	w := httptest.NewRecorder()
+	app.setGuestCartCookie(w, "guest-abc")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one cookie but got %d", len(cookies))
+	}
+
+	req := httptest.NewRequest("GET", "/api/cart", nil)
+	req.AddCookie(cookies[0])
+	guestId, ok := app.guestIdFromRequest(req)
+	if !ok || guestId != "guest-abc" {
+		t.Errorf("expected guest-abc but got %q %v", guestId, ok)
+	}
+
+	req = httptest.NewRequest("GET", "/api/cart", nil)
+	req.AddCookie(&http.Cookie{Name: guestCartCookie, Value: "forged.value"})
+	if _, ok := app.guestIdFromRequest(req); ok {
+		t.Error("expected forged cookie to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"lapbytes/internal/model"
+	"lapbytes/internal/productexport"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"time"
+)
+
+// AdminExportProducts streams every laptop matching the search filters as CSV, Excel friendly CSV or
+// JSON lines (admin only). Rows are written as they are read, so the whole catalog is never in memory.
+func (a *App) AdminExportProducts(w http.ResponseWriter, r *http.Request) {
+	badRequest := func(err error) {
+		a.LogBadRequest(r, "invalid export parameters", "adminexportproducts", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+	}
+	q := r.URL.Query()
+	format, err := productexport.ParseFormat(q.Get("format"))
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	columns, err := productexport.Columns(q.Get("columns"))
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	filter, err := parseLaptopFilter(q)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	a.streamExport(w, r, format, columns, filter)
+}
+
+// streamExport writes the response once the first row arrives, so a failing query still gets a
+// proper error. A failure after that aborts the connection, the client sees a cut off download
+// rather than a short file that looks complete.
+func (a *App) streamExport(w http.ResponseWriter, r *http.Request, format productexport.Format, columns []productexport.Column, filter queries.LaptopFilter) {
+	out := productexport.NewWriter(w, format, columns)
+	started, count := false, 0
+	start := func() error {
+		started = true
+		fileName := "laptops-" + time.Now().UTC().Format("20060102-150405") + format.Extension()
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		return out.WriteHeader()
+	}
+
+	err := queries.EachLaptop(a.DB, filter, func(lp model.Laptop) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		count++
+		return out.Write(lp)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil && !started {
+		a.LogDatabaseError(r, "export laptops query error", "eachlaptop", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if err != nil {
+		a.Logger.Error("product export aborted",
+			"error", err,
+			"rows", count,
+		)
+		panic(http.ErrAbortHandler)
+	}
+	actorId, _ := userIdFromContext(r.Context())
+	a.Logger.Info("products exported",
+		"time", time.Now(),
+		"format", format,
+		"rows", count,
+		"adminid", actorId,
+	)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminExportProductsValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 1, Mfa: true}
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"unknown format", "/api/admin/products/export?format=pdf"},
+		{"unknown column", "/api/admin/products/export?columns=sku,colour"},
+		{"repeated column", "/api/admin/products/export?columns=sku,sku"},
+		{"bad filter", "/api/admin/products/export?min_price=cheap"},
+		{"inverted range", "/api/admin/products/export?min_ram=32&max_ram=8"},
+		{"bad sort", "/api/admin/products/export?sort=random"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.AdminExportProducts(w, adminRequest("GET", tt.target, "", "", admin))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d but got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
@@ -2,23 +2,51 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"lapbytes/internal/keys"
+	"lapbytes/internal/lockout"
+	"lapbytes/internal/mail"
 	"lapbytes/internal/model"
+	"lapbytes/internal/payment"
+	"lapbytes/internal/productimport"
+	"lapbytes/internal/storage"
 	"lapbytes/internal/store/queries"
 	"log"
 	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type App struct {
-	DB     *pgxpool.Pool
-	Logger *slog.Logger
+	DB           *pgxpool.Pool
+	Logger       *slog.Logger
+	CookieSecret []byte //HMAC key for signed cookies such as the guest cart
+
+	Payments               map[string]payment.Provider //keyed by provider name
+	DefaultPaymentProvider string
+
+	Revocations *RevocationList
+	Keys        *keys.Provider   //access token signing and verification keys
+	Limiter     *lockout.Limiter //failed login throttling
+
+	ResetLimiter   *lockout.Limiter //password reset request throttling
+	PasswordResets chan string      //emails waiting for RunPasswordResets, requests are dropped when full
+
+	Mailer  mail.Sender
+	BaseURL string //public address used in emailed links
+
+	Imports *ImportJobs //bulk product imports running on this instance
+
+	Media      storage.Store //uploaded product images
+	Thumbnails *Thumbnailer
+	Variants   *ImageVariants //resized covers for /img
 }
 
 // RenderHome serves the homepage template
@@ -77,6 +105,26 @@ func (a *App) RenderLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RenderResetPassword serves the page the password reset email links to, see ResetPasswordLink
+func (a *App) RenderResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer") //the URL carries the reset token
+
+	tmpl, err := template.ParseFiles("templates/reset-password.gohtml")
+	if err != nil {
+		a.LogInternalServerError(r, "template parsing", "renderresetpassword", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, map[string]int{"MinLength": minPasswordLength, "MaxLength": maxPasswordLength})
+	if err != nil {
+		a.LogTemplateError("renderresetpassword", "reset-password.gohtml", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
 // RenderProducts serves the products listing page
 func (a *App) RenderProducts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -141,13 +189,31 @@ func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passwordhash, err := queries.GetUserHash(a.DB, userRequest.Email)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if wait := a.loginBackoff(r, userRequest.Email, host); wait > 0 {
+		a.Logger.Warn("login throttled",
+			"handler", "loginuser",
+			"status", 429,
+			"ip", host,
+			"retryafter", wait,
+		)
+		tooManyLoginAttempts(w, wait)
+		return
+	}
+
+	user, err := queries.GetUserHash(a.DB, userRequest.Email)
 	if err != nil {
+		verifyPasswordHash(userRequest.Password, dummyPasswordHash())
+		a.recordLoginFailure(r, userRequest.Email, host)
 		a.Logger.Error("invalid credentials",
 			"handler", "loginuser",
 			"path", r.URL.Path,
 			"method", r.Method,
 			"status", 401,
+			"ip", host,
 			"error", fmt.Errorf("user does not exist"),
 		)
 		w.Header().Set("Content-Type", "application/json")
@@ -159,14 +225,9 @@ func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	loggedIn := verifyPasswordHash(userRequest.Password, passwordhash)
+	loggedIn := verifyPasswordHash(userRequest.Password, user.Password_hash)
 	if !loggedIn {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			a.Logger.Error("ip parsing err",
-				"remoteAddress", r.RemoteAddr,
-			)
-		}
+		a.recordLoginFailure(r, userRequest.Email, host)
 		a.Logger.Error("invalid credentials",
 			"handler", "loginuser",
 			"path", r.URL.Path,
@@ -183,11 +244,26 @@ func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	// with TOTP the failures are only cleared once the second factor passes too
+	if !user.Totp_enabled {
+		a.recordLoginSuccess(r, userRequest.Email)
+	}
+	if a.rejectSuspended(w, r, user, "loginuser") {
+		return
+	}
 
-	InitKeys()
-	accessToken, err := IssueKeys()
+	if user.Totp_enabled {
+		a.startLoginChallenge(w, r, user)
+		return
+	}
+	a.completeLogin(w, r, user, false, "loginuser")
+}
+
+// completeLogin issues the access token and refresh session of a user who passed every login step
+func (a *App) completeLogin(w http.ResponseWriter, r *http.Request, user model.User, mfa bool, handler string) {
+	accessToken, err := a.IssueKeys(user, mfa)
 	if err != nil {
-		a.LogInternalServerError(r, "jwt token issuing error", "loginuser", err)
+		a.LogInternalServerError(r, "jwt token issuing error", handler, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -197,14 +273,15 @@ func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := model.LoginResponse{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
+		AccessToken:    accessToken,
+		TokenType:      "Bearer",
+		Email_verified: user.Email_verified,
+		Totp_required:  user.Access_level <= 1 && !user.Totp_enabled,
 	}
 
 	//Set cookies + A refresh token
-	refreshToken, err := generateRandomString()
-	if err != nil {
-		a.LogInternalServerError(r, "cookie issuance error", "loginuser", err)
+	if err = a.startSession(w, r, user.Id, mfa); err != nil {
+		a.LogInternalServerError(r, "cookie issuance error", handler, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -213,16 +290,8 @@ func (a *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
+	response.Cart_merge = a.mergeGuestCartOnLogin(w, r, user.Id)
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(time.Hour * 24 * 3),
-	})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(response)
@@ -262,6 +331,17 @@ func (a *App) RegisterUser(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	userRequest.Email = strings.TrimSpace(userRequest.Email)
+	if !validEmail(userRequest.Email) {
+		a.LogBadRequest(r, "invalid email", "registeruser", fmt.Errorf("invalid email address"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid email address",
+		})
+		return
+	}
+
 	user := &model.User{}
 	password_hash, err := hashPassword(userRequest.Password)
 	if err != nil {
@@ -299,11 +379,16 @@ func (a *App) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		"time", time.Now(),
 		"userid", userId,
 	)
+	// the account exists either way, a failed email can be resent later
+	user.Id = userId
+	if err = a.sendEmailVerification(*user); err != nil {
+		a.LogInternalServerError(r, "verification email error", "registeruser", err)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"message": "account created successfully",
+		"message": "account created successfully, check your email to verify your address",
 	})
 
 }
@@ -394,7 +479,17 @@ func (a *App) ListProduct(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	if product.Images, err = queries.ListProductImages(a.DB, id); err != nil {
+		a.LogDatabaseError(r, "product images query error", "listproductimages", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(product.Version))
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(product) //Maybe encode to memory first later to avoid sending malformed json
 	if err != nil {
@@ -424,6 +519,11 @@ func (a *App) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	// snapshot for the audit log, a missing user is left to DeleteUser to report
+	var before any
+	if user, err := queries.GetUser(a.DB, id); err == nil {
+		before = userSummary(user)
+	}
 	err = queries.DeleteUser(a.DB, id)
 	if err != nil {
 		a.LogDatabaseError(r, "delete user query error", "deleteuser", err)
@@ -435,6 +535,11 @@ func (a *App) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
+	// the user is only archived, so their sessions and access tokens have to be ended here
+	if _, err = a.logoutEverywhere(id); err != nil {
+		a.LogDatabaseError(r, "logout deleted user error", "revokeusersessions", err)
+	}
+	a.recordAudit(r, auditUserDelete, "user", id, before, nil)
 	a.Logger.Info("successful user deletion",
 		"id", id,
 		"time", time.Now(),
@@ -511,7 +616,7 @@ func (a *App) ListSingleUser(w http.ResponseWriter, r *http.Request) {
 	}
 	user, err := queries.GetUser(a.DB, id)
 	if err != nil {
-		if err.Error() == fmt.Sprintf("user with id %d not found", id) {
+		if errors.Is(err, queries.ErrUserNotFound) {
 			a.LogDatabaseError(r, "user not found", "listsingleuser", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -555,8 +660,12 @@ func (a *App) AddNewProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var product model.Laptop
-	err := json.NewDecoder(r.Body).Decode(&product)
+	// the unit count is hidden from shoppers, so it isn't part of the laptop's JSON
+	var body struct {
+		model.Laptop
+		In_stock *int `json:"in_stock"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		a.LogBadRequest(r, "invalid json", "addnewproduct", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -566,6 +675,16 @@ func (a *App) AddNewProduct(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	product := body.Laptop
+	if msg := applyStockCount(&product, body.In_stock); msg != "" {
+		a.LogBadRequest(r, msg, "addnewproduct", nil)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
 	productId, err := queries.InsertLaptop(a.DB, product)
 	if err != nil {
 		a.LogDatabaseError(r, "insert laptop query error", "insertlaptop", err)
@@ -576,6 +695,8 @@ func (a *App) AddNewProduct(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	product.Id = productId
+	a.recordAudit(r, auditProductCreate, "product", productId, nil, product)
 	a.Logger.Info("laptop successfully added",
 		"time", time.Now(),
 		"id", productId,
@@ -589,6 +710,23 @@ func (a *App) AddNewProduct(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// applyStockCount sets the units in stock of a new laptop, which also decide is_in_stock.
+// Without a count a laptop can't be sold, so one marked in stock must come with it.
+func applyStockCount(product *model.Laptop, inStock *int) string {
+	if inStock == nil {
+		if product.Is_in_stock {
+			return "in_stock is required when is_in_stock is true"
+		}
+		return ""
+	}
+	if *inStock < 0 || *inStock > productimport.MaxStock {
+		return fmt.Sprintf("in_stock must be a whole number from 0 to %d", productimport.MaxStock)
+	}
+	product.In_stock = *inStock
+	product.Is_in_stock = *inStock > 0
+	return ""
+}
+
 // DeleteProduct removes a laptop from the database (admin only)
 func (a *App) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -602,6 +740,10 @@ func (a *App) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	var before any
+	if laptop, err := queries.QueryLaptop(a.DB, productId); err == nil {
+		before = laptop
+	}
 	err = queries.DeleteLaptop(a.DB, productId)
 	if err != nil {
 		a.LogDatabaseError(r, "delete laptop query error", "deleteproduct", err)
@@ -612,6 +754,7 @@ func (a *App) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	a.recordAudit(r, auditProductDelete, "product", productId, before, nil)
 	a.Logger.Info("product successfully deleted",
 		"time", time.Now(),
 		"id", productId,
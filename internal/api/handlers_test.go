@@ -499,6 +499,58 @@ func TestAddNewProductValidJSON(t *testing.T) {
 	app.AddNewProduct(w, req)
 }
 
+func TestAddNewProductInvalidStock(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"in stock without a count", `{"name": "Dell XPS 13", "is_in_stock": true}`},
+		{"negative count", `{"name": "Dell XPS 13", "in_stock": -1}`},
+		{"count too large", `{"name": "Dell XPS 13", "in_stock": 1000001}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/admin/addproduct", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			app.AddNewProduct(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestApplyStockCount(t *testing.T) {
+	five, zero := 5, 0
+	tests := []struct {
+		name        string
+		isInStock   bool
+		inStock     *int
+		wantStock   int
+		wantInStock bool
+	}{
+		{"count sets availability", false, &five, 5, true},
+		{"zero count is out of stock", true, &zero, 0, false},
+		{"no count out of stock", false, nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := model.Laptop{Is_in_stock: tt.isInStock}
+			if msg := applyStockCount(&product, tt.inStock); msg != "" {
+				t.Fatalf("unexpected error %q", msg)
+			}
+			if product.In_stock != tt.wantStock || product.Is_in_stock != tt.wantInStock {
+				t.Errorf("expected %d units and in stock %v, got %d and %v",
+					tt.wantStock, tt.wantInStock, product.In_stock, product.Is_in_stock)
+			}
+		})
+	}
+}
+
 func TestDeleteProductInvalidID(t *testing.T) {
 	app := setupTestApp()
 
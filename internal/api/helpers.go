@@ -5,12 +5,13 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	netmail "net/mail"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 func generateRandomString() (string, error) {
-	randomString := make([]byte, 8)
+	randomString := make([]byte, 32)
 	_, err := rand.Read(randomString)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate a string: %+v", err)
@@ -19,6 +20,12 @@ func generateRandomString() (string, error) {
 	return string(encodedString), nil
 }
 
+// validEmail accepts a bare address like "jane@example.com"
+func validEmail(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email && len(email) <= 100
+}
+
 func hashPassword(password string) (string, error) {
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), 8)
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lapbytes/internal/imaging"
+	"lapbytes/internal/model"
+	"lapbytes/internal/storage"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxImageBytes      = 10 << 20
+	maxImagesPerUpload = 10
+	mediaPath          = "/media/"
+)
+
+// mediaURL is where ServeMedia serves a stored object
+func mediaURL(key string) string {
+	return mediaPath + key
+}
+
+// AdminUploadProductImages adds the "image" parts of a multipart body to a laptop, after its
+// existing images (admin only). The type is sniffed from the bytes, and each image is decoded and
+// encoded again, which drops EXIF data such as GPS positions. Thumbnails follow in the background.
+func (a *App) AdminUploadProductImages(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, msg string, err error) {
+		a.LogBadRequest(r, msg, "adminuploadproductimages", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+	}
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productId < 1 {
+		fail(http.StatusBadRequest, "invalid id", err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImagesPerUpload*maxImageBytes+1<<20)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		fail(http.StatusBadRequest, "send the images as multipart/form-data in \"image\" fields", err)
+		return
+	}
+	if _, err = queries.QueryLaptop(a.DB, productId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = queries.ErrProductNotFound
+		}
+		a.writeImageError(w, r, "querylaptop", err)
+		return
+	}
+
+	var stored []model.ProductImage
+	discard := func() {
+		for _, image := range stored {
+			deleteImageFiles(a.Media, a.Logger, image.Key)
+		}
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discard()
+			fail(http.StatusBadRequest, "invalid multipart body", err)
+			return
+		}
+		if part.FormName() != "image" {
+			part.Close()
+			continue
+		}
+		if len(stored) == maxImagesPerUpload {
+			discard()
+			fail(http.StatusBadRequest, fmt.Sprintf("at most %d images per upload", maxImagesPerUpload), nil)
+			return
+		}
+		name := part.FileName()
+		if name == "" {
+			name = fmt.Sprintf("image %d", len(stored)+1)
+		}
+		image, status, err := a.storeImage(r, productId, part)
+		part.Close()
+		if err != nil {
+			discard()
+			fail(status, fmt.Sprintf("%s: %v", name, err), err)
+			return
+		}
+		stored = append(stored, image)
+	}
+	if len(stored) == 0 {
+		fail(http.StatusBadRequest, "no \"image\" fields in the body", nil)
+		return
+	}
+
+	images, err := queries.AddProductImages(a.DB, productId, stored)
+	if err != nil {
+		discard()
+		a.writeImageError(w, r, "addproductimages", err)
+		return
+	}
+	added := images[len(images)-len(stored):]
+	for _, image := range added {
+		a.Thumbnails.Enqueue(image.Id)
+	}
+	a.recordAudit(r, auditProductImageAdd, "product", productId, nil, added)
+	a.Logger.Info("product images uploaded",
+		"time", time.Now(),
+		"id", productId,
+		"count", len(added),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images": images,
+	})
+}
+
+// storeImage checks and cleans one uploaded image and saves it, returning the status to answer with on error
+func (a *App) storeImage(r *http.Request, productId int, part io.Reader) (model.ProductImage, int, error) {
+	data, err := io.ReadAll(io.LimitReader(part, maxImageBytes+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return model.ProductImage{}, http.StatusRequestEntityTooLarge, errors.New("the upload is too large")
+	}
+	if err != nil {
+		return model.ProductImage{}, http.StatusBadRequest, err
+	}
+	if len(data) > maxImageBytes {
+		return model.ProductImage{}, http.StatusRequestEntityTooLarge, fmt.Errorf("larger than %dMB", maxImageBytes>>20)
+	}
+	img, contentType, err := imaging.Decode(data)
+	if errors.Is(err, imaging.ErrUnsupported) {
+		return model.ProductImage{}, http.StatusUnsupportedMediaType, err
+	}
+	if err != nil {
+		return model.ProductImage{}, http.StatusBadRequest, err
+	}
+
+	var b bytes.Buffer
+	if contentType, err = imaging.Encode(&b, img, contentType); err != nil {
+		return model.ProductImage{}, http.StatusInternalServerError, err
+	}
+	key := fmt.Sprintf("products/%d/%s%s", productId, uuid.NewString(), imaging.Extension(contentType))
+	if err = a.Media.Put(r.Context(), key, b.Bytes(), contentType); err != nil {
+		a.Logger.Error("image write failed", "key", key, "error", err)
+		return model.ProductImage{}, http.StatusInternalServerError, errors.New("could not be saved")
+	}
+	return model.ProductImage{
+		Product_id:   productId,
+		Key:          key,
+		Url:          mediaURL(key),
+		Content_type: contentType,
+		Width:        img.Rect.Dx(),
+		Height:       img.Rect.Dy(),
+		Bytes:        b.Len(),
+	}, 0, nil
+}
+
+// AdminDeleteProductImage removes one image of a laptop and its files (admin only)
+func (a *App) AdminDeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	imageId, imageErr := strconv.Atoi(r.PathValue("image_id"))
+	if err != nil || imageErr != nil || productId < 1 || imageId < 1 {
+		a.LogBadRequest(r, "invalid id", "admindeleteproductimage", errors.Join(err, imageErr))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid id",
+		})
+		return
+	}
+	image, err := queries.DeleteProductImage(a.DB, productId, imageId)
+	if err != nil {
+		a.writeImageError(w, r, "deleteproductimage", err)
+		return
+	}
+	deleteImageFiles(a.Media, a.Logger, image.Key)
+	a.recordAudit(r, auditProductImageDelete, "product", productId, image, nil)
+	a.Logger.Info("product image deleted",
+		"time", time.Now(),
+		"id", productId,
+		"imageid", imageId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "image deleted",
+		"id":      imageId,
+	})
+}
+
+// AdminReorderProductImages sets the display order of a laptop's images from {"image_ids": [...]},
+// which must list every image once. The first becomes the product's image_url (admin only).
+func (a *App) AdminReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	badRequest := func(msg string, err error) {
+		a.LogBadRequest(r, msg, "adminreorderproductimages", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+	}
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productId < 1 {
+		badRequest("invalid id", err)
+		return
+	}
+	var body struct {
+		Image_ids []int `json:"image_ids"`
+	}
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		badRequest("invalid JSON", err)
+		return
+	}
+	if len(body.Image_ids) == 0 {
+		badRequest("image_ids is required", nil)
+		return
+	}
+	before, _ := queries.ListProductImages(a.DB, productId)
+	images, err := queries.ReorderProductImages(a.DB, productId, body.Image_ids)
+	if err != nil {
+		a.writeImageError(w, r, "reorderproductimages", err)
+		return
+	}
+	a.recordAudit(r, auditProductImageOrder, "product", productId, imageIds(before), imageIds(images))
+	a.Logger.Info("product images reordered",
+		"time", time.Now(),
+		"id", productId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images": images,
+	})
+}
+
+func imageIds(images []model.ProductImage) []int {
+	ids := make([]int, len(images))
+	for n, image := range images {
+		ids[n] = image.Id
+	}
+	return ids
+}
+
+// writeImageError maps image query errors to responses
+func (a *App) writeImageError(w http.ResponseWriter, r *http.Request, query string, err error) {
+	status, msg := http.StatusInternalServerError, "internal server error"
+	switch {
+	case errors.Is(err, queries.ErrProductNotFound), errors.Is(err, queries.ErrImageNotFound):
+		status, msg = http.StatusNotFound, err.Error()
+	case errors.Is(err, queries.ErrTooManyImages):
+		status, msg = http.StatusConflict, err.Error()
+	case errors.Is(err, queries.ErrImageOrderMismatch):
+		status, msg = http.StatusBadRequest, err.Error()
+	default:
+		a.LogDatabaseError(r, "product image query error", query, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
+
+// mediaTypes are the content types of the files ServeMedia serves
+var mediaTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// ServeMedia serves stored uploads. Keys are never reused, so responses are cached for good.
+func (a *App) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	contentType, ok := mediaTypes[path.Ext(key)]
+	if !ok || !storage.ValidKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := a.Media.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.Logger.Error("media read failed", "key", key, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
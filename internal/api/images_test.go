@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"lapbytes/internal/storage"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminProductImagesValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 0, Mfa: true}
+	tests := []struct {
+		name    string
+		handler func(*App) http.HandlerFunc
+		method  string
+		target  string
+		id      string
+		body    string
+	}{
+		{"upload bad id", func(a *App) http.HandlerFunc { return a.AdminUploadProductImages }, "POST", "/api/admin/product/x/images", "x", ""},
+		{"upload not multipart", func(a *App) http.HandlerFunc { return a.AdminUploadProductImages }, "POST", "/api/admin/product/1/images", "1", `{"image":"a.jpg"}`},
+		{"reorder bad id", func(a *App) http.HandlerFunc { return a.AdminReorderProductImages }, "PUT", "/api/admin/product/0/images/order", "0", `{"image_ids":[1]}`},
+		{"reorder bad json", func(a *App) http.HandlerFunc { return a.AdminReorderProductImages }, "PUT", "/api/admin/product/1/images/order", "1", `{"image_ids":"1,2"}`},
+		{"reorder no ids", func(a *App) http.HandlerFunc { return a.AdminReorderProductImages }, "PUT", "/api/admin/product/1/images/order", "1", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			tt.handler(app)(w, adminRequest(tt.method, tt.target, tt.id, tt.body, admin))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d but got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminDeleteProductImageInvalidId(t *testing.T) {
+	app := setupTestApp()
+	w := httptest.NewRecorder()
+	req := adminRequest("DELETE", "/api/admin/product/1/images/x", "1", "", nil)
+	req.SetPathValue("image_id", "x")
+
+	app.AdminDeleteProductImage(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d but got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestWriteImageError(t *testing.T) {
+	tests := []struct {
+		err            error
+		expectedStatus int
+	}{
+		{queries.ErrProductNotFound, http.StatusNotFound},
+		{queries.ErrImageNotFound, http.StatusNotFound},
+		{queries.ErrTooManyImages, http.StatusConflict},
+		{queries.ErrImageOrderMismatch, http.StatusBadRequest},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.writeImageError(w, httptest.NewRequest("POST", "/", nil), "addproductimages", tt.err)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeMedia(t *testing.T) {
+	app := setupTestApp()
+	app.Media = &storage.Local{Dir: t.TempDir()}
+	app.Media.Put(context.Background(), "products/1/a.jpg", []byte("jpeg bytes"), "image/jpeg")
+	tests := []struct {
+		key            string
+		expectedStatus int
+	}{
+		{"products/1/a.jpg", http.StatusOK},
+		{"products/1/b.jpg", http.StatusNotFound},
+		{"products/1/a.txt", http.StatusNotFound},
+		{"products/../1/a.jpg", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/media/x", nil)
+			req.SetPathValue("key", tt.key)
+
+			app.ServeMedia(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusOK {
+				body, _ := io.ReadAll(w.Body)
+				if string(body) != "jpeg bytes" || w.Header().Get("Content-Type") != "image/jpeg" {
+					t.Errorf("unexpected response %q %q", body, w.Header().Get("Content-Type"))
+				}
+			}
+		})
+	}
+}
+
+func TestThumbnailKey(t *testing.T) {
+	if got := thumbnailKey("products/3/abc.png", 320); got != "products/3/abc_320.jpg" {
+		t.Errorf("unexpected key %q", got)
+	}
+}
+
+func TestDeleteImageFiles(t *testing.T) {
+	app := setupTestApp()
+	store := &storage.Local{Dir: t.TempDir()}
+	ctx := context.Background()
+	keys := []string{"products/1/a.jpg", thumbnailKey("products/1/a.jpg", 160), "products/1/b.jpg"}
+	for _, key := range keys {
+		store.Put(ctx, key, []byte("x"), "image/jpeg")
+	}
+
+	deleteImageFiles(store, app.Logger, "products/1/a.jpg")
+
+	for i, key := range keys {
+		_, err := store.Get(ctx, key)
+		if deleted := errors.Is(err, storage.ErrNotFound); deleted != (i < 2) {
+			t.Errorf("%s: expected deleted to be %v", key, i < 2)
+		}
+	}
+}
+
+func TestThumbnailerEnqueueDoesNotBlock(t *testing.T) {
+	thumbnails := NewThumbnailer(nil, nil, setupTestApp().Logger)
+	for i := 0; i < cap(thumbnails.jobs)+10; i++ {
+		thumbnails.Enqueue(i)
+	}
+	if len(thumbnails.jobs) != cap(thumbnails.jobs) {
+		t.Errorf("expected a full queue, got %d", len(thumbnails.jobs))
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"lapbytes/internal/productimport"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxImportBytes   = 20 << 20
+	importJobMaxAge  = 24 * time.Hour //finished jobs are forgotten after this
+	importRunning    = "running"
+	importCompleted  = "completed"
+	importFailed     = "failed"
+	importStatusPath = "/api/admin/products/import/"
+)
+
+// ImportJob is the progress of a bulk product import running in the background
+type ImportJob struct {
+	Id          string                  `json:"id"`
+	Status      string                  `json:"status"`
+	Total       int                     `json:"total"`
+	Processed   int                     `json:"processed"`
+	Summary     productimport.Summary   `json:"summary"`
+	Error       string                  `json:"error,omitempty"`
+	Row_error   *productimport.RowError `json:"row_error,omitempty"`
+	Actor_id    int                     `json:"actor_id"`
+	Started_at  time.Time               `json:"started_at"`
+	Finished_at *time.Time              `json:"finished_at,omitempty"`
+}
+
+// ImportJobs keeps import jobs in memory so their progress can be polled. Jobs live on the
+// instance that runs them and are forgotten a day after they finish.
+type ImportJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*ImportJob
+}
+
+func NewImportJobs() *ImportJobs {
+	return &ImportJobs{jobs: map[string]*ImportJob{}}
+}
+
+// start registers a running job and forgets old finished ones
+func (j *ImportJobs) start(total int, actorId int) ImportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for id, job := range j.jobs {
+		if job.Finished_at != nil && now.Sub(*job.Finished_at) > importJobMaxAge {
+			delete(j.jobs, id)
+		}
+	}
+	job := &ImportJob{Id: uuid.NewString(), Status: importRunning, Total: total, Actor_id: actorId, Started_at: now}
+	j.jobs[job.Id] = job
+	return *job
+}
+
+// update changes a job under the lock
+func (j *ImportJobs) update(id string, change func(job *ImportJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// Get returns a copy of a job
+func (j *ImportJobs) Get(id string) (ImportJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return ImportJob{}, false
+	}
+	return *job, true
+}
+
+// AdminImportProducts creates or updates laptops by SKU from a CSV or JSON lines body (admin only).
+// Every row is validated first. With dry_run=true it reports what would happen, otherwise it
+// starts a job and answers 202 with the job to poll.
+func (a *App) AdminImportProducts(w http.ResponseWriter, r *http.Request) {
+	badRequest := func(status int, msg string, err error) {
+		a.LogBadRequest(r, msg, "adminimportproducts", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+	}
+	q := r.URL.Query()
+	format, err := productimport.FormatFor(q.Get("format"), r.Header.Get("Content-Type"), "")
+	if err != nil {
+		badRequest(http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			badRequest(http.StatusBadRequest, "dry_run must be true or false", err)
+			return
+		}
+	}
+
+	rows, rowErrs, err := productimport.Parse(http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			badRequest(http.StatusRequestEntityTooLarge, "the file is larger than 20MB, split it", err)
+			return
+		}
+		badRequest(http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if dryRun {
+		planned, conflicts, err := productimport.Plan(a.DB, rows)
+		if err != nil {
+			a.LogDatabaseError(r, "import plan query error", "laptopsforimport", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "internal server error",
+			})
+			return
+		}
+		rowErrs = append(rowErrs, conflicts...)
+		sort.SliceStable(rowErrs, func(i, j int) bool { return rowErrs[i].Line < rowErrs[j].Line })
+		var summary productimport.Summary
+		for _, row := range planned {
+			switch row.Action {
+			case queries.ImportCreated:
+				summary.Created++
+			case queries.ImportUpdated:
+				summary.Updated++
+			default:
+				summary.Unchanged++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run": true,
+			"summary": summary,
+			"rows":    planned,
+			"errors":  rowErrs,
+		})
+		return
+	}
+	if len(rowErrs) > 0 {
+		a.LogBadRequest(r, "invalid import rows", "adminimportproducts", errors.New(strconv.Itoa(len(rowErrs))+" invalid rows"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "some rows are invalid, nothing was imported",
+			"errors": rowErrs,
+		})
+		return
+	}
+
+	actorId, _ := userIdFromContext(r.Context())
+	job := a.Imports.start(len(rows), actorId)
+	go a.runImport(r, job.Id, rows)
+	a.Logger.Info("product import started",
+		"time", time.Now(),
+		"jobid", job.Id,
+		"rows", len(rows),
+		"adminid", actorId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", importStatusPath+job.Id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// runImport writes the rows of a job and records how it went. r is only read for the audit log.
+func (a *App) runImport(r *http.Request, jobId string, rows []productimport.Row) {
+	summary, rowErr, err := productimport.Import(a.DB, rows, func(done int) {
+		a.Imports.update(jobId, func(job *ImportJob) { job.Processed = done })
+	})
+	finished := time.Now()
+	a.Imports.update(jobId, func(job *ImportJob) {
+		job.Finished_at = &finished
+		job.Status = importCompleted
+		job.Summary = summary
+		if err != nil {
+			job.Status, job.Processed = importFailed, 0
+			job.Error = "the import failed and was rolled back"
+			job.Row_error = rowErr
+		}
+	})
+	if err != nil {
+		a.Logger.Error("product import failed",
+			"jobid", jobId,
+			"error", err,
+		)
+		return
+	}
+	a.recordAudit(r, auditProductImport, "import", jobId, nil, summary)
+	a.Logger.Info("product import completed",
+		"time", finished,
+		"jobid", jobId,
+		"created", summary.Created,
+		"updated", summary.Updated,
+		"unchanged", summary.Unchanged,
+	)
+}
+
+// AdminImportStatus returns the progress of an import job (admin only)
+func (a *App) AdminImportStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.Imports.Get(r.PathValue("id"))
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "import job not found",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminImportProductsValidation(t *testing.T) {
+	admin := &Principal{User_id: 1, Access_level: 0, Mfa: true}
+	invalidRow := "sku,name,brand,operating_system,operating_system_version,ram_size,cpu_maker,cpu_generation,cpu_model,year_of_manufacture,image_url,price,screen_size\n" +
+		"X1,ThinkPad,Lenovo,Windows,11,16,Intel,13th,i7,2023,/static/x1.png,free,14\n"
+	tests := []struct {
+		name           string
+		target         string
+		body           string
+		expectedStatus int
+	}{
+		{"unknown format", "/api/admin/products/import?format=xml", "", http.StatusBadRequest},
+		{"no format", "/api/admin/products/import", "", http.StatusBadRequest},
+		{"bad dry_run", "/api/admin/products/import?format=csv&dry_run=maybe", "", http.StatusBadRequest},
+		{"empty file", "/api/admin/products/import?format=csv", "", http.StatusBadRequest},
+		{"unknown column", "/api/admin/products/import?format=csv", "sku,colour\n", http.StatusBadRequest},
+		{"invalid rows", "/api/admin/products/import?format=csv", invalidRow, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			w := httptest.NewRecorder()
+
+			app.AdminImportProducts(w, adminRequest("POST", tt.target, "", tt.body, admin))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminImportProductsReportsRowErrors(t *testing.T) {
+	app := setupTestApp()
+	w := httptest.NewRecorder()
+	body := `{"sku":"bad sku","name":"XPS"}` + "\n"
+
+	app.AdminImportProducts(w, adminRequest("POST", "/api/admin/products/import?format=jsonl", "", body, nil))
+
+	var response struct {
+		Errors []struct {
+			Line   int      `json:"line"`
+			Errors []string `json:"errors"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].Line != 1 || len(response.Errors[0].Errors) < 2 {
+		t.Errorf("expected every problem of line 1, got %+v", response.Errors)
+	}
+}
+
+func TestImportJobs(t *testing.T) {
+	jobs := NewImportJobs()
+	job := jobs.start(10, 3)
+	if job.Status != importRunning || job.Total != 10 || job.Actor_id != 3 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	jobs.update(job.Id, func(j *ImportJob) { j.Processed = 5 })
+	got, ok := jobs.Get(job.Id)
+	if !ok || got.Processed != 5 {
+		t.Fatalf("expected 5 rows processed, got %+v", got)
+	}
+
+	old := time.Now().Add(-importJobMaxAge - time.Minute)
+	jobs.update(job.Id, func(j *ImportJob) { j.Status, j.Finished_at = importCompleted, &old })
+	jobs.start(1, 3)
+	if _, ok := jobs.Get(job.Id); ok {
+		t.Error("expected an old finished job to be forgotten")
+	}
+}
+
+func TestAdminImportStatusNotFound(t *testing.T) {
+	app := setupTestApp()
+	app.Imports = NewImportJobs()
+	w := httptest.NewRecorder()
+
+	app.AdminImportStatus(w, adminRequest("GET", "/api/admin/products/import/nope", "nope", "", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d but got %d", http.StatusNotFound, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "not found") {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+}
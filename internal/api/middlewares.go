@@ -2,38 +2,70 @@ package api
 
 import (
 	"context"
-	"crypto/rsa"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-type jwtClaims struct {
-	jwt.RegisteredClaims
-	Access_level int `json:"access_level,omitempty"`
-}
 type contextKey string
 
-const jwtClaimsKey contextKey = "jwt_claims"
+const principalKey contextKey = "principal"
 
-// Public, potential error here
-var PublicKey *rsa.PublicKey
+// Principal is the authenticated caller of a request, taken from a verified access token
+type Principal struct {
+	User_id      int
+	Access_level int
+	Token_id     string
+	Issued_at    time.Time
+	Expires_at   time.Time
+	Mfa          bool
+}
 
-func init() {
-	pkData, err := os.ReadFile("./public_key.pem")
-	if err != nil {
-		log.Fatal(err)
+// principalFromClaims converts verified token claims into a Principal, rejecting
+// tokens without the claims every token we issue carries
+func principalFromClaims(c *JwtClaims) (*Principal, error) {
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil || id < 1 {
+		return nil, fmt.Errorf("token subject %q is not a user id", c.Subject)
+	}
+	if c.ID == "" {
+		return nil, fmt.Errorf("token has no jti")
 	}
-	PublicKey, err = jwt.ParseRSAPublicKeyFromPEM(pkData)
-	if err != nil {
-		log.Fatal(err)
+	if c.NotBefore == nil || c.IssuedAt == nil || c.ExpiresAt == nil {
+		return nil, fmt.Errorf("token is missing nbf, iat or exp")
 	}
+	return &Principal{
+		User_id:      id,
+		Access_level: c.Access_level,
+		Token_id:     c.ID,
+		Issued_at:    c.IssuedAt.Time,
+		Expires_at:   c.ExpiresAt.Time,
+		Mfa:          c.Mfa,
+	}, nil
+}
 
+// principalFromContext returns the caller set by GeneralJwtVerifierMW
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// userIdFromContext returns the id of the authenticated user
+func userIdFromContext(ctx context.Context) (int, error) {
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return 0, fmt.Errorf("no principal in context")
+	}
+	if p.User_id < 1 {
+		return 0, fmt.Errorf("principal has no user id")
+	}
+	return p.User_id, nil
 }
 
 // ReqLoggingMW logs HTTP requests with method, path, and duration
@@ -68,14 +100,36 @@ func (a *App) GeneralJwtVerifierMW(next http.Handler) http.Handler {
 			return
 		}
 		tokenString := splitToken[1]
-		claims := jwtClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
-			return PublicKey, nil
-		})
+		claims := JwtClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, &claims, a.Keys.Keyfunc,
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+			jwt.WithIssuer(tokenIssuer),
+			jwt.WithAudience(tokenAudience),
+			jwt.WithIssuedAt(),
+			jwt.WithExpirationRequired(),
+		)
+		var principal *Principal
+		if err == nil && token.Valid {
+			principal, err = principalFromClaims(&claims)
+		}
 		if err != nil || !token.Valid {
 			a.Logger.Error("jwt verfication error",
 				"time", time.Now(),
 				"ip", r.RemoteAddr,
+				"error", err,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "not authorized",
+			})
+			return
+		}
+		if a.Revocations != nil && a.Revocations.IsRevoked(principal) {
+			a.Logger.Error("revoked token used",
+				"time", time.Now(),
+				"ip", r.RemoteAddr,
+				"userid", principal.User_id,
 			)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
@@ -84,18 +138,30 @@ func (a *App) GeneralJwtVerifierMW(next http.Handler) http.Handler {
 			})
 			return
 		}
-		ctx := context.WithValue(r.Context(), jwtClaimsKey, &claims)
+		ctx := context.WithValue(r.Context(), principalKey, principal)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// IsAdminJwtVerifierMW ensures the authenticated user has admin privileges
+// OptionalJwtVerifierMW lets anonymous requests through and verifies the token when one is sent
+func (a *App) OptionalJwtVerifierMW(next http.Handler) http.Handler {
+	verified := a.GeneralJwtVerifierMW(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		verified.ServeHTTP(w, r)
+	})
+}
+
+// IsAdminJwtVerifierMW ensures the authenticated user has admin privileges and
+// logged in with two-factor authentication
 func (a *App) IsAdminJwtVerifierMW(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		v := r.Context().Value(jwtClaimsKey)
-		c, ok := v.(*jwtClaims)
+		p, ok := principalFromContext(r.Context())
 
-		if !ok || c == nil || c.Access_level > 1 {
+		if !ok || p.Access_level > 1 {
 			a.Logger.Error("admin access denied",
 				"time", time.Now(),
 				"ip", r.RemoteAddr,
@@ -108,6 +174,19 @@ func (a *App) IsAdminJwtVerifierMW(next http.Handler) http.Handler {
 			return
 
 		}
+		if !p.Mfa {
+			a.Logger.Error("admin access denied without two-factor authentication",
+				"time", time.Now(),
+				"ip", r.RemoteAddr,
+				"userid", p.User_id,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "two-factor authentication required, enable it and log in again",
+			})
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
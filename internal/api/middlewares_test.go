@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
+	"lapbytes/internal/keys"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -34,19 +35,31 @@ func generateTestKeys() (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	return privateKey, &privateKey.PublicKey, nil
 }
 
-func createTestToken(privateKey *rsa.PrivateKey, accessLevel int) (string, error) {
-	claims := jwtClaims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-		Access_level: accessLevel,
+func testKeyProvider(t *testing.T, privateKey *rsa.PrivateKey) *keys.Provider {
+	t.Helper()
+	provider, err := keys.New("", keys.Key{Id: "test", Private: privateKey, Public: &privateKey.PublicKey})
+	if err != nil {
+		t.Fatalf("failed to create key provider: %v", err)
 	}
+	return provider
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+func createTestToken(privateKey *rsa.PrivateKey, accessLevel int) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, newAccessClaims(7, accessLevel))
 	return token.SignedString(privateKey)
 }
 
+func signMutatedClaims(t *testing.T, privateKey *rsa.PrivateKey, mutate func(*JwtClaims)) string {
+	t.Helper()
+	claims := newAccessClaims(7, 2)
+	mutate(claims)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
 func TestReqLoggingMW(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -104,14 +117,12 @@ func TestReqLoggingMW(t *testing.T) {
 }
 
 func TestGeneralJwtVerifierMW(t *testing.T) {
-	privateKey, publicKey, err := generateTestKeys()
+	privateKey, _, err := generateTestKeys()
 	if err != nil {
 		t.Fatalf("failed to generate test keys: %v", err)
 	}
 
-	originalPublicKey := PublicKey
-	PublicKey = publicKey
-	defer func() { PublicKey = originalPublicKey }()
+	provider := testKeyProvider(t, privateKey)
 
 	tests := []struct {
 		name               string
@@ -166,7 +177,7 @@ func TestGeneralJwtVerifierMW(t *testing.T) {
 			name:       "expired token",
 			authHeader: "Bearer %s",
 			setupToken: func() string {
-				claims := jwtClaims{
+				claims := JwtClaims{
 					RegisteredClaims: jwt.RegisteredClaims{
 						ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)), // Expired
 						IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
@@ -183,22 +194,68 @@ func TestGeneralJwtVerifierMW(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			expectedError:  "not authorized",
 		},
+		{
+			name:       "wrong audience",
+			authHeader: "Bearer %s",
+			setupToken: func() string {
+				return signMutatedClaims(t, privateKey, func(c *JwtClaims) { c.Audience = jwt.ClaimStrings{"other-service"} })
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "not authorized",
+		},
+		{
+			name:       "wrong issuer",
+			authHeader: "Bearer %s",
+			setupToken: func() string {
+				return signMutatedClaims(t, privateKey, func(c *JwtClaims) { c.Issuer = "someone-else" })
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "not authorized",
+		},
+		{
+			name:       "subject is not a user id",
+			authHeader: "Bearer %s",
+			setupToken: func() string {
+				return signMutatedClaims(t, privateKey, func(c *JwtClaims) { c.Subject = "authentication" })
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "not authorized",
+		},
+		{
+			name:       "missing jti",
+			authHeader: "Bearer %s",
+			setupToken: func() string {
+				return signMutatedClaims(t, privateKey, func(c *JwtClaims) { c.ID = "" })
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "not authorized",
+		},
+		{
+			name:       "not yet valid",
+			authHeader: "Bearer %s",
+			setupToken: func() string {
+				return signMutatedClaims(t, privateKey, func(c *JwtClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) })
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "not authorized",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app := setupTestAppForMiddleware()
+			app.Keys = provider
 
 			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if tt.expectContextValue {
-					claims := r.Context().Value(jwtClaimsKey)
+					claims := r.Context().Value(principalKey)
 					if claims == nil {
 						t.Error("expected jwt claims in context but got nil")
 					}
-					if jwtClaims, ok := claims.(*jwtClaims); !ok {
-						t.Error("expected jwt claims to be of type *jwtClaims")
-					} else if jwtClaims.Access_level != 2 {
-						t.Errorf("expected access level 2 but got %d", jwtClaims.Access_level)
+					if principal, ok := claims.(*Principal); !ok {
+						t.Error("expected principal to be of type *Principal")
+					} else if principal.Access_level != 2 {
+						t.Errorf("expected access level 2 but got %d", principal.Access_level)
 					}
 				}
 				w.WriteHeader(http.StatusOK)
@@ -258,24 +315,33 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 		{
 			name: "valid admin access (level 0)",
 			setupContext: func() context.Context {
-				claims := &jwtClaims{Access_level: 0}
-				return context.WithValue(context.Background(), jwtClaimsKey, claims)
+				claims := &Principal{Access_level: 0, Mfa: true}
+				return context.WithValue(context.Background(), principalKey, claims)
 			},
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name: "valid admin access (level 1)",
 			setupContext: func() context.Context {
-				claims := &jwtClaims{Access_level: 1}
-				return context.WithValue(context.Background(), jwtClaimsKey, claims)
+				claims := &Principal{Access_level: 1, Mfa: true}
+				return context.WithValue(context.Background(), principalKey, claims)
 			},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name: "admin without two-factor login",
+			setupContext: func() context.Context {
+				claims := &Principal{Access_level: 0}
+				return context.WithValue(context.Background(), principalKey, claims)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "two-factor authentication required, enable it and log in again",
+		},
 		{
 			name: "invalid access level (level 2)",
 			setupContext: func() context.Context {
-				claims := &jwtClaims{Access_level: 2}
-				return context.WithValue(context.Background(), jwtClaimsKey, claims)
+				claims := &Principal{Access_level: 2}
+				return context.WithValue(context.Background(), principalKey, claims)
 			},
 			expectedStatus: http.StatusForbidden,
 			expectedError:  "admin access required",
@@ -283,8 +349,8 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 		{
 			name: "invalid access level (level 4)",
 			setupContext: func() context.Context {
-				claims := &jwtClaims{Access_level: 4}
-				return context.WithValue(context.Background(), jwtClaimsKey, claims)
+				claims := &Principal{Access_level: 4}
+				return context.WithValue(context.Background(), principalKey, claims)
 			},
 			expectedStatus: http.StatusForbidden,
 			expectedError:  "admin access required",
@@ -300,7 +366,7 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 		{
 			name: "wrong context key",
 			setupContext: func() context.Context {
-				claims := &jwtClaims{Access_level: 0}
+				claims := &Principal{Access_level: 0}
 				return context.WithValue(context.Background(), "wrong_key", claims)
 			},
 			expectedStatus: http.StatusForbidden,
@@ -309,7 +375,7 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 		{
 			name: "nil claims",
 			setupContext: func() context.Context {
-				return context.WithValue(context.Background(), jwtClaimsKey, nil)
+				return context.WithValue(context.Background(), principalKey, nil)
 			},
 			expectedStatus: http.StatusForbidden,
 			expectedError:  "admin access required",
@@ -317,7 +383,7 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 		{
 			name: "wrong type in context",
 			setupContext: func() context.Context {
-				return context.WithValue(context.Background(), jwtClaimsKey, "not_jwt_claims")
+				return context.WithValue(context.Background(), principalKey, "not_jwt_claims")
 			},
 			expectedStatus: http.StatusForbidden,
 			expectedError:  "admin access required",
@@ -370,15 +436,15 @@ func TestIsAdminJwtVerifierMW(t *testing.T) {
 }
 
 func TestContextKey(t *testing.T) {
-	ctx := context.WithValue(context.Background(), jwtClaimsKey, "test_value")
-	value := ctx.Value(jwtClaimsKey)
+	ctx := context.WithValue(context.Background(), principalKey, "test_value")
+	value := ctx.Value(principalKey)
 
 	if value != "test_value" {
 		t.Errorf("expected 'test_value' but got %v", value)
 	}
 
-	ctx2 := context.WithValue(context.Background(), contextKey("jwt_claims"), "test_value2")
-	value2 := ctx2.Value(jwtClaimsKey)
+	ctx2 := context.WithValue(context.Background(), contextKey("principal"), "test_value2")
+	value2 := ctx2.Value(principalKey)
 
 	if value2 != "test_value2" {
 		t.Errorf("expected 'test_value2' but got %v", value2)
@@ -386,7 +452,7 @@ func TestContextKey(t *testing.T) {
 }
 
 func TestJwtClaims(t *testing.T) {
-	claims := jwtClaims{
+	claims := JwtClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -403,3 +469,52 @@ func TestJwtClaims(t *testing.T) {
 		t.Error("expected JWT token to be created but got nil")
 	}
 }
+
+func TestOptionalJwtVerifierMW(t *testing.T) {
+	privateKey, _, err := generateTestKeys()
+	if err != nil {
+		t.Fatalf("failed to generate test keys: %v", err)
+	}
+	provider := testKeyProvider(t, privateKey)
+
+	validToken, err := createTestToken(privateKey, 4)
+	if err != nil {
+		t.Fatalf("failed to create test token: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		authHeader     string
+		expectedStatus int
+		expectClaims   bool
+	}{
+		{"anonymous request", "", http.StatusOK, false},
+		{"valid token", "Bearer " + validToken, http.StatusOK, true},
+		{"invalid token", "Bearer invalid.token.here", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestAppForMiddleware()
+			app.Keys = provider
+			handler := app.OptionalJwtVerifierMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, hasClaims := r.Context().Value(principalKey).(*Principal)
+				if hasClaims != tt.expectClaims {
+					t.Errorf("expected claims in context %v but got %v", tt.expectClaims, hasClaims)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/api/cart", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
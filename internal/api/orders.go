@@ -0,0 +1,376 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateShipping trims the shipping details and checks that all fields are present
+func validateShipping(s *model.ShippingDetails) error {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Phone = strings.TrimSpace(s.Phone)
+	s.Address = strings.TrimSpace(s.Address)
+	s.City = strings.TrimSpace(s.City)
+	switch {
+	case s.Name == "" || len(s.Name) > 255:
+		return fmt.Errorf("shipping name is required")
+	case s.Phone == "" || len(s.Phone) > 32:
+		return fmt.Errorf("shipping phone is required")
+	case s.Address == "" || len(s.Address) > 500:
+		return fmt.Errorf("shipping address is required")
+	case s.City == "" || len(s.City) > 255:
+		return fmt.Errorf("shipping city is required")
+	}
+	return nil
+}
+
+// Checkout turns the authenticated user's cart into an order and reserves the stock
+func (a *App) Checkout(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "checkout", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	type checkoutRequest struct {
+		Shipping model.ShippingDetails `json:"shipping"`
+	}
+	var req checkoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.LogBadRequest(r, "invalid json", "checkout", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid JSON",
+		})
+		return
+	}
+	if err := validateShipping(&req.Shipping); err != nil {
+		a.LogBadRequest(r, "invalid shipping details", "checkout", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		a.LogBadRequest(r, "checkout without user", "checkout", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+
+	verified, err := queries.IsEmailVerified(a.DB, userId)
+	if err != nil {
+		a.LogDatabaseError(r, "email verification lookup error", "isemailverified", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if !verified {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "please verify your email address before checking out",
+		})
+		return
+	}
+
+	order, err := queries.CreateOrderFromCart(a.DB, userId, req.Shipping)
+	if err != nil {
+		var stockErr *queries.StockError
+		switch {
+		case errors.Is(err, queries.ErrCartEmpty):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "cart is empty",
+			})
+		case errors.As(err, &stockErr):
+			a.Logger.Info("checkout rejected for stock",
+				"userid", userId,
+				"error", err,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":     "some items are no longer available in the requested quantity",
+				"shortages": stockErr.Shortages,
+			})
+		default:
+			a.LogDatabaseError(r, "checkout transaction error", "createorderfromcart", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "could not complete checkout",
+			})
+		}
+		return
+	}
+	a.Logger.Info("order created",
+		"time", time.Now(),
+		"orderid", order.Id,
+		"userid", userId,
+		"total", order.Total,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":   order,
+		"message": "order created",
+	})
+}
+
+func parseOrderId(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid order id %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// writeOrderError maps order store errors to responses
+func (a *App) writeOrderError(w http.ResponseWriter, r *http.Request, query string, err error) {
+	status, msg := http.StatusInternalServerError, "internal server error"
+	switch {
+	case errors.Is(err, queries.ErrOrderNotFound):
+		status, msg = http.StatusNotFound, "order not found"
+	case errors.Is(err, queries.ErrInvalidTransition):
+		status, msg = http.StatusConflict, err.Error()
+	default:
+		a.LogDatabaseError(r, "order query error", query, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
+
+// listOrders writes a page of orders matching the filter
+func (a *App) listOrders(w http.ResponseWriter, r *http.Request, filter queries.OrderFilter) {
+	orders, total, err := queries.ListOrders(a.DB, filter)
+	if err != nil {
+		a.writeOrderError(w, r, "listorders", err)
+		return
+	}
+	if orders == nil {
+		orders = []model.Order{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"orders":  orders,
+		"total":   total,
+		"page":    filter.Offset/filter.Limit + 1,
+		"limit":   filter.Limit,
+		"message": "request successful",
+	})
+}
+
+// ListMyOrders returns the authenticated user's orders, newest first
+func (a *App) ListMyOrders(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePaging(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid paging", "listmyorders", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		a.LogBadRequest(r, "orders without user", "listmyorders", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	a.listOrders(w, r, queries.OrderFilter{UserId: userId, Limit: limit, Offset: offset})
+}
+
+// GetMyOrder returns one of the authenticated user's orders with its items and history
+func (a *App) GetMyOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := parseOrderId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid order id", "getmyorder", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid order id",
+		})
+		return
+	}
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		a.LogBadRequest(r, "order without user", "getmyorder", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	order, err := queries.GetOrder(a.DB, orderId)
+	if err == nil && order.User_id != userId {
+		// don't reveal that other users' orders exist
+		err = queries.ErrOrderNotFound
+	}
+	if err != nil {
+		a.writeOrderError(w, r, "getorder", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":   order,
+		"message": "request successful",
+	})
+}
+
+// AdminListOrders returns all orders filtered by status and user (admin only)
+func (a *App) AdminListOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset, err := parsePaging(q)
+	filter := queries.OrderFilter{Status: q.Get("status"), Limit: limit, Offset: offset}
+	if err == nil && filter.Status != "" && !model.IsOrderStatus(filter.Status) {
+		err = fmt.Errorf("invalid status %q", filter.Status)
+	}
+	if err == nil && q.Get("user_id") != "" {
+		filter.UserId, err = strconv.Atoi(q.Get("user_id"))
+		if err != nil || filter.UserId < 1 {
+			err = fmt.Errorf("invalid user_id")
+		}
+	}
+	if err != nil {
+		a.LogBadRequest(r, "invalid order filter", "adminlistorders", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.listOrders(w, r, filter)
+}
+
+// AdminGetOrder returns any order with its items and history (admin only)
+func (a *App) AdminGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := parseOrderId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid order id", "admingetorder", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid order id",
+		})
+		return
+	}
+	order, err := queries.GetOrder(a.DB, orderId)
+	if err != nil {
+		a.writeOrderError(w, r, "getorder", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":   order,
+		"message": "request successful",
+	})
+}
+
+// AdminUpdateOrderStatus advances an order through the order state machine (admin only)
+func (a *App) AdminUpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderId, err := parseOrderId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid order id", "adminupdateorderstatus", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid order id",
+		})
+		return
+	}
+	type statusRequest struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	var req statusRequest
+	if r.Header.Get("Content-Type") != "application/json" {
+		err = fmt.Errorf("non json request")
+	} else if err = json.NewDecoder(r.Body).Decode(&req); err == nil && !model.IsOrderStatus(req.Status) {
+		err = fmt.Errorf("invalid status %q", req.Status)
+	}
+	if err != nil {
+		a.LogBadRequest(r, "invalid status update", "adminupdateorderstatus", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "a JSON body with a valid status is required",
+		})
+		return
+	}
+	// these follow money: paid comes from a settled payment, refunded from AdminRefundOrder
+	if req.Status == model.OrderPaid || req.Status == model.OrderRefunded {
+		a.LogBadRequest(r, "payment status set by hand", "adminupdateorderstatus", fmt.Errorf("status %q", req.Status))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "paid is set by the payment provider, refund orders through /refund",
+		})
+		return
+	}
+
+	actor := queries.OrderActor{Type: model.ActorAdmin}
+	if adminId, err := userIdFromContext(r.Context()); err == nil {
+		actor.Id = &adminId
+	}
+	order, err := queries.TransitionOrder(a.DB, orderId, req.Status, actor, strings.TrimSpace(req.Note))
+	if err != nil {
+		a.writeOrderError(w, r, "transitionorder", err)
+		return
+	}
+	// the transition is the last history event, its from status is the state before
+	var before, after any = nil, map[string]string{"status": order.Status}
+	if n := len(order.History); n > 0 {
+		after = order.History[n-1]
+		if from := order.History[n-1].From; from != nil {
+			before = map[string]string{"status": *from}
+		}
+	}
+	a.recordAudit(r, auditOrderStatus, "order", orderId, before, after)
+	a.Logger.Info("order status changed",
+		"time", time.Now(),
+		"orderid", orderId,
+		"status", order.Status,
+		"actor", actor.Id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":   order,
+		"message": "order status updated",
+	})
+}
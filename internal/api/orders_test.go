@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"lapbytes/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateShipping(t *testing.T) {
+	valid := model.ShippingDetails{Name: " Jane ", Phone: "0712345678", Address: "Moi Avenue 1", City: "Nairobi"}
+	if err := validateShipping(&valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if valid.Name != "Jane" {
+		t.Errorf("expected trimmed name but got %q", valid.Name)
+	}
+
+	missing := []model.ShippingDetails{
+		{Phone: "1", Address: "a", City: "c"},
+		{Name: "n", Address: "a", City: "c"},
+		{Name: "n", Phone: "1", City: "c"},
+		{Name: "n", Phone: "1", Address: "a", City: "   "},
+	}
+	for i, s := range missing {
+		if err := validateShipping(&s); err == nil {
+			t.Errorf("case %d: expected error but got nil", i)
+		}
+	}
+}
+
+func TestCheckoutInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{"invalid content type", "text/plain", "{}", http.StatusBadRequest},
+		{"invalid json", "application/json", "nope", http.StatusBadRequest},
+		{"missing shipping", "application/json", `{}`, http.StatusBadRequest},
+		{"no user", "application/json", `{"shipping":{"name":"n","phone":"1","address":"a","city":"c"}}`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/checkout", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req = req.WithContext(context.WithValue(req.Context(), principalKey, &Principal{}))
+			w := httptest.NewRecorder()
+
+			app.Checkout(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{model.OrderPendingPayment, model.OrderPaid, true},
+		{model.OrderPendingPayment, model.OrderCancelled, true},
+		{model.OrderPendingPayment, model.OrderShipped, false},
+		{model.OrderPaid, model.OrderPacked, true},
+		{model.OrderPaid, model.OrderRefunded, true},
+		{model.OrderPacked, model.OrderShipped, true},
+		{model.OrderShipped, model.OrderDelivered, true},
+		{model.OrderShipped, model.OrderCancelled, false},
+		{model.OrderDelivered, model.OrderRefunded, true},
+		{model.OrderCancelled, model.OrderPaid, false},
+		{model.OrderRefunded, model.OrderPaid, false},
+		{"unknown", model.OrderPaid, false},
+	}
+	for _, tt := range tests {
+		if got := model.CanTransitionOrder(tt.from, tt.to); got != tt.allowed {
+			t.Errorf("%s -> %s: expected %v but got %v", tt.from, tt.to, tt.allowed, got)
+		}
+	}
+}
+
+func TestGetMyOrderInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"invalid id", "abc", http.StatusBadRequest},
+		{"zero id", "0", http.StatusBadRequest},
+		{"no user", "5", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("GET", "/api/orders/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+
+			app.GetMyOrder(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestListMyOrdersInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"invalid limit", "limit=abc", http.StatusBadRequest},
+		{"no user", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("GET", "/api/orders?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			app.ListMyOrders(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminListOrdersInvalidFilter(t *testing.T) {
+	for _, query := range []string{"status=lost", "user_id=abc", "user_id=0", "page=0"} {
+		app := setupTestApp()
+		req := httptest.NewRequest("GET", "/api/admin/orders?"+query, nil)
+		w := httptest.NewRecorder()
+
+		app.AdminListOrders(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", query, w.Code)
+		}
+	}
+}
+
+func TestAdminUpdateOrderStatusInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		contentType string
+		body        string
+	}{
+		{"invalid id", "abc", "application/json", `{"status":"paid"}`},
+		{"invalid content type", "1", "text/plain", `{"status":"paid"}`},
+		{"invalid json", "1", "application/json", "nope"},
+		{"unknown status", "1", "application/json", `{"status":"teleported"}`},
+		{"paid by hand", "1", "application/json", `{"status":"paid"}`},
+		{"refunded by hand", "1", "application/json", `{"status":"refunded"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/admin/orders/"+tt.id+"/status", strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			app.AdminUpdateOrderStatus(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/mail"
+	"lapbytes/internal/store/queries"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	passwordResetLifetime = 30 * time.Minute
+	minPasswordLength     = 8
+	maxPasswordLength     = 72 //bcrypt ignores anything longer
+	maxEmailLength        = 254
+)
+
+// ResetPasswordPath is the page a password reset email links to, it posts to /api/password/reset
+const ResetPasswordPath = "/reset-password"
+
+// ResetPasswordLink is the address emailed for a reset token
+func ResetPasswordLink(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + ResetPasswordPath + "?token=" + url.QueryEscape(token)
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d characters", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// RunPasswordResets sends the reset emails queued in PasswordResets on workers goroutines; it never returns
+func (a *App) RunPasswordResets(workers int) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for email := range a.PasswordResets {
+				a.sendPasswordReset(email)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sendPasswordReset emails a reset link if the email belongs to an account. It runs
+// after the response is written so its timing says nothing about the account.
+func (a *App) sendPasswordReset(email string) {
+	user, err := queries.GetUserHash(a.DB, email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			a.Logger.Error("database error", "sourceQuery", "getuserhash", "error", err)
+		}
+		return
+	}
+	token, err := generateRandomString()
+	if err != nil {
+		a.Logger.Error("reset token generation error", "error", err)
+		return
+	}
+	if err = queries.CreatePasswordReset(a.DB, user.Id, hashToken(token), time.Now().Add(passwordResetLifetime)); err != nil {
+		a.Logger.Error("database error", "sourceQuery", "createpasswordreset", "error", err)
+		return
+	}
+	link := ResetPasswordLink(a.BaseURL, token)
+	err = a.Mailer.Send(context.Background(), mail.Message{
+		To:      user.Email,
+		Subject: "Reset your LapBytes password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It works once and expires in %d minutes.\n\n%s\n\n"+
+			"If you did not ask for this you can ignore this email.\n", user.Username, int(passwordResetLifetime.Minutes()), link),
+	})
+	if err != nil {
+		a.Logger.Error("password reset email failed", "userid", user.Id, "error", err)
+		return
+	}
+	a.Logger.Info("password reset requested",
+		"time", time.Now(),
+		"userid", user.Id,
+	)
+}
+
+// ForgotPassword starts a password reset. The response is the same whether or not the email exists.
+func (a *App) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type forgotRequest struct {
+		Email string `json:"email"`
+	}
+	var req forgotRequest
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "forgotpassword", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		a.LogBadRequest(r, "missing email", "forgotpassword", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email is required",
+		})
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if len(email) > maxEmailLength {
+		a.LogBadRequest(r, "email too long", "forgotpassword", fmt.Errorf("%d characters", len(email)))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email is too long",
+		})
+		return
+	}
+	// every request counts, known email or not, so the answer says nothing about the account
+	if a.ResetLimiter != nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		wait, err := a.ResetLimiter.Check(r.Context(), email, host)
+		if err != nil {
+			a.LogDatabaseError(r, "password reset throttle check error", "getloginthrottle", err)
+		}
+		if wait > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "too many password reset requests, please try again later",
+			})
+			return
+		}
+		if err = a.ResetLimiter.Failure(r.Context(), email, host); err != nil {
+			a.LogDatabaseError(r, "password reset throttle update error", "failloginthrottle", err)
+		}
+	}
+	select {
+	case a.PasswordResets <- email:
+	default:
+		a.Logger.Warn("password reset queue full, request dropped")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "if an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password with a reset token and logs the user out everywhere
+func (a *App) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	type resetRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var req resetRequest
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "resetpassword", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		a.LogBadRequest(r, "missing reset token", "resetpassword", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "token and password are required",
+		})
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		a.LogBadRequest(r, "invalid new password", "resetpassword", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		a.LogInternalServerError(r, "password hashing error", "resetpassword", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	userId, err := queries.ConsumePasswordReset(a.DB, hashToken(req.Token), passwordHash)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrResetTokenInvalid) {
+			status, msg = http.StatusBadRequest, "invalid or expired reset link"
+		} else {
+			a.LogDatabaseError(r, "consume password reset error", "consumepasswordreset", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	// whoever knew the old password must not stay logged in
+	if _, err = a.logoutEverywhere(userId); err != nil {
+		a.LogDatabaseError(r, "revoke sessions after reset error", "revokeusersessions", err)
+	}
+	clearRefreshTokenCookie(w)
+	a.Logger.Info("password reset",
+		"time", time.Now(),
+		"userid", userId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "password updated, please log in again",
+	})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"lapbytes/internal/lockout"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password  string
+		expectErr bool
+	}{
+		{"short", true},
+		{"longenough", false},
+		{strings.Repeat("a", 72), false},
+		{strings.Repeat("a", 73), true},
+	}
+	for _, tt := range tests {
+		if err := validatePassword(tt.password); (err != nil) != tt.expectErr {
+			t.Errorf("password of length %d: expected error %v but got %v", len(tt.password), tt.expectErr, err)
+		}
+	}
+}
+
+func TestForgotPasswordInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"invalid content type", "text/plain", `{"email":"a@example.com"}`},
+		{"invalid json", "application/json", "nope"},
+		{"missing email", "application/json", `{"email":"  "}`},
+		{"email too long", "application/json", `{"email":"` + strings.Repeat("a", 250) + `@example.com"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/password/forgot", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			app.ForgotPassword(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestForgotPasswordQueuedAndThrottled(t *testing.T) {
+	app := setupTestApp()
+	app.ResetLimiter = lockout.NewReset(lockout.NewMemory())
+	app.PasswordResets = make(chan string, 1)
+	forgot := func(email string) int {
+		req := httptest.NewRequest("POST", "/api/password/forgot", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		app.ForgotPassword(w, req)
+		return w.Code
+	}
+
+	if code := forgot("jane@example.com"); code != http.StatusAccepted {
+		t.Fatalf("expected status 202 but got %d", code)
+	}
+	if email := <-app.PasswordResets; email != "jane@example.com" {
+		t.Errorf("expected the email to be queued, got %q", email)
+	}
+	if code := forgot("Jane@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a second request for the email to be throttled, got %d", code)
+	}
+
+	forgot("ann@example.com")
+	if code := forgot("bob@example.com"); code != http.StatusAccepted {
+		t.Errorf("expected a full queue to still answer 202, got %d", code)
+	}
+}
+
+func TestResetPasswordInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"invalid content type", "text/plain", `{"token":"t","password":"longenough"}`},
+		{"invalid json", "application/json", "nope"},
+		{"missing token", "application/json", `{"password":"longenough"}`},
+		{"weak password", "application/json", `{"token":"t","password":"short"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			app.ResetPassword(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
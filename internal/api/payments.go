@@ -0,0 +1,440 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/payment"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"time"
+)
+
+// paymentProvider returns the named provider, or the default one when name is empty
+func (a *App) paymentProvider(name string) (payment.Provider, bool) {
+	if name == "" {
+		name = a.DefaultPaymentProvider
+	}
+	p, ok := a.Payments[name]
+	return p, ok
+}
+
+// applyPaymentResult stores a provider result and logs what it changed
+func (a *App) applyPaymentResult(r *http.Request, provider string, res payment.Result) (queries.PaymentUpdate, error) {
+	update, err := queries.ApplyPaymentResult(a.DB, provider, res.Reference, string(res.Status), res.Receipt, res.Message)
+	if err != nil {
+		return update, err
+	}
+	switch {
+	case !update.Applied:
+		a.Logger.Info("payment result already applied",
+			"provider", provider,
+			"reference", res.Reference,
+		)
+	case res.Status == payment.StatusSucceeded && !update.OrderPaid:
+		a.Logger.Warn("payment succeeded for an order that is no longer awaiting payment, refund required",
+			"provider", provider,
+			"reference", res.Reference,
+			"orderid", update.Payment.Order_id,
+		)
+	default:
+		a.Logger.Info("payment settled",
+			"provider", provider,
+			"reference", res.Reference,
+			"status", res.Status,
+			"orderid", update.Payment.Order_id,
+		)
+	}
+	return update, nil
+}
+
+// paymentInitiationTimeout is how long a payment may stay initiating before it is taken as abandoned
+const paymentInitiationTimeout = 5 * time.Minute
+
+// reconcilePayment asks the provider about a payment still pending locally, in case a callback was
+// lost, and fails one whose initiation was abandoned
+func (a *App) reconcilePayment(r *http.Request, p model.Payment) model.Payment {
+	if p.Status == model.PaymentInitiating {
+		abandoned, err := queries.AbandonPayment(a.DB, p.Id, "the payment provider did not answer", paymentInitiationTimeout)
+		if err != nil {
+			if !errors.Is(err, queries.ErrPaymentNotFound) {
+				a.LogDatabaseError(r, "abandon payment error", "abandonpayment", err)
+			}
+			return p
+		}
+		return abandoned
+	}
+	if p.Status != model.PaymentPending {
+		return p
+	}
+	provider, ok := a.Payments[p.Provider]
+	if !ok {
+		return p
+	}
+	res, err := provider.Status(r.Context(), p.Reference)
+	if err != nil {
+		a.Logger.Error("payment status query failed",
+			"provider", p.Provider,
+			"reference", p.Reference,
+			"error", err,
+		)
+		return p
+	}
+	if !res.Status.Final() {
+		return p
+	}
+	update, err := a.applyPaymentResult(r, p.Provider, res)
+	if err != nil {
+		a.LogDatabaseError(r, "apply payment result error", "applypaymentresult", err)
+		return p
+	}
+	return update.Payment
+}
+
+// ownOrder loads an order of the authenticated user, writing the error response if it can't
+func (a *App) ownOrder(w http.ResponseWriter, r *http.Request, handler string) (model.Order, bool) {
+	orderId, err := parseOrderId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid order id", handler, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid order id",
+		})
+		return model.Order{}, false
+	}
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		a.LogBadRequest(r, "order without user", handler, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return model.Order{}, false
+	}
+	order, err := queries.GetOrder(a.DB, orderId)
+	if err == nil && order.User_id != userId {
+		err = queries.ErrOrderNotFound
+	}
+	if err != nil {
+		a.writeOrderError(w, r, "getorder", err)
+		return model.Order{}, false
+	}
+	return order, true
+}
+
+// PayOrder starts paying for one of the user's pending orders
+func (a *App) PayOrder(w http.ResponseWriter, r *http.Request) {
+	type payRequest struct {
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	var req payRequest
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "payorder", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" {
+		a.LogBadRequest(r, "invalid payment request", "payorder", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "phone is required",
+		})
+		return
+	}
+	provider, ok := a.paymentProvider(req.Provider)
+	if !ok {
+		a.LogBadRequest(r, "unknown payment provider", "payorder", fmt.Errorf("provider %q", req.Provider))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "unsupported payment provider",
+		})
+		return
+	}
+	order, ok := a.ownOrder(w, r, "payorder")
+	if !ok {
+		return
+	}
+	if order.Status != model.OrderPendingPayment {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "order is not awaiting payment",
+		})
+		return
+	}
+
+	previous, err := queries.GetLatestOrderPayment(a.DB, order.Id)
+	if err != nil && !errors.Is(err, queries.ErrPaymentNotFound) {
+		a.LogDatabaseError(r, "latest payment query error", "getlatestorderpayment", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if err == nil {
+		a.reconcilePayment(r, previous) //settles one whose callback was lost, so a new one may start
+	}
+	started, err := queries.StartPayment(a.DB, model.Payment{
+		Order_id: order.Id,
+		Provider: provider.Name(),
+		Amount:   order.Total,
+		Phone:    req.Phone,
+	})
+	if err != nil {
+		status, msg := http.StatusConflict, "a payment for this order is already in progress"
+		if !errors.Is(err, queries.ErrPaymentOpen) {
+			a.LogDatabaseError(r, "start payment error", "startpayment", err)
+			status, msg = http.StatusInternalServerError, "internal server error"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+
+	res, err := provider.Initiate(r.Context(), payment.Request{
+		OrderId:     order.Id,
+		Amount:      order.Total,
+		Phone:       req.Phone,
+		Description: fmt.Sprintf("LapBytes order %d", order.Id),
+	})
+	if err != nil {
+		a.Logger.Error("payment initiation failed",
+			"provider", provider.Name(),
+			"orderid", order.Id,
+			"error", err,
+		)
+		if _, err := queries.AbandonPayment(a.DB, started.Id, "the payment provider refused the payment", 0); err != nil {
+			a.LogDatabaseError(r, "abandon payment error", "abandonpayment", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "could not start payment, please check the phone number and try again",
+		})
+		return
+	}
+	p, err := queries.PaymentInitiated(a.DB, started.Id, res.Reference, res.Message)
+	if err != nil {
+		a.LogDatabaseError(r, "payment initiated error", "paymentinitiated", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if res.Status.Final() {
+		// providers may settle synchronously
+		if update, err := a.applyPaymentResult(r, provider.Name(), res); err != nil {
+			a.LogDatabaseError(r, "apply payment result error", "applypaymentresult", err)
+		} else {
+			p = update.Payment
+		}
+	}
+	a.Logger.Info("payment initiated",
+		"time", time.Now(),
+		"orderid", order.Id,
+		"provider", provider.Name(),
+		"reference", p.Reference,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"payment": p,
+		"message": res.Message,
+	})
+}
+
+// GetOrderPayment returns the latest payment of one of the user's orders
+func (a *App) GetOrderPayment(w http.ResponseWriter, r *http.Request) {
+	order, ok := a.ownOrder(w, r, "getorderpayment")
+	if !ok {
+		return
+	}
+	p, err := queries.GetLatestOrderPayment(a.DB, order.Id)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrPaymentNotFound) {
+			status, msg = http.StatusNotFound, "no payment for this order"
+		} else {
+			a.LogDatabaseError(r, "latest payment query error", "getlatestorderpayment", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"payment": a.reconcilePayment(r, p),
+		"message": "request successful",
+	})
+}
+
+// PaymentCallback receives asynchronous payment results from a provider's gateway.
+// Callbacks are verified by the provider and safe to deliver more than once.
+func (a *App) PaymentCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := a.Payments[name]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "unknown provider",
+		})
+		return
+	}
+	res, err := provider.ParseCallback(r)
+	if err != nil {
+		a.Logger.Error("payment callback rejected",
+			"provider", name,
+			"ip", r.RemoteAddr,
+			"error", err,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid callback",
+		})
+		return
+	}
+	if _, err = a.applyPaymentResult(r, name, res); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, queries.ErrPaymentNotFound) {
+			status = http.StatusNotFound
+		}
+		a.LogDatabaseError(r, "apply payment callback error", "applypaymentresult", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "callback not applied",
+		})
+		return
+	}
+	// shape accepted by Daraja, harmless for other providers
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	})
+}
+
+// AdminRefundOrder refunds the payment of an order through its provider (admin only)
+func (a *App) AdminRefundOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := parseOrderId(r)
+	if err != nil {
+		a.LogBadRequest(r, "invalid order id", "adminrefundorder", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid order id",
+		})
+		return
+	}
+	order, err := queries.GetOrder(a.DB, orderId)
+	if err != nil {
+		a.writeOrderError(w, r, "getorder", err)
+		return
+	}
+	p, err := queries.GetLatestOrderPayment(a.DB, orderId)
+	if err != nil && !errors.Is(err, queries.ErrPaymentNotFound) {
+		a.LogDatabaseError(r, "latest payment query error", "getlatestorderpayment", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	provider, known := a.Payments[p.Provider]
+	if err != nil || p.Status != model.PaymentSucceeded || !known || !model.CanTransitionOrder(order.Status, model.OrderRefunded) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "order has no refundable payment",
+		})
+		return
+	}
+
+	if err = queries.ClaimRefund(a.DB, p.Id); err != nil {
+		status, msg := http.StatusConflict, "order has no refundable payment"
+		if !errors.Is(err, queries.ErrPaymentNotPaid) {
+			a.LogDatabaseError(r, "claim refund error", "claimrefund", err)
+			status, msg = http.StatusInternalServerError, "internal server error"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+
+	paid := payment.Result{Reference: p.Reference, Receipt: p.Receipt, Status: payment.StatusSucceeded}
+	if err = provider.Refund(r.Context(), paid, p.Amount); err != nil {
+		a.Logger.Error("payment refund failed",
+			"provider", p.Provider,
+			"reference", p.Reference,
+			"orderid", orderId,
+			"error", err,
+		)
+		if err := queries.ReleaseRefund(a.DB, p.Id); err != nil {
+			a.LogDatabaseError(r, "release refund error", "releaserefund", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "payment provider refused the refund",
+		})
+		return
+	}
+
+	actor := queries.OrderActor{Type: model.ActorAdmin}
+	if adminId, err := userIdFromContext(r.Context()); err == nil {
+		actor.Id = &adminId
+	}
+	if err = queries.RefundOrderPayment(a.DB, p.Id, actor, "refund "+p.Reference); err != nil {
+		// the money already went back, so this needs attention rather than a retry
+		a.Logger.Error("refund issued but not recorded",
+			"orderid", orderId,
+			"paymentid", p.Id,
+			"error", err,
+		)
+		a.writeOrderError(w, r, "refundorderpayment", err)
+		return
+	}
+	a.recordAudit(r, auditOrderRefund, "order", orderId,
+		map[string]interface{}{"status": order.Status, "payment_id": p.Id, "amount": p.Amount},
+		map[string]interface{}{"status": model.OrderRefunded, "reference": p.Reference},
+	)
+	a.Logger.Info("order refunded",
+		"time", time.Now(),
+		"orderid", orderId,
+		"amount", p.Amount,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "order refunded",
+		"orderid": orderId,
+	})
+}
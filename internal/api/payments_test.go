@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"lapbytes/internal/payment"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setupPaymentTestApp() (*App, *payment.Fake) {
+	app := setupTestApp()
+	fake := payment.NewFake([]byte("test-secret"), false)
+	app.Payments = map[string]payment.Provider{"fake": fake}
+	app.DefaultPaymentProvider = "fake"
+	return app, fake
+}
+
+func TestPayOrderInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		orderId        string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{"invalid content type", "1", "text/plain", `{"phone":"0712345678"}`, http.StatusBadRequest},
+		{"invalid json", "1", "application/json", "nope", http.StatusBadRequest},
+		{"missing phone", "1", "application/json", `{}`, http.StatusBadRequest},
+		{"unknown provider", "1", "application/json", `{"provider":"paypal","phone":"0712345678"}`, http.StatusBadRequest},
+		{"invalid order id", "abc", "application/json", `{"phone":"0712345678"}`, http.StatusBadRequest},
+		{"no user", "1", "application/json", `{"phone":"0712345678"}`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := setupPaymentTestApp()
+			req := httptest.NewRequest("POST", "/api/orders/"+tt.orderId+"/pay", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.SetPathValue("id", tt.orderId)
+			req = req.WithContext(context.WithValue(req.Context(), principalKey, &Principal{}))
+			w := httptest.NewRecorder()
+
+			app.PayOrder(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestPaymentCallbackRejected(t *testing.T) {
+	app, fake := setupPaymentTestApp()
+	res, err := fake.Initiate(context.Background(), payment.Request{OrderId: 1, Amount: 100, Phone: "0712345678"})
+	if err != nil {
+		t.Fatalf("initiate: %v", err)
+	}
+
+	t.Run("unknown provider", func(t *testing.T) {
+		req, _ := fake.CallbackRequest("/api/payments/paypal/callback", res.Reference)
+		req.SetPathValue("provider", "paypal")
+		w := httptest.NewRecorder()
+
+		app.PaymentCallback(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d but got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		req, _ := fake.CallbackRequest("/api/payments/fake/callback", res.Reference)
+		req.Header.Set(payment.FakeSignatureHeader, "00")
+		req.SetPathValue("provider", "fake")
+		w := httptest.NewRecorder()
+
+		app.PaymentCallback(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d but got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+}
+
+func TestAdminRefundOrderInvalidId(t *testing.T) {
+	app, _ := setupPaymentTestApp()
+	req := httptest.NewRequest("POST", "/api/admin/orders/x/refund", nil)
+	req.SetPathValue("id", "x")
+	w := httptest.NewRecorder()
+
+	app.AdminRefundOrder(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d but got %d", http.StatusBadRequest, w.Code)
+	}
+}
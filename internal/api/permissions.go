@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Permission names one action on one kind of resource, as "resource:action"
+type Permission string
+
+const (
+	PermProductsWrite  Permission = "products:write"
+	PermProductsDelete Permission = "products:delete"
+	PermProductsExport Permission = "products:export"
+	PermOrdersRead     Permission = "orders:read"
+	PermOrdersWrite    Permission = "orders:write"
+	PermOrdersRefund   Permission = "orders:refund"
+	PermUsersRead      Permission = "users:read"
+	PermUsersManage    Permission = "users:manage" //force logout and unlock
+	PermUsersRoles     Permission = "users:roles"  //change access levels
+	PermUsersSuspend   Permission = "users:suspend"
+	PermUsersDelete    Permission = "users:delete"
+	PermAuditRead      Permission = "audit:read"
+)
+
+// Role is the set of permissions granted to an access level
+type Role struct {
+	Name        string
+	Permissions []Permission
+}
+
+// roles maps access levels to roles. Levels 2 and 3 have no staff role yet, like
+// shoppers and levels outside 0-4 they hold no permissions.
+var roles = map[int]Role{
+	0: {Name: "superuser", Permissions: []Permission{
+		PermProductsWrite, PermProductsDelete, PermProductsExport,
+		PermOrdersRead, PermOrdersWrite, PermOrdersRefund,
+		PermUsersRead, PermUsersManage, PermUsersRoles, PermUsersSuspend, PermUsersDelete,
+		PermAuditRead,
+	}},
+	1: {Name: "admin", Permissions: []Permission{
+		PermProductsWrite, PermProductsDelete, PermProductsExport,
+		PermOrdersRead, PermOrdersWrite, PermOrdersRefund,
+		PermUsersRead, PermUsersManage, PermUsersRoles, PermUsersSuspend,
+		PermAuditRead,
+	}},
+	2: {Name: "customer"},
+	3: {Name: "customer"},
+	4: {Name: "customer"},
+}
+
+// RoleForLevel returns the role of an access level
+func RoleForLevel(level int) Role {
+	if role, ok := roles[level]; ok {
+		return role
+	}
+	return Role{Name: "customer"}
+}
+
+// HasPermission reports whether an access level grants a permission
+func HasPermission(level int, perm Permission) bool {
+	return slices.Contains(RoleForLevel(level).Permissions, perm)
+}
+
+// RequirePermission only lets requests through whose principal holds every one of perms.
+// It goes after GeneralJwtVerifierMW, and after IsAdminJwtVerifierMW on admin routes.
+func (a *App) RequirePermission(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := principalFromContext(r.Context())
+			for _, perm := range perms {
+				if ok && HasPermission(p.Access_level, perm) {
+					continue
+				}
+				userId, accessLevel := 0, -1
+				if ok {
+					userId, accessLevel = p.User_id, p.Access_level
+				}
+				a.Logger.Warn("permission denied",
+					"time", time.Now(),
+					"userid", userId,
+					"accesslevel", accessLevel,
+					"role", RoleForLevel(accessLevel).Name,
+					"permission", perm,
+					"method", r.Method,
+					"path", r.URL.Path,
+					"ip", r.RemoteAddr,
+				)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":      "permission denied",
+					"permission": string(perm),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
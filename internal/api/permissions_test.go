@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		level    int
+		perm     Permission
+		expected bool
+	}{
+		{0, PermUsersDelete, true},
+		{0, PermOrdersRefund, true},
+		{1, PermProductsWrite, true},
+		{1, PermOrdersRefund, true},
+		{1, PermUsersDelete, false},
+		{1, PermProductsExport, true},
+		{2, PermOrdersRead, false},
+		{4, PermProductsWrite, false},
+		{10, PermUsersRead, false},
+		{-1, PermUsersRead, false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.level, tt.perm); got != tt.expected {
+			t.Errorf("HasPermission(%d, %s): expected %v but got %v", tt.level, tt.perm, tt.expected, got)
+		}
+	}
+}
+
+func TestRoleForLevel(t *testing.T) {
+	if name := RoleForLevel(0).Name; name != "superuser" {
+		t.Errorf("expected superuser but got %s", name)
+	}
+	if name := RoleForLevel(1).Name; name != "admin" {
+		t.Errorf("expected admin but got %s", name)
+	}
+	if name := RoleForLevel(7).Name; name != "customer" {
+		t.Errorf("expected unknown levels to be customers but got %s", name)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name           string
+		principal      *Principal
+		perms          []Permission
+		expectedStatus int
+		missing        string
+	}{
+		{"superuser deletes users", &Principal{User_id: 1, Access_level: 0}, []Permission{PermUsersDelete}, http.StatusOK, ""},
+		{"admin cannot delete users", &Principal{User_id: 2, Access_level: 1}, []Permission{PermUsersDelete}, http.StatusForbidden, "users:delete"},
+		{"all permissions are required", &Principal{User_id: 2, Access_level: 1}, []Permission{PermUsersRead, PermUsersDelete}, http.StatusForbidden, "users:delete"},
+		{"shopper", &Principal{User_id: 3, Access_level: 4}, []Permission{PermOrdersRead}, http.StatusForbidden, "orders:read"},
+		{"no principal", nil, []Permission{PermOrdersRead}, http.StatusForbidden, "orders:read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestAppForMiddleware()
+			handler := app.RequirePermission(tt.perms...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest("POST", "/api/admin/deleteuser/9", nil)
+			if tt.principal != nil {
+				req = req.WithContext(context.WithValue(req.Context(), principalKey, tt.principal))
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.missing != "" {
+				var response map[string]string
+				json.NewDecoder(w.Body).Decode(&response)
+				if response["permission"] != tt.missing {
+					t.Errorf("expected missing permission %q but got %q", tt.missing, response["permission"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lapbytes/internal/productimport"
+	"lapbytes/internal/store/queries"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxProductText  = 255 //the products table uses VARCHAR(255)
+	maxProductPrice = 99_999_999.99
+)
+
+// errPreconditionRequired means an update named no version to check against
+var errPreconditionRequired = errors.New("send the product's ETag in If-Match or its version in the body")
+
+// productETag is the ETag of a laptop at a version
+func productETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch returns the version an If-Match header asks for, 0 when it is absent or "*"
+func parseIfMatch(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`))
+	if err != nil || version < 1 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, fmt.Errorf("If-Match must be a single ETag like %s", productETag(3))
+	}
+	return version, nil
+}
+
+// parseLaptopPatch reads a partial laptop from JSON using the field names of model.Laptop.
+// version is the "version" field, 0 when not sent.
+func parseLaptopPatch(body io.Reader) (patch queries.LaptopPatch, version int, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.NewDecoder(body).Decode(&fields); err != nil {
+		return patch, 0, fmt.Errorf("invalid JSON")
+	}
+
+	text := func(dst **string) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v string
+			if json.Unmarshal(raw, &v) != nil {
+				return fmt.Errorf("%s must be a string", name)
+			}
+			v = strings.TrimSpace(v)
+			if v == "" || len(v) > maxProductText {
+				return fmt.Errorf("%s must be 1 to %d characters", name, maxProductText)
+			}
+			*dst = &v
+			return nil
+		}
+	}
+	// nullable text, null clears it
+	optionalText := func(dst **sql.NullString) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v *string
+			if json.Unmarshal(raw, &v) != nil {
+				return fmt.Errorf("%s must be a string or null", name)
+			}
+			if v == nil {
+				*dst = &sql.NullString{}
+				return nil
+			}
+			s := strings.TrimSpace(*v)
+			if len(s) > maxProductText {
+				return fmt.Errorf("%s must be at most %d characters", name, maxProductText)
+			}
+			*dst = &sql.NullString{String: s, Valid: s != ""}
+			return nil
+		}
+	}
+	number := func(dst **float64, positive bool, max float64) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v float64
+			if json.Unmarshal(raw, &v) != nil || v < 0 || (positive && v == 0) || v > max {
+				if positive {
+					return fmt.Errorf("%s must be a number above 0 and at most %g", name, max)
+				}
+				return fmt.Errorf("%s must be a number from 0 to %g", name, max)
+			}
+			*dst = &v
+			return nil
+		}
+	}
+	flag := func(dst **bool) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v bool
+			if json.Unmarshal(raw, &v) != nil {
+				return fmt.Errorf("%s must be true or false", name)
+			}
+			*dst = &v
+			return nil
+		}
+	}
+
+	count := func(dst **int, max int) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v int
+			if json.Unmarshal(raw, &v) != nil || v < 0 || v > max {
+				return fmt.Errorf("%s must be a whole number from 0 to %d", name, max)
+			}
+			*dst = &v
+			return nil
+		}
+	}
+
+	sku := func(dst **string) func(string, json.RawMessage) error {
+		return func(name string, raw json.RawMessage) error {
+			var v string
+			if json.Unmarshal(raw, &v) != nil || !productimport.ValidSku(strings.TrimSpace(v)) {
+				return fmt.Errorf("%s must be 1 to %d letters, digits, '-', '_' or '.'", name, productimport.MaxSkuLength)
+			}
+			v = strings.TrimSpace(v)
+			*dst = &v
+			return nil
+		}
+	}
+
+	p := &patch
+	parsers := map[string]func(string, json.RawMessage) error{
+		"name":                     text(&p.Name),
+		"brand":                    text(&p.Brand),
+		"operating_system":         text(&p.Operating_system),
+		"operating_system_version": text(&p.Operating_system_version),
+		"hdd":                      flag(&p.HDD),
+		"ssd":                      flag(&p.SSD),
+		"hdd_size":                 number(&p.HDD_size, false, 1e6),
+		"ssd_size":                 number(&p.SSD_size, false, 1e6),
+		"ram_size":                 number(&p.Ram_size, true, 1e4),
+		"cpu_maker":                text(&p.CPU_maker),
+		"cpu_generation":           text(&p.CPU_gen),
+		"cpu_model":                text(&p.CPU_model),
+		"year_of_manufacture":      text(&p.YOM),
+		"image_url":                text(&p.Image_url),
+		"price":                    number(&p.Price, true, maxProductPrice),
+		"screen_size":              number(&p.Screen_size, true, 100),
+		"has_gpu":                  flag(&p.Has_gpu),
+		"gpu_model":                optionalText(&p.Gpu_make),
+		"gpu_manufacturer":         optionalText(&p.Gpu_maker),
+		"has_integrated_gpu":       flag(&p.Has_igpu),
+		"is_in_stock":              flag(&p.Is_in_stock),
+		"in_stock":                 count(&p.In_stock, productimport.MaxStock),
+		"sku":                      sku(&p.Sku),
+	}
+
+	// sorted so the first error reported doesn't depend on map order
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	updates := 0
+	for _, name := range names {
+		if name == "version" {
+			if json.Unmarshal(fields[name], &version) != nil || version < 1 {
+				return patch, 0, fmt.Errorf("version must be a positive integer")
+			}
+			continue
+		}
+		parse, ok := parsers[name]
+		if !ok {
+			return patch, 0, fmt.Errorf("unknown or read-only field %q", name)
+		}
+		if err = parse(name, fields[name]); err != nil {
+			return patch, 0, err
+		}
+		updates++
+	}
+	if updates == 0 {
+		return patch, 0, fmt.Errorf("no fields to update")
+	}
+	// a unit count decides availability unless is_in_stock is sent too, as in imports
+	if p.In_stock != nil && p.Is_in_stock == nil {
+		inStock := *p.In_stock > 0
+		p.Is_in_stock = &inStock
+	}
+	if p.In_stock != nil && *p.In_stock == 0 && *p.Is_in_stock {
+		return patch, 0, fmt.Errorf("is_in_stock can't be true with in_stock 0")
+	}
+	return patch, version, nil
+}
+
+// UpdateProduct applies a partial update to a laptop (admin only). The client must send the
+// version it last read, as If-Match or "version", so it can't overwrite someone else's edit.
+func (a *App) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productId < 1 {
+		a.LogBadRequest(r, "invalid id", "updateproduct", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid id",
+		})
+		return
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" && ct != "application/merge-patch+json" {
+		a.LogBadRequest(r, "invalid content-type", "updateproduct", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid data type",
+		})
+		return
+	}
+
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	patch, bodyVersion, bodyErr := parseLaptopPatch(r.Body)
+	if err == nil {
+		err = bodyErr
+	}
+	if err == nil && bodyVersion != 0 {
+		if version != 0 && version != bodyVersion {
+			err = fmt.Errorf("If-Match and version disagree")
+		}
+		version = bodyVersion
+	}
+	if err == nil && version == 0 && strings.TrimSpace(r.Header.Get("If-Match")) != "*" {
+		a.LogBadRequest(r, "update without version", "updateproduct", errPreconditionRequired)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": errPreconditionRequired.Error(),
+		})
+		return
+	}
+	if err != nil {
+		a.LogBadRequest(r, "invalid product update", "updateproduct", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	previous, product, err := queries.UpdateLaptop(a.DB, productId, patch, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, queries.ErrProductNotFound):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "item not found",
+			})
+		case errors.Is(err, queries.ErrProductVersionConflict):
+			a.Logger.Warn("product update conflict",
+				"time", time.Now(),
+				"id", productId,
+				"version", version,
+				"currentversion", previous.Version,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", productETag(previous.Version))
+			w.WriteHeader(http.StatusPreconditionFailed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "the product was changed since you loaded it, reload and try again",
+				"current": previous,
+			})
+		case errors.Is(err, queries.ErrDuplicateProduct), errors.Is(err, queries.ErrDuplicateSku):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+		default:
+			a.LogDatabaseError(r, "update laptop query error", "updatelaptop", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "internal server error",
+			})
+		}
+		return
+	}
+	a.recordAudit(r, auditProductUpdate, "product", productId, previous, product)
+	a.Logger.Info("product successfully updated",
+		"time", time.Now(),
+		"id", productId,
+		"version", product.Version,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(product.Version))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(product)
+}
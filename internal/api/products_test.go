@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"*", 0, false},
+		{`"3"`, 3, false},
+		{` "12" `, 12, false},
+		{"3", 0, true},
+		{`W/"3"`, 0, true},
+		{`"0"`, 0, true},
+		{`"3", "4"`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			version, err := parseIfMatch(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v but got %v", tt.wantErr, err)
+			}
+			if version != tt.version {
+				t.Errorf("expected version %d but got %d", tt.version, version)
+			}
+		})
+	}
+}
+
+func TestParseLaptopPatch(t *testing.T) {
+	patch, version, err := parseLaptopPatch(strings.NewReader(`{"price": 899.5, "name": " ThinkPad X1 ", "gpu_model": null, "version": 4}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != 4 {
+		t.Errorf("expected version 4 but got %d", version)
+	}
+	if patch.Price == nil || *patch.Price != 899.5 {
+		t.Errorf("expected price 899.5 but got %v", patch.Price)
+	}
+	if patch.Name == nil || *patch.Name != "ThinkPad X1" {
+		t.Errorf("expected trimmed name but got %v", patch.Name)
+	}
+	if patch.Gpu_make == nil || patch.Gpu_make.Valid {
+		t.Errorf("expected gpu_model to be cleared but got %v", patch.Gpu_make)
+	}
+	if patch.Brand != nil || patch.Ram_size != nil {
+		t.Error("expected fields missing from the body to stay nil")
+	}
+}
+
+func TestParseLaptopPatchStock(t *testing.T) {
+	tests := []struct {
+		body      string
+		inStock   int
+		available bool
+	}{
+		{`{"in_stock": 12}`, 12, true},
+		{`{"in_stock": 0}`, 0, false},
+		{`{"in_stock": 12, "is_in_stock": false}`, 12, false},
+	}
+	for _, tt := range tests {
+		patch, _, err := parseLaptopPatch(strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.body, err)
+		}
+		if patch.In_stock == nil || *patch.In_stock != tt.inStock || patch.Is_in_stock == nil || *patch.Is_in_stock != tt.available {
+			t.Errorf("%s: expected %d units and is_in_stock %v but got %v, %v", tt.body, tt.inStock, tt.available, patch.In_stock, patch.Is_in_stock)
+		}
+	}
+}
+
+func TestParseLaptopPatchInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{"price":`},
+		{"empty", `{}`},
+		{"only version", `{"version": 2}`},
+		{"unknown field", `{"colour": "black"}`},
+		{"read-only id", `{"id": 9}`},
+		{"negative price", `{"price": -1}`},
+		{"zero ram", `{"ram_size": 0}`},
+		{"price as string", `{"price": "100"}`},
+		{"blank name", `{"name": "   "}`},
+		{"long brand", `{"brand": "` + strings.Repeat("x", maxProductText+1) + `"}`},
+		{"null name", `{"name": null}`},
+		{"bad flag", `{"has_gpu": "yes"}`},
+		{"bad version", `{"price": 10, "version": 0}`},
+		{"negative stock", `{"in_stock": -1}`},
+		{"fractional stock", `{"in_stock": 2.5}`},
+		{"in stock without units", `{"in_stock": 0, "is_in_stock": true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseLaptopPatch(strings.NewReader(tt.body)); err == nil {
+				t.Error("expected an error but got nil")
+			}
+		})
+	}
+}
+
+func TestUpdateProductValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		contentType    string
+		ifMatch        string
+		body           string
+		expectedStatus int
+	}{
+		{"invalid id", "abc", "application/json", `"1"`, `{"price": 10}`, http.StatusBadRequest},
+		{"not json", "1", "text/plain", `"1"`, `{"price": 10}`, http.StatusBadRequest},
+		{"bad field", "1", "application/json", `"1"`, `{"price": -10}`, http.StatusBadRequest},
+		{"bad if-match", "1", "application/json", `1`, `{"price": 10}`, http.StatusBadRequest},
+		{"versions disagree", "1", "application/json", `"1"`, `{"price": 10, "version": 2}`, http.StatusBadRequest},
+		{"no version", "1", "application/json", ``, `{"price": 10}`, http.StatusPreconditionRequired},
+		{"no version merge patch", "1", "application/merge-patch+json", ``, `{"price": 10}`, http.StatusPreconditionRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("PATCH", "/api/admin/product/"+tt.id, strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			app.UpdateProduct(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
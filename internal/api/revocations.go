@@ -0,0 +1,133 @@
+package api
+
+import (
+	"lapbytes/internal/store/queries"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// revocationSyncOverlap re-reads a little history on every sync so rows committed
+// slightly out of order by other instances are not missed
+const revocationSyncOverlap = 5 * time.Second
+
+// RevocationList answers "is this access token revoked" from memory. Revocations are
+// written through to Postgres and other instances pick them up on their next Sync.
+type RevocationList struct {
+	pool *pgxpool.Pool
+
+	mu            sync.RWMutex
+	tokens        map[string]time.Time //jti -> token expiry
+	users         map[int]time.Time    //user id -> tokens issued before this are revoked
+	lastTokenSync time.Time
+	lastUserSync  time.Time
+}
+
+func NewRevocationList(pool *pgxpool.Pool) *RevocationList {
+	return &RevocationList{
+		pool:   pool,
+		tokens: map[string]time.Time{},
+		users:  map[int]time.Time{},
+	}
+}
+
+// IsRevoked reports whether the token behind a principal has been revoked
+func (l *RevocationList) IsRevoked(p *Principal) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.tokens[p.Token_id]; ok {
+		return true
+	}
+	cutoff, ok := l.users[p.User_id]
+	return ok && !p.Issued_at.After(cutoff)
+}
+
+// RevokeToken revokes a single access token until it expires
+func (l *RevocationList) RevokeToken(p *Principal) error {
+	if err := queries.RevokeToken(l.pool, p.Token_id, p.User_id, p.Expires_at); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.tokens[p.Token_id] = p.Expires_at
+	l.mu.Unlock()
+	return nil
+}
+
+// RevokeUser revokes every access token issued to a user up to now. Token iat has
+// second precision, so a token issued later within the same second is revoked too.
+func (l *RevocationList) RevokeUser(userId int) error {
+	now := time.Now()
+	if err := queries.RevokeUserTokens(l.pool, userId, now); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if now.After(l.users[userId]) {
+		l.users[userId] = now
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// Sync loads revocations made since the last sync and forgets the ones that no longer matter
+func (l *RevocationList) Sync() error {
+	l.mu.RLock()
+	tokenSince, userSince := l.lastTokenSync, l.lastUserSync
+	l.mu.RUnlock()
+
+	tokens, err := queries.RevokedTokensSince(l.pool, tokenSince.Add(-revocationSyncOverlap))
+	if err != nil {
+		return err
+	}
+	users, err := queries.UserRevocationsSince(l.pool, userSince.Add(-revocationSyncOverlap))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range tokens {
+		l.tokens[t.Jti] = t.Expires_at
+		if t.Revoked_at.After(l.lastTokenSync) {
+			l.lastTokenSync = t.Revoked_at
+		}
+	}
+	for _, u := range users {
+		if u.Revoked_before.After(l.users[u.User_id]) {
+			l.users[u.User_id] = u.Revoked_before
+		}
+		if u.Updated_at.After(l.lastUserSync) {
+			l.lastUserSync = u.Updated_at
+		}
+	}
+	for jti, expires := range l.tokens {
+		if now.After(expires) {
+			delete(l.tokens, jti)
+		}
+	}
+	// once a cutoff is older than the token lifetime every token it covers has expired
+	for userId, cutoff := range l.users {
+		if now.Sub(cutoff) > accessTokenLifetime+time.Minute {
+			delete(l.users, userId)
+		}
+	}
+	return nil
+}
+
+// Run syncs the list every interval and prunes expired rows from Postgres; it never returns
+func (l *RevocationList) Run(interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := l.Sync(); err != nil {
+			logger.Error("revocation list sync failed", "error", err)
+		}
+		if n, err := queries.PruneRevokedTokens(l.pool); err != nil {
+			logger.Error("revoked token pruning failed", "error", err)
+		} else if n > 0 {
+			logger.Info("pruned expired revoked tokens", "count", n)
+		}
+	}
+}
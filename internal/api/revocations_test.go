@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestRevocationListIsRevoked(t *testing.T) {
+	now := time.Now()
+	l := NewRevocationList(nil)
+	l.tokens["revoked-jti"] = now.Add(time.Hour)
+	l.users[5] = now
+
+	tests := []struct {
+		name      string
+		principal Principal
+		expected  bool
+	}{
+		{"clean token", Principal{User_id: 1, Token_id: "jti", Issued_at: now}, false},
+		{"revoked jti", Principal{User_id: 1, Token_id: "revoked-jti", Issued_at: now}, true},
+		{"issued before user cutoff", Principal{User_id: 5, Token_id: "a", Issued_at: now.Add(-time.Minute)}, true},
+		{"issued at user cutoff", Principal{User_id: 5, Token_id: "b", Issued_at: now}, true},
+		{"issued after user cutoff", Principal{User_id: 5, Token_id: "c", Issued_at: now.Add(time.Second)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.IsRevoked(&tt.principal); got != tt.expected {
+				t.Errorf("expected revoked %v but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// roundTrip encodes a time as pgx sends it for a column type and reads it back
+func roundTrip(t *testing.T, oid uint32, in time.Time) time.Time {
+	t.Helper()
+	m := pgtype.NewMap()
+	buf, err := m.Encode(oid, pgtype.BinaryFormatCode, in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out time.Time
+	if err = m.Scan(oid, pgtype.BinaryFormatCode, buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRevocationCutoffOutsideUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("EAT", 3*60*60) //Africa/Nairobi
+	defer func() { time.Local = local }()
+
+	revokedAt := time.Now()
+	l := NewRevocationList(nil)
+	l.users[5] = roundTrip(t, pgtype.TimestamptzOID, revokedAt) //as Sync reads revokedbefore back
+
+	if !l.users[5].Equal(revokedAt.Truncate(time.Microsecond)) {
+		t.Errorf("expected the cutoff %v to survive the database but got %v", revokedAt, l.users[5])
+	}
+	later := &Principal{User_id: 5, Token_id: "a", Issued_at: revokedAt.Add(time.Second)}
+	if l.IsRevoked(later) {
+		t.Error("expected a token issued after the cutoff to be accepted")
+	}
+	// what a TIMESTAMP column did: the wall clock came back as UTC, three hours late
+	if shifted := roundTrip(t, pgtype.TimestampOID, revokedAt); shifted.Sub(revokedAt) < 2*time.Hour {
+		t.Errorf("expected TIMESTAMP to move the cutoff, got %v", shifted.Sub(revokedAt))
+	}
+}
+
+func TestGeneralJwtVerifierMWRejectsRevokedToken(t *testing.T) {
+	privateKey, _, err := generateTestKeys()
+	if err != nil {
+		t.Fatalf("failed to generate test keys: %v", err)
+	}
+
+	claims := newAccessClaims(7, 4)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	app := setupTestAppForMiddleware()
+	app.Keys = testKeyProvider(t, privateKey)
+	app.Revocations = NewRevocationList(nil)
+	app.Revocations.tokens[claims.ID] = claims.ExpiresAt.Time
+
+	handler := app.GeneralJwtVerifierMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestGeneralJwtVerifierMWRejectsSuspendedUser(t *testing.T) {
+	privateKey, _, err := generateTestKeys()
+	if err != nil {
+		t.Fatalf("failed to generate test keys: %v", err)
+	}
+
+	claims := newAccessClaims(7, 4)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	app := setupTestAppForMiddleware()
+	app.Keys = testKeyProvider(t, privateKey)
+	app.Revocations = NewRevocationList(nil)
+	// what AdminSuspendUser leaves behind through logoutEverywhere
+	app.Revocations.users[7] = claims.IssuedAt.Time.Add(time.Second)
+
+	handler := app.GeneralJwtVerifierMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// parseLaptopFilter builds a queries.LaptopFilter from the request query string.
+// Repeated or comma separated brand/cpu_maker/gpu_maker values are OR'ed together.
+func parseLaptopFilter(q url.Values) (queries.LaptopFilter, error) {
+	var f queries.LaptopFilter
+	var err error
+
+	f.Brands = splitMulti(q["brand"])
+	f.CPUMakers = splitMulti(q["cpu_maker"])
+	f.GPUMakers = splitMulti(q["gpu_maker"])
+	f.CPUModel = strings.TrimSpace(q.Get("cpu_model"))
+	f.CPUGen = strings.TrimSpace(q.Get("cpu_generation"))
+
+	floats := []struct {
+		key  string
+		dest **float64
+	}{
+		{"min_ram", &f.MinRam},
+		{"max_ram", &f.MaxRam},
+		{"min_ssd", &f.MinSSD},
+		{"max_ssd", &f.MaxSSD},
+		{"min_hdd", &f.MinHDD},
+		{"max_hdd", &f.MaxHDD},
+		{"min_screen", &f.MinScreen},
+		{"max_screen", &f.MaxScreen},
+		{"min_price", &f.MinPrice},
+		{"max_price", &f.MaxPrice},
+	}
+	for _, fl := range floats {
+		if *fl.dest, err = optionalFloat(q, fl.key); err != nil {
+			return f, err
+		}
+	}
+	if f.HasGPU, err = optionalBool(q, "has_gpu"); err != nil {
+		return f, err
+	}
+	if f.InStock, err = optionalBool(q, "in_stock"); err != nil {
+		return f, err
+	}
+
+	ranges := []struct {
+		name     string
+		min, max *float64
+	}{
+		{"ram", f.MinRam, f.MaxRam},
+		{"ssd", f.MinSSD, f.MaxSSD},
+		{"hdd", f.MinHDD, f.MaxHDD},
+		{"screen", f.MinScreen, f.MaxScreen},
+		{"price", f.MinPrice, f.MaxPrice},
+	}
+	for _, r := range ranges {
+		if r.min != nil && r.max != nil && *r.min > *r.max {
+			return f, fmt.Errorf("min_%s is greater than max_%s", r.name, r.name)
+		}
+	}
+
+	f.Sort = q.Get("sort")
+	if !queries.ValidLaptopSort(f.Sort) {
+		return f, fmt.Errorf("invalid sort, use one of price_asc, price_desc, newest, name")
+	}
+
+	f.Limit, f.Offset, err = parsePaging(q)
+	return f, err
+}
+
+// parsePaging reads the optional limit and page query parameters and returns limit and offset
+func parsePaging(q url.Values) (limit int, offset int, err error) {
+	limit, page := defaultSearchLimit, 1
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return 0, 0, fmt.Errorf("invalid limit, must be between 1 and %d", maxSearchLimit)
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page")
+		}
+	}
+	return limit, (page - 1) * limit, nil
+}
+
+func splitMulti(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func optionalFloat(q url.Values, key string) (*float64, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return nil, fmt.Errorf("invalid %s", key)
+	}
+	return &f, nil
+}
+
+func optionalBool(q url.Values, key string) (*bool, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", key)
+	}
+	return &b, nil
+}
+
+// SearchProducts returns a filtered, sorted and paginated list of laptops
+func (a *App) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLaptopFilter(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid search parameters", "searchproducts", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	products, total, err := queries.SearchLaptops(a.DB, filter)
+	if err != nil {
+		a.LogDatabaseError(r, "search laptops error", "searchlaptops", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Internal Server Error",
+		})
+		return
+	}
+	if products == nil {
+		products = []model.Laptop{}
+	}
+	a.Logger.Info("successful products search",
+		"time", time.Now(),
+		"results", len(products),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"products": products,
+		"total":    total,
+		"page":     filter.Offset/filter.Limit + 1,
+		"limit":    filter.Limit,
+		"message":  "request successful",
+	})
+}
+
+const maxSearchTextLength = 200
+
+// renderSnippet escapes a search snippet and turns the store highlight markers into <mark> tags
+func renderSnippet(snippet string) string {
+	return strings.NewReplacer(
+		queries.HighlightStart, "<mark>",
+		queries.HighlightStop, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// TextSearchProducts ranks laptops by relevance to the free-text "q" parameter.
+// All structured search filters are also accepted.
+func (a *App) TextSearchProducts(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" || len(text) > maxSearchTextLength {
+		a.LogBadRequest(r, "invalid search text", "textsearchproducts", fmt.Errorf("q has length %d", len(text)))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("q is required and must be at most %d characters", maxSearchTextLength),
+		})
+		return
+	}
+	filter, err := parseLaptopFilter(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid search parameters", "textsearchproducts", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	results, total, err := queries.FullTextSearchLaptops(a.DB, text, filter)
+	if err != nil {
+		a.LogDatabaseError(r, "full text search error", "fulltextsearchlaptops", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Internal Server Error",
+		})
+		return
+	}
+	if results == nil {
+		results = []queries.LaptopSearchResult{}
+	}
+	for i := range results {
+		results[i].Snippet = renderSnippet(results[i].Snippet)
+	}
+	a.Logger.Info("successful text search",
+		"time", time.Now(),
+		"results", len(results),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"products": results,
+		"total":    total,
+		"page":     filter.Offset/filter.Limit + 1,
+		"limit":    filter.Limit,
+		"message":  "request successful",
+	})
+}
+
+// ListProductFacets returns per-facet laptop counts for the filter sidebar.
+// It accepts the same filters as SearchProducts.
+func (a *App) ListProductFacets(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLaptopFilter(r.URL.Query())
+	if err != nil {
+		a.LogBadRequest(r, "invalid facet parameters", "listproductfacets", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	facets, err := queries.QueryLaptopFacets(a.DB, filter)
+	if err != nil {
+		a.LogDatabaseError(r, "facet query error", "querylaptopfacets", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Internal Server Error",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"facets":  facets,
+		"message": "request successful",
+	})
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseLaptopFilter(t *testing.T) {
+	q, _ := url.ParseQuery("brand=Dell,HP&brand=Lenovo&cpu_maker=intel&gpu_maker=nvidia,%20amd&min_ram=8&max_price=150000&has_gpu=true&sort=price_asc&limit=10&page=3")
+	f, err := parseLaptopFilter(q)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.Brands) != 3 {
+		t.Errorf("expected 3 brands but got %v", f.Brands)
+	}
+	if len(f.GPUMakers) != 2 {
+		t.Errorf("expected 2 gpu makers but got %v", f.GPUMakers)
+	}
+	if len(f.CPUMakers) != 1 || f.CPUMakers[0] != "intel" {
+		t.Errorf("unexpected cpu makers %v", f.CPUMakers)
+	}
+	if f.MinRam == nil || *f.MinRam != 8 {
+		t.Errorf("expected min_ram 8 but got %v", f.MinRam)
+	}
+	if f.MaxPrice == nil || *f.MaxPrice != 150000 {
+		t.Errorf("expected max_price 150000 but got %v", f.MaxPrice)
+	}
+	if f.MinPrice != nil {
+		t.Errorf("expected nil min_price but got %v", *f.MinPrice)
+	}
+	if f.HasGPU == nil || !*f.HasGPU {
+		t.Error("expected has_gpu true")
+	}
+	if f.InStock != nil {
+		t.Error("expected in_stock to be unset")
+	}
+	if f.Limit != 10 || f.Offset != 20 {
+		t.Errorf("expected limit 10 offset 20 but got %d %d", f.Limit, f.Offset)
+	}
+}
+
+func TestParseLaptopFilterDefaults(t *testing.T) {
+	f, err := parseLaptopFilter(url.Values{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Limit != defaultSearchLimit || f.Offset != 0 {
+		t.Errorf("expected default paging but got limit %d offset %d", f.Limit, f.Offset)
+	}
+}
+
+func TestSearchProductsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"non numeric price", "min_price=cheap"},
+		{"negative ram", "min_ram=-4"},
+		{"min above max", "min_price=500&max_price=100"},
+		{"invalid bool", "in_stock=maybe"},
+		{"unknown sort", "sort=rating"},
+		{"limit too large", "limit=1000"},
+		{"zero page", "page=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("GET", "/api/products/search?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			app.SearchProducts(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRenderSnippet(t *testing.T) {
+	raw := "ThinkPad <X1> " + queries.HighlightStart + "i7" + queries.HighlightStop
+	got := renderSnippet(raw)
+	want := "ThinkPad &lt;X1&gt; <mark>i7</mark>"
+	if got != want {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+}
+
+func TestTextSearchProductsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"missing q", ""},
+		{"blank q", "q=%20%20"},
+		{"q too long", "q=" + strings.Repeat("a", maxSearchTextLength+1)},
+		{"bad filter", "q=thinkpad&max_price=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("GET", "/api/products/search/text?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			app.TextSearchProducts(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestListProductFacetsInvalidParams(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("GET", "/api/products/facets?min_ram=32&max_ram=8", nil)
+	w := httptest.NewRecorder()
+
+	app.ListProductFacets(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
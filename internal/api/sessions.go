@@ -0,0 +1,315 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	refreshTokenCookie   = "refresh_token"
+	refreshTokenLifetime = time.Hour * 24 * 3
+)
+
+// hashToken is what gets stored for bearer secrets like refresh and reset tokens,
+// so a leaked table can't be replayed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setRefreshTokenCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+	})
+}
+
+func clearRefreshTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
+
+// startSession opens a new refresh token family for a user who just logged in,
+// mfa is carried over to every access token refreshed from it
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, userId int, mfa bool) error {
+	token, err := generateRandomString()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	session, err := queries.CreateSession(a.DB, model.Session{
+		User_id:    userId,
+		Family_id:  uuid.NewString(),
+		Token_hash: hashToken(token),
+		User_agent: userAgent,
+		Ip:         host,
+		Expires_at: time.Now().Add(refreshTokenLifetime),
+		Mfa:        mfa,
+	})
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	setRefreshTokenCookie(w, token, session.Expires_at)
+	return nil
+}
+
+// RefreshToken rotates the refresh token cookie and issues a new access token
+func (a *App) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshTokenCookie)
+	if err != nil || cookie.Value == "" {
+		a.Logger.Error("missing refresh token",
+			"handler", "refreshtoken",
+			"path", r.URL.Path,
+			"method", r.Method,
+			"status", 401,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+
+	token, err := generateRandomString()
+	if err != nil {
+		a.LogInternalServerError(r, "refresh token generation error", "refreshtoken", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	session, err := queries.RotateSession(a.DB, hashToken(cookie.Value), hashToken(token), time.Now().Add(refreshTokenLifetime))
+	if err != nil {
+		switch {
+		case errors.Is(err, queries.ErrRefreshTokenReused):
+			a.Logger.Warn("refresh token reuse detected, session family revoked",
+				"userid", session.User_id,
+				"family", session.Family_id,
+				"ip", r.RemoteAddr,
+			)
+		case errors.Is(err, queries.ErrSessionNotFound),
+			errors.Is(err, queries.ErrSessionExpired),
+			errors.Is(err, queries.ErrSessionRevoked):
+			a.Logger.Error("refresh token rejected",
+				"handler", "refreshtoken",
+				"status", 401,
+				"error", err,
+			)
+		default:
+			a.LogDatabaseError(r, "rotate session error", "rotatesession", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "internal server error",
+			})
+			return
+		}
+		clearRefreshTokenCookie(w)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "session expired, please log in again",
+		})
+		return
+	}
+	setRefreshTokenCookie(w, token, session.Expires_at)
+
+	// access level may have changed since login, so it is read fresh
+	user, err := queries.GetUser(a.DB, session.User_id)
+	if err != nil {
+		a.LogDatabaseError(r, "user lookup error", "getuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if user.Suspended {
+		clearRefreshTokenCookie(w)
+		a.rejectSuspended(w, r, user, "refreshtoken")
+		return
+	}
+	accessToken, err := a.IssueKeys(user, session.Mfa)
+	if err != nil {
+		a.LogInternalServerError(r, "jwt token issuing error", "refreshtoken", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	a.Logger.Info("access token refreshed",
+		"time", time.Now(),
+		"userid", session.User_id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(model.LoginResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+	})
+}
+
+// logoutEverywhere revokes every refresh session and access token of a user
+func (a *App) logoutEverywhere(userId int) (sessions int64, err error) {
+	sessions, err = queries.RevokeUserSessions(a.DB, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err = a.Revocations.RevokeUser(userId); err != nil {
+		return 0, err
+	}
+	return sessions, nil
+}
+
+// Logout ends the current session: the refresh cookie's session and the presented access token
+func (a *App) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" {
+		if err = queries.RevokeSessionByToken(a.DB, hashToken(cookie.Value)); err != nil {
+			a.LogDatabaseError(r, "revoke session error", "revokesessionbytoken", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "internal server error",
+			})
+			return
+		}
+	}
+	if err := a.Revocations.RevokeToken(principal); err != nil {
+		a.LogDatabaseError(r, "revoke token error", "revoketoken", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	clearRefreshTokenCookie(w)
+	a.Logger.Info("user logged out",
+		"time", time.Now(),
+		"userid", principal.User_id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "logged out",
+	})
+}
+
+// LogoutAll ends every session of the authenticated user on every device
+func (a *App) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	sessions, err := a.logoutEverywhere(userId)
+	if err != nil {
+		a.LogDatabaseError(r, "logout everywhere error", "revokeusersessions", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	clearRefreshTokenCookie(w)
+	a.Logger.Info("user logged out everywhere",
+		"time", time.Now(),
+		"userid", userId,
+		"sessions", sessions,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "logged out of all sessions",
+		"sessions": sessions,
+	})
+}
+
+// AdminForceLogout ends every session of a user, e.g. after a compromise (admin only)
+func (a *App) AdminForceLogout(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		a.LogBadRequest(r, "invalid user id", "adminforcelogout", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid user id",
+		})
+		return
+	}
+	sessions, err := a.logoutEverywhere(id)
+	if err != nil {
+		a.LogDatabaseError(r, "force logout error", "revokeusersessions", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	a.recordAudit(r, auditUserLogout, "user", id, nil, map[string]int64{"sessions": sessions})
+	adminId, _ := userIdFromContext(r.Context())
+	a.Logger.Info("user force logged out",
+		"time", time.Now(),
+		"userid", id,
+		"adminid", adminId,
+		"sessions", sessions,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "user logged out of all sessions",
+		"userid":   id,
+		"sessions": sessions,
+	})
+}
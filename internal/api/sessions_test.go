@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHashToken(t *testing.T) {
+	a, b := hashToken("token-a"), hashToken("token-b")
+	if len(a) != 64 {
+		t.Errorf("expected a 64 character hex digest but got %d characters", len(a))
+	}
+	if a != hashToken("token-a") {
+		t.Error("expected hashing to be deterministic")
+	}
+	if a == b {
+		t.Error("expected different tokens to hash differently")
+	}
+}
+
+func TestRefreshTokenWithoutCookie(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/token/refresh", nil)
+	w := httptest.NewRecorder()
+
+	app.RefreshToken(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestLogoutWithoutPrincipal(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/logout", nil)
+	w := httptest.NewRecorder()
+
+	app.Logout(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAdminForceLogoutInvalidId(t *testing.T) {
+	for _, id := range []string{"abc", "0", "-3"} {
+		app := setupTestApp()
+		req := httptest.NewRequest("POST", "/api/admin/users/"+id+"/logout", nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+
+		app.AdminForceLogout(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("id %q: expected status %d but got %d", id, http.StatusBadRequest, w.Code)
+		}
+	}
+}
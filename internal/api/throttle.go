@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lapbytes/internal/store/queries"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dummyPasswordHash is compared against when the email is unknown, so a failed
+// login takes as long whether or not the account exists
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("lapbytes-no-such-user")
+	return hash
+})
+
+// loginBackoff returns how long the caller must wait before another login attempt.
+// Throttle store errors are logged and let the attempt through.
+func (a *App) loginBackoff(r *http.Request, email string, ip string) time.Duration {
+	if a.Limiter == nil {
+		return 0
+	}
+	wait, err := a.Limiter.Check(r.Context(), email, ip)
+	if err != nil {
+		a.LogDatabaseError(r, "login throttle check error", "getloginthrottle", err)
+		return 0
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login against the account and IP
+func (a *App) recordLoginFailure(r *http.Request, email string, ip string) {
+	if a.Limiter == nil {
+		return
+	}
+	if err := a.Limiter.Failure(context.Background(), email, ip); err != nil {
+		a.LogDatabaseError(r, "login throttle update error", "failloginthrottle", err)
+	}
+}
+
+// recordLoginSuccess clears the failures of the account
+func (a *App) recordLoginSuccess(r *http.Request, email string) {
+	if a.Limiter == nil {
+		return
+	}
+	if err := a.Limiter.Success(context.Background(), email); err != nil {
+		a.LogDatabaseError(r, "login throttle reset error", "resetloginthrottle", err)
+	}
+}
+
+// tooManyLoginAttempts is the same for every email, known or not
+func tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "too many failed login attempts, please try again later",
+	})
+}
+
+// AdminUnlockUser clears the failed login counter and lockout of an account (admin only)
+func (a *App) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		a.LogBadRequest(r, "invalid user id", "adminunlockuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid user id",
+		})
+		return
+	}
+	user, err := queries.GetUser(a.DB, id)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrUserNotFound) {
+			status, msg = http.StatusBadRequest, "user id does not exist"
+		}
+		a.LogDatabaseError(r, "get user query error", "adminunlockuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	if err = a.Limiter.Unlock(r.Context(), user.Email); err != nil {
+		a.LogDatabaseError(r, "unlock account error", "resetloginthrottle", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	a.recordAudit(r, auditUserUnlock, "user", id, nil, nil)
+	adminId, _ := userIdFromContext(r.Context())
+	a.Logger.Info("account unlocked",
+		"time", time.Now(),
+		"userid", id,
+		"adminid", adminId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "account unlocked",
+		"userid":  id,
+	})
+}
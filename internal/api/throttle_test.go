@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"lapbytes/internal/lockout"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoginUserThrottled(t *testing.T) {
+	app := setupTestApp()
+	app.Limiter = lockout.New(lockout.NewMemory())
+	for i := 0; i < lockout.DefaultAccountPolicy.LockoutAfter; i++ {
+		app.Limiter.Failure(context.Background(), "jane@example.com", "192.0.2.1")
+	}
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"jane@example.com","password":"whatever1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "198.51.100.7:4242"
+	w := httptest.NewRecorder()
+
+	app.LoginUser(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 but got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "900" {
+		t.Errorf("expected Retry-After 900 but got %q", w.Header().Get("Retry-After"))
+	}
+	var response map[string]string
+	json.NewDecoder(w.Body).Decode(&response)
+	if response["error"] != "too many failed login attempts, please try again later" {
+		t.Errorf("unexpected error %q", response["error"])
+	}
+}
+
+func TestAdminUnlockUserInvalidId(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/admin/users/abc/unlock", nil)
+	req.SetPathValue("id", "abc")
+	w := httptest.NewRecorder()
+
+	app.AdminUnlockUser(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
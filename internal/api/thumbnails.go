@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lapbytes/internal/imaging"
+	"lapbytes/internal/storage"
+	"lapbytes/internal/store/queries"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// thumbnailSizes are the bounding squares thumbnails are made for, in pixels
+var thumbnailSizes = []int{160, 320, 640}
+
+const thumbnailQuality = 82
+
+// thumbnailKey is where the thumbnail of one size of an image is stored
+func thumbnailKey(key string, size int) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + strconv.Itoa(size) + ".jpg"
+}
+
+// Thumbnailer makes thumbnails of uploaded images in the background. Work is claimed in the
+// database, so several instances can run one and an image queued twice is only done once.
+type Thumbnailer struct {
+	Pool   *pgxpool.Pool
+	Store  storage.Store
+	Logger *slog.Logger
+	jobs   chan int
+}
+
+func NewThumbnailer(pool *pgxpool.Pool, store storage.Store, logger *slog.Logger) *Thumbnailer {
+	return &Thumbnailer{Pool: pool, Store: store, Logger: logger, jobs: make(chan int, 256)}
+}
+
+// Enqueue asks for thumbnails of an image without waiting. When the queue is full the image
+// stays pending and the next sweep picks it up.
+func (t *Thumbnailer) Enqueue(imageId int) {
+	select {
+	case t.jobs <- imageId:
+	default:
+	}
+}
+
+// Run starts workers and every sweep queues images still waiting, such as those uploaded
+// before a restart or claimed by a worker that died; it never returns
+func (t *Thumbnailer) Run(workers int, sweep time.Duration) {
+	for range workers {
+		go func() {
+			for id := range t.jobs {
+				t.process(id)
+			}
+		}()
+	}
+	for {
+		ids, err := queries.PendingThumbnailJobs(t.Pool, cap(t.jobs))
+		if err != nil {
+			t.Logger.Error("pending thumbnails query failed", "error", err)
+		}
+		for _, id := range ids {
+			t.Enqueue(id)
+		}
+		time.Sleep(sweep)
+	}
+}
+
+// process makes every thumbnail of one image. A storage failure leaves the claim to expire so
+// a later sweep retries; an image that can't be decoded is marked failed.
+func (t *Thumbnailer) process(imageId int) {
+	image, ok, err := queries.ClaimThumbnailJob(t.Pool, imageId)
+	if err != nil {
+		t.Logger.Error("thumbnail claim failed", "imageid", imageId, "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	body, err := t.Store.Get(ctx, image.Key)
+	if err != nil {
+		t.Logger.Error("thumbnail source read failed", "imageid", imageId, "key", image.Key, "error", err)
+		return
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Logger.Error("thumbnail source read failed", "imageid", imageId, "key", image.Key, "error", err)
+		return
+	}
+	src, _, err := imaging.Decode(data)
+	if err != nil {
+		t.Logger.Error("thumbnail source is not a usable image", "imageid", imageId, "key", image.Key, "error", err)
+		if err = queries.FailThumbnailJob(t.Pool, imageId); err != nil {
+			t.Logger.Error("thumbnail failure not recorded", "imageid", imageId, "error", err)
+		}
+		return
+	}
+
+	thumbnails := make(map[string]string, len(thumbnailSizes))
+	for _, size := range thumbnailSizes {
+		var b bytes.Buffer
+		if err = imaging.EncodeJPEG(&b, imaging.Fit(src, size), thumbnailQuality); err != nil {
+			t.Logger.Error("thumbnail encode failed", "imageid", imageId, "size", size, "error", err)
+			return
+		}
+		key := thumbnailKey(image.Key, size)
+		if err = t.Store.Put(ctx, key, b.Bytes(), "image/jpeg"); err != nil {
+			t.Logger.Error("thumbnail write failed", "imageid", imageId, "key", key, "error", err)
+			return
+		}
+		thumbnails[strconv.Itoa(size)] = mediaURL(key)
+	}
+
+	err = queries.CompleteThumbnailJob(t.Pool, imageId, thumbnails)
+	if errors.Is(err, queries.ErrImageNotFound) {
+		// deleted while we worked, its files went with it except what we just wrote
+		deleteImageFiles(t.Store, t.Logger, image.Key)
+		return
+	}
+	if err != nil {
+		t.Logger.Error("thumbnail completion not recorded", "imageid", imageId, "error", err)
+		return
+	}
+	t.Logger.Info("thumbnails generated",
+		"time", time.Now(),
+		"imageid", imageId,
+		"productid", image.Product_id,
+	)
+}
+
+// deleteImageFiles removes an original and all its thumbnails, logging what can't be removed
+func deleteImageFiles(store storage.Store, logger *slog.Logger, key string) {
+	keys := []string{key}
+	for _, size := range thumbnailSizes {
+		keys = append(keys, thumbnailKey(key, size))
+	}
+	for _, k := range keys {
+		if err := store.Delete(context.Background(), k); err != nil {
+			logger.Error("image file not deleted", "key", k, "error", err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"lapbytes/internal/totp"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer                = "LapBytes"
+	loginChallengeLifetime    = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+	recoveryCodeCount         = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns recovery codes formatted for the user along with the hashes to store
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts a recovery code however the user typed it
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkTotpCode validates a code and uses up its time step so it can't be replayed
+func (a *App) checkTotpCode(userId int, secret string, lastStep int64, code string) (bool, error) {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+	return queries.UseTotpStep(a.DB, userId, step)
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code of a user with TOTP enabled
+func (a *App) verifySecondFactor(userId int, code string, recoveryCode string) (bool, error) {
+	secret, enabled, lastStep, err := queries.GetTotp(a.DB, userId)
+	if err != nil || !enabled {
+		return false, err
+	}
+	if recoveryCode != "" {
+		return queries.UseRecoveryCode(a.DB, userId, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+	return a.checkTotpCode(userId, secret, lastStep, code)
+}
+
+// startLoginChallenge answers a correct password of a TOTP enabled account with a challenge instead of tokens
+func (a *App) startLoginChallenge(w http.ResponseWriter, r *http.Request, user model.User) {
+	challenge, err := generateRandomString()
+	if err == nil {
+		err = queries.CreateLoginChallenge(a.DB, user.Id, hashToken(challenge), time.Now().Add(loginChallengeLifetime))
+	}
+	if err != nil {
+		a.LogInternalServerError(r, "login challenge error", "loginuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "An Error Occured During Login",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(model.LoginChallengeResponse{
+		Totp_required: true,
+		Challenge:     challenge,
+		Expires_at:    time.Now().Add(loginChallengeLifetime),
+	})
+}
+
+// LoginTotp exchanges a login challenge and a TOTP or recovery code for tokens
+func (a *App) LoginTotp(w http.ResponseWriter, r *http.Request) {
+	type totpLoginRequest struct {
+		Challenge     string `json:"challenge"`
+		Code          string `json:"code"`
+		Recovery_code string `json:"recovery_code"`
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		a.LogBadRequest(r, "invalid content-type", "logintotp", fmt.Errorf("non json request"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "bad request, accepts JSON only",
+		})
+		return
+	}
+	var req totpLoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Challenge == "" || (req.Code == "" && req.Recovery_code == "") {
+		a.LogBadRequest(r, "missing challenge or code", "logintotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "challenge and code or recovery_code are required",
+		})
+		return
+	}
+
+	challengeHash := hashToken(req.Challenge)
+	userId, err := queries.GetLoginChallenge(a.DB, challengeHash, loginChallengeMaxAttempts)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrLoginChallengeInvalid) {
+			status, msg = http.StatusUnauthorized, "login expired, please log in again"
+		} else {
+			a.LogDatabaseError(r, "login challenge lookup error", "getloginchallenge", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	user, err := queries.GetUser(a.DB, userId)
+	if err != nil {
+		a.LogDatabaseError(r, "user lookup error", "getuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	// codes count against the same limits as passwords, a challenge only allows a few
+	// tries but a new one is a password away
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if wait := a.loginBackoff(r, user.Email, host); wait > 0 {
+		a.Logger.Warn("login throttled",
+			"handler", "logintotp",
+			"status", 429,
+			"ip", host,
+			"retryafter", wait,
+		)
+		tooManyLoginAttempts(w, wait)
+		return
+	}
+	ok, err := a.verifySecondFactor(userId, req.Code, req.Recovery_code)
+	if err != nil {
+		a.LogDatabaseError(r, "second factor check error", "gettotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if !ok {
+		if err = queries.FailLoginChallenge(a.DB, challengeHash); err != nil {
+			a.LogDatabaseError(r, "login challenge update error", "failloginchallenge", err)
+		}
+		a.recordLoginFailure(r, user.Email, host)
+		a.Logger.Error("invalid two-factor code",
+			"handler", "logintotp",
+			"status", 401,
+			"userid", userId,
+			"ip", host,
+		)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid code",
+		})
+		return
+	}
+	if err = queries.CompleteLoginChallenge(a.DB, challengeHash); err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrLoginChallengeInvalid) {
+			status, msg = http.StatusUnauthorized, "login expired, please log in again"
+		} else {
+			a.LogDatabaseError(r, "login challenge update error", "completeloginchallenge", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	a.recordLoginSuccess(r, user.Email)
+	if a.rejectSuspended(w, r, user, "logintotp") {
+		return
+	}
+	if req.Recovery_code != "" {
+		remaining, _ := queries.CountRecoveryCodes(a.DB, userId)
+		a.Logger.Warn("recovery code used to log in",
+			"time", time.Now(),
+			"userid", userId,
+			"remaining", remaining,
+		)
+	}
+	a.completeLogin(w, r, user, true, "logintotp")
+}
+
+// SetupTotp starts two-factor enrolment, returning the secret and the otpauth:// URI for a QR code
+func (a *App) SetupTotp(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	user, err := queries.GetUser(a.DB, userId)
+	if err != nil {
+		a.LogDatabaseError(r, "user lookup error", "getuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if user.Totp_enabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "two-factor authentication is already enabled",
+		})
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.LogInternalServerError(r, "totp secret generation error", "setuptotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if err = queries.SetTotpSecret(a.DB, userId, secret); err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrTotpAlreadyEnabled) {
+			status, msg = http.StatusConflict, "two-factor authentication is already enabled"
+		} else {
+			a.LogDatabaseError(r, "totp setup error", "settotpsecret", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTotp confirms enrolment with a first code and returns the recovery codes, which are shown only once
+func (a *App) EnableTotp(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		a.LogBadRequest(r, "missing code", "enabletotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "code is required",
+		})
+		return
+	}
+	secret, enabled, _, err := queries.GetTotp(a.DB, userId)
+	if err != nil {
+		a.LogDatabaseError(r, "totp lookup error", "gettotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if enabled || secret == "" {
+		msg := "two-factor authentication is already enabled"
+		if !enabled {
+			msg = "no two-factor enrolment in progress"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	step, ok := totp.Validate(secret, req.Code, time.Now())
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid code",
+		})
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = queries.EnableTotp(a.DB, userId, step, hashes)
+	}
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrTotpNotPending) {
+			status, msg = http.StatusConflict, "no two-factor enrolment in progress"
+		} else {
+			a.LogDatabaseError(r, "totp enable error", "enabletotp", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	a.Logger.Info("two-factor authentication enabled",
+		"time", time.Now(),
+		"userid", userId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "two-factor authentication enabled, store the recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTotp turns two-factor authentication off, which only shoppers may do
+func (a *App) DisableTotp(w http.ResponseWriter, r *http.Request) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	if principal.Access_level <= 1 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "two-factor authentication is mandatory for admin accounts",
+		})
+		return
+	}
+	var req struct {
+		Code          string `json:"code"`
+		Recovery_code string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.Recovery_code == "") {
+		a.LogBadRequest(r, "missing code", "disabletotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "code or recovery_code is required",
+		})
+		return
+	}
+	ok, err := a.verifySecondFactor(principal.User_id, req.Code, req.Recovery_code)
+	if err != nil {
+		a.LogDatabaseError(r, "second factor check error", "gettotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "invalid code",
+		})
+		return
+	}
+	if err = queries.DisableTotp(a.DB, principal.User_id); err != nil {
+		a.LogDatabaseError(r, "totp disable error", "disabletotp", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	a.Logger.Info("two-factor authentication disabled",
+		"time", time.Now(),
+		"userid", principal.User_id,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "two-factor authentication disabled",
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes and hashes but got %d and %d", recoveryCodeCount, len(codes), len(hashes))
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected code format %q", code)
+		}
+		if hashes[i] != hashToken(normalizeRecoveryCode(strings.ToUpper(code))) {
+			t.Errorf("hash of %q does not match its normalized form", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestLoginTotpValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"non json", "text/plain", `{}`},
+		{"malformed body", "application/json", `{`},
+		{"missing challenge", "application/json", `{"code":"123456"}`},
+		{"missing code", "application/json", `{"challenge":"abc"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("POST", "/api/login/totp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			app.LoginTotp(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 but got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestTotpEndpointsWithoutPrincipal(t *testing.T) {
+	app := setupTestApp()
+	handlers := map[string]http.HandlerFunc{
+		"setup":   app.SetupTotp,
+		"enable":  app.EnableTotp,
+		"disable": app.DisableTotp,
+	}
+	for name, handler := range handlers {
+		req := httptest.NewRequest("POST", "/api/account/totp/"+name, strings.NewReader(`{"code":"123456"}`))
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401 but got %d", name, w.Code)
+		}
+	}
+}
+
+func TestEnableTotpMissingCode(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/account/totp/enable", strings.NewReader(`{}`))
+	req = req.WithContext(context.WithValue(req.Context(), principalKey, &Principal{User_id: 7, Access_level: 4}))
+	w := httptest.NewRecorder()
+
+	app.EnableTotp(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestDisableTotpMandatoryForAdmins(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/account/totp/disable", strings.NewReader(`{"code":"123456"}`))
+	req = req.WithContext(context.WithValue(req.Context(), principalKey, &Principal{User_id: 1, Access_level: 1, Mfa: true}))
+	w := httptest.NewRecorder()
+
+	app.DisableTotp(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 but got %d", w.Code)
+	}
+}
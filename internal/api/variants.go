@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"lapbytes/internal/diskcache"
+	"lapbytes/internal/imaging"
+	"lapbytes/internal/storage"
+	"lapbytes/internal/store/queries"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageVariants are the sizes served by /img/{id}/{variant}, as the bounding square in pixels
+var imageVariants = map[string]int{
+	"thumb":  160,
+	"card":   480,
+	"detail": 960,
+	"zoom":   1600,
+}
+
+const variantQuality = 82
+
+// Formats variants are encoded in: WebP for clients that accept it, it is smaller, JPEG otherwise
+const (
+	variantJPEG = "jpeg"
+	variantWebP = "webp"
+)
+
+var variantContentTypes = map[string]string{
+	variantJPEG: "image/jpeg",
+	variantWebP: "image/webp",
+}
+
+var errVariantsBusy = errors.New("too many images being resized")
+
+// variantKey names a rendering of an image at a size in a format. It is the cache file name and the
+// ETag: stored keys are never reused, so the same inputs always give the same bytes.
+func variantKey(sourceKey string, size int, format string) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%s|%d", sourceKey, size, format, variantQuality))
+	return hex.EncodeToString(sum[:16])
+}
+
+// variantFormat picks the format for an Accept header. WebP has to be listed by name, every browser
+// that can show it does, while */* also comes from clients that predate it.
+func variantFormat(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "image/webp") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+					return variantJPEG
+				}
+			}
+		}
+		return variantWebP
+	}
+	return variantJPEG
+}
+
+// ImageVariants resizes images on request. Work goes to a fixed number of workers through a
+// bounded queue, so a burst of requests for uncached variants gets 503s instead of every core;
+// requests for a variant already being made wait for it rather than making it again.
+type ImageVariants struct {
+	Store  storage.Store
+	Cache  *diskcache.Cache
+	Logger *slog.Logger
+	jobs   chan *variantJob
+
+	mu       sync.Mutex
+	inflight map[string]*variantJob
+}
+
+type variantJob struct {
+	ctx       context.Context //of the request that asked first, skipped if it is gone
+	key       string
+	sourceKey string
+	size      int
+	format    string
+
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewImageVariants queues at most queue variants beyond those being made
+func NewImageVariants(store storage.Store, cache *diskcache.Cache, logger *slog.Logger, queue int) *ImageVariants {
+	return &ImageVariants{
+		Store:    store,
+		Cache:    cache,
+		Logger:   logger,
+		jobs:     make(chan *variantJob, queue),
+		inflight: map[string]*variantJob{},
+	}
+}
+
+// Run makes queued variants on workers goroutines; it never returns
+func (v *ImageVariants) Run(workers int) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range v.jobs {
+				v.process(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Get returns a variant in a format from the cache, or has a worker make it. It returns
+// errVariantsBusy when the queue is full.
+func (v *ImageVariants) Get(ctx context.Context, sourceKey string, size int, format string) ([]byte, error) {
+	key := variantKey(sourceKey, size, format)
+	for {
+		if data, ok := v.Cache.Get(key); ok {
+			return data, nil
+		}
+		v.mu.Lock()
+		job, waiting := v.inflight[key]
+		if !waiting {
+			job = &variantJob{ctx: ctx, key: key, sourceKey: sourceKey, size: size, format: format, done: make(chan struct{})}
+			select {
+			case v.jobs <- job:
+				v.inflight[key] = job
+			default:
+				v.mu.Unlock()
+				return nil, errVariantsBusy
+			}
+		}
+		v.mu.Unlock()
+
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if waiting && job.ctx.Err() != nil && ctx.Err() == nil {
+			continue //the request that queued it went away before a worker got to it
+		}
+		return job.data, job.err
+	}
+}
+
+func (v *ImageVariants) process(job *variantJob) {
+	defer func() {
+		v.mu.Lock()
+		delete(v.inflight, job.key)
+		v.mu.Unlock()
+		close(job.done)
+	}()
+	if job.err = job.ctx.Err(); job.err != nil {
+		return
+	}
+	job.data, job.err = v.render(job.ctx, job.sourceKey, job.size, job.format)
+	if job.err != nil {
+		return
+	}
+	if err := v.Cache.Put(job.key, job.data); err != nil {
+		v.Logger.Error("image variant not cached", "key", job.sourceKey, "size", job.size, "format", job.format, "error", err)
+	}
+}
+
+func (v *ImageVariants) render(ctx context.Context, sourceKey string, size int, format string) ([]byte, error) {
+	body, err := v.Store.Get(ctx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := imaging.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if format == variantWebP {
+		err = imaging.EncodeWebP(&b, imaging.Fit(src, size), variantQuality)
+	} else {
+		err = imaging.EncodeJPEG(&b, imaging.Fit(src, size), variantQuality)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// variantURL is the address of a variant at one version of a laptop. Versions are never reused,
+// so what it serves never changes.
+func variantURL(productId int, variant string, version int) string {
+	return fmt.Sprintf("/img/%d/%s?v=%d", productId, variant, version)
+}
+
+// ServeImageVariant serves the first image of a laptop resized to a named variant, as WebP when the
+// Accept header lists it and JPEG otherwise. The URL names the laptop's version with ?v=: the
+// current one is cached for good, others are redirected to it as the first image may have changed.
+// The ETag still answers 304 without resizing anything.
+func (a *App) ServeImageVariant(w http.ResponseWriter, r *http.Request) {
+	variant := r.PathValue("variant")
+	size, ok := imageVariants[variant]
+	productId, err := strconv.Atoi(r.PathValue("id"))
+	if !ok || err != nil || productId < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	image, version, err := queries.ProductCoverImage(a.DB, productId)
+	if errors.Is(err, queries.ErrImageNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.LogDatabaseError(r, "cover image query error", "productcoverimage", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("v") != strconv.Itoa(version) {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, variantURL(productId, variant, version), http.StatusFound)
+		return
+	}
+
+	format := variantFormat(r.Header.Get("Accept"))
+	etag := `"` + variantKey(image.Key, size, format) + `"`
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		setVariantCaching(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	data, err := a.Variants.Get(r.Context(), image.Key, size, format)
+	switch {
+	case errors.Is(err, errVariantsBusy):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "busy, please retry", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		a.Logger.Error("image variant failed", "id", productId, "key", image.Key, "size", size, "format", format, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	setVariantCaching(w, etag)
+	w.Header().Set("Content-Type", variantContentTypes[format])
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// setVariantCaching marks a variant cacheable for good. Caches keep one copy per Accept header as
+// the format depends on it.
+func setVariantCaching(w http.ResponseWriter, etag string) {
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+}
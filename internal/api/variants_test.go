@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"lapbytes/internal/diskcache"
+	"lapbytes/internal/storage"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func newTestVariants(t *testing.T, queue int) (*ImageVariants, *storage.Local) {
+	t.Helper()
+	cache, err := diskcache.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &storage.Local{Dir: t.TempDir()}
+	return NewImageVariants(store, cache, slog.New(slog.NewTextHandler(os.Stderr, nil)), queue), store
+}
+
+func TestServeImageVariantNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		variant string
+	}{
+		{"unknown variant", "1", "huge"},
+		{"bad id", "x", "card"},
+		{"zero id", "0", "card"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp()
+			req := httptest.NewRequest("GET", "/img/"+tt.id+"/"+tt.variant, nil)
+			req.SetPathValue("id", tt.id)
+			req.SetPathValue("variant", tt.variant)
+			w := httptest.NewRecorder()
+
+			app.ServeImageVariant(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status %d but got %d", http.StatusNotFound, w.Code)
+			}
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`*`, true},
+		{`"abd"`, false},
+		{`abc`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("%q: expected %v but got %v", tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	key := variantKey("products/1/a.jpg", 480, variantJPEG)
+	if key != variantKey("products/1/a.jpg", 480, variantJPEG) {
+		t.Error("expected the same key for the same image, size and format")
+	}
+	if key == variantKey("products/1/a.jpg", 960, variantJPEG) || key == variantKey("products/1/b.jpg", 480, variantJPEG) {
+		t.Error("expected different keys for a different size or image")
+	}
+	if key == variantKey("products/1/a.jpg", 480, variantWebP) {
+		t.Error("expected a different key, and so ETag, for WebP")
+	}
+}
+
+func TestVariantFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", variantWebP},
+		{"image/webp", variantWebP},
+		{"IMAGE/WEBP;q=0.5", variantWebP},
+		{"image/webp;q=0, image/jpeg", variantJPEG},
+		{"image/png,image/*;q=0.8,*/*;q=0.5", variantJPEG},
+		{"*/*", variantJPEG},
+		{"", variantJPEG},
+	}
+	for _, tt := range tests {
+		if got := variantFormat(tt.accept); got != tt.want {
+			t.Errorf("%q: expected %s but got %s", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestVariantURL(t *testing.T) {
+	if got := variantURL(12, "card", 3); got != "/img/12/card?v=3" {
+		t.Errorf("expected /img/12/card?v=3 but got %s", got)
+	}
+}
+
+func TestImageVariantsGet(t *testing.T) {
+	variants, store := newTestVariants(t, 4)
+	go variants.Run(2)
+	var src bytes.Buffer
+	png.Encode(&src, gradientImage(800, 400))
+	store.Put(context.Background(), "products/1/a.png", src.Bytes(), "image/png")
+
+	data, err := variants.Get(context.Background(), "products/1/a.png", 160, variantJPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected a JPEG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 160 || b.Dy() != 80 {
+		t.Errorf("expected 160x80 but got %dx%d", b.Dx(), b.Dy())
+	}
+
+	data, err = variants.Get(context.Background(), "products/1/a.png", 160, variantWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected a WebP: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 160 || b.Dy() != 80 {
+		t.Errorf("expected 160x80 but got %dx%d", b.Dx(), b.Dy())
+	}
+
+	store.Delete(context.Background(), "products/1/a.png")
+	cached, err := variants.Get(context.Background(), "products/1/a.png", 160, variantWebP)
+	if err != nil || !bytes.Equal(cached, data) {
+		t.Errorf("expected the variant from the cache, got %v", err)
+	}
+	if _, err = variants.Get(context.Background(), "products/1/a.png", 320, variantJPEG); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected storage.ErrNotFound for a missing original but got %v", err)
+	}
+}
+
+func TestImageVariantsBusy(t *testing.T) {
+	variants, _ := newTestVariants(t, 0) //no queue and no workers running
+
+	if _, err := variants.Get(context.Background(), "products/1/a.png", 160, variantJPEG); !errors.Is(err, errVariantsBusy) {
+		t.Errorf("expected errVariantsBusy but got %v", err)
+	}
+}
+
+func TestImageVariantsSkipsAbandonedRequests(t *testing.T) {
+	variants, _ := newTestVariants(t, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := variants.Get(ctx, "products/1/a.png", 160, variantJPEG); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled but got %v", err)
+	}
+	job := <-variants.jobs
+	variants.process(job)
+	if !errors.Is(job.err, context.Canceled) {
+		t.Errorf("expected the job to be skipped but got %v", job.err)
+	}
+	if len(variants.inflight) != 0 {
+		t.Error("expected the job to be forgotten")
+	}
+}
+
+// gradientImage is a w x h image that isn't a single color
+func gradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for n := range img.Pix {
+		img.Pix[n] = uint8(n)
+	}
+	return img
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/mail"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	emailVerificationLifetime  = 48 * time.Hour
+	verificationResendInterval = time.Minute
+)
+
+// sendEmailVerification emails a new verification link to the user
+func (a *App) sendEmailVerification(user model.User) error {
+	token, err := generateRandomString()
+	if err != nil {
+		return err
+	}
+	if err = queries.CreateEmailVerification(a.DB, user.Id, hashToken(token), time.Now().Add(emailVerificationLifetime)); err != nil {
+		return fmt.Errorf("create email verification: %w", err)
+	}
+	link := strings.TrimRight(a.BaseURL, "/") + "/api/verify-email?token=" + url.QueryEscape(token)
+	return a.Mailer.Send(context.Background(), mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your LapBytes email address",
+		Body: fmt.Sprintf("Hi %s,\n\nWelcome to LapBytes! Confirm your email address to be able to place orders:\n\n%s\n\n"+
+			"The link expires in %d hours.\n", user.Username, link, int(emailVerificationLifetime.Hours())),
+	})
+}
+
+// VerifyEmail marks the email of the account behind a verification link as verified
+func (a *App) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		a.LogBadRequest(r, "missing verification token", "verifyemail", fmt.Errorf("empty token"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "token is required",
+		})
+		return
+	}
+	userId, err := queries.ConsumeEmailVerification(a.DB, hashToken(token))
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, queries.ErrVerificationTokenInvalid) {
+			status, msg = http.StatusBadRequest, "invalid or expired verification link"
+		} else {
+			a.LogDatabaseError(r, "consume email verification error", "consumeemailverification", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": msg,
+		})
+		return
+	}
+	a.Logger.Info("email verified",
+		"time", time.Now(),
+		"userid", userId,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "email verified",
+	})
+}
+
+// ResendVerification emails a fresh verification link to the authenticated user
+func (a *App) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "not authorized",
+		})
+		return
+	}
+	user, err := queries.GetUser(a.DB, userId)
+	if err != nil {
+		a.LogDatabaseError(r, "user lookup error", "getuser", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "internal server error",
+		})
+		return
+	}
+	if user.Email_verified {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "email already verified",
+		})
+		return
+	}
+	if user.Verification_sent_at != nil && time.Since(*user.Verification_sent_at) < verificationResendInterval {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", fmt.Sprint(int(verificationResendInterval.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "a verification email was just sent, please wait a minute",
+		})
+		return
+	}
+	if err = a.sendEmailVerification(user); err != nil {
+		a.LogInternalServerError(r, "verification email error", "resendverification", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "could not send verification email",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "verification email sent",
+	})
+}
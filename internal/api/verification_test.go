@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidEmail(t *testing.T) {
+	tests := []struct {
+		email    string
+		expected bool
+	}{
+		{"jane@example.com", true},
+		{"jane.doe+shop@mail.example.co.ke", true},
+		{"", false},
+		{"not an email", false},
+		{"Jane <jane@example.com>", false},
+		{"jane@", false},
+		{strings.Repeat("a", 100) + "@example.com", false},
+	}
+	for _, tt := range tests {
+		if got := validEmail(tt.email); got != tt.expected {
+			t.Errorf("validEmail(%q): expected %v but got %v", tt.email, tt.expected, got)
+		}
+	}
+}
+
+func TestRegisterUserInvalidEmail(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/register", strings.NewReader(`{"username":"u","email":"nope","password":"password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	app.RegisterUser(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestVerifyEmailMissingToken(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("GET", "/api/verify-email", nil)
+	w := httptest.NewRecorder()
+
+	app.VerifyEmail(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestResendVerificationWithoutPrincipal(t *testing.T) {
+	app := setupTestApp()
+	req := httptest.NewRequest("POST", "/api/verify-email/resend", nil)
+	w := httptest.NewRecorder()
+
+	app.ResendVerification(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 but got %d", w.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"lapbytes/internal/model"
+	"testing"
+	"time"
+)
+
+func chain(n int) []model.AuditEntry {
+	prev := Genesis
+	var entries []model.AuditEntry
+	for i := 0; i < n; i++ {
+		e := model.AuditEntry{
+			Id:          int64(i + 1),
+			Actor_id:    1,
+			Action:      "product.delete",
+			Target_type: "product",
+			Target_id:   "42",
+			Ip:          "10.0.0.1",
+			Before:      json.RawMessage(`{"name":"ThinkPad"}`),
+			Created_at:  time.Unix(1700000000+int64(i), 0),
+			Prev_hash:   prev,
+		}
+		e.Hash = Hash(prev, e)
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func verify(entries []model.AuditEntry) error {
+	v := NewVerifier()
+	for _, e := range entries {
+		if err := v.Check(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	entries := chain(5)
+	v := NewVerifier()
+	for _, e := range entries {
+		if err := v.Check(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if v.Checked != 5 || v.LastId != 5 || v.Head != entries[4].Hash {
+		t.Errorf("unexpected verifier state %+v", v)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]model.AuditEntry) []model.AuditEntry
+		id     int64
+	}{
+		{"modified content", func(e []model.AuditEntry) []model.AuditEntry {
+			e[2].Actor_id = 7
+			return e
+		}, 3},
+		{"modified snapshot", func(e []model.AuditEntry) []model.AuditEntry {
+			e[1].Before = json.RawMessage(`{"name":"MacBook"}`)
+			return e
+		}, 2},
+		{"removed entry", func(e []model.AuditEntry) []model.AuditEntry {
+			return append(e[:2], e[3:]...)
+		}, 4},
+		{"reordered entries", func(e []model.AuditEntry) []model.AuditEntry {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}, 3},
+		{"rehashed entry", func(e []model.AuditEntry) []model.AuditEntry {
+			e[1].Action = "user.delete"
+			e[1].Hash = Hash(e[1].Prev_hash, e[1])
+			return e
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.tamper(chain(5)))
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("expected a chain error but got %v", err)
+			}
+			if chainErr.Id != tt.id {
+				t.Errorf("expected the break at entry %d but got %d", tt.id, chainErr.Id)
+			}
+		})
+	}
+}
+
+func TestHashIsUnambiguous(t *testing.T) {
+	a := model.AuditEntry{Action: "ab", Target_type: "c"}
+	b := model.AuditEntry{Action: "a", Target_type: "bc"}
+	if Hash(Genesis, a) == Hash(Genesis, b) {
+		t.Error("expected different field splits to hash differently")
+	}
+}
@@ -0,0 +1,176 @@
+// Package diskcache keeps generated files in a directory up to a total size, removing the least
+// recently used ones to make room. Files already in the directory are picked up on Open, oldest
+// first, so the cache survives restarts.
+package diskcache
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidKey = errors.New("cache keys are letters, digits, '-' and '_'")
+
+// Cache is safe for concurrent use. Entries are files named after their key in one directory.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List //of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Open uses dir, creating it if needed, for at most maxBytes of files
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		entry
+		used time.Time
+	}
+	var existing []found
+	for _, f := range files {
+		if !f.Type().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), ".tmp-") { //left by a write that never finished
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || !validKey(f.Name()) {
+			continue
+		}
+		existing = append(existing, found{entry{f.Name(), info.Size()}, info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].used.Before(existing[j].used) })
+	for _, f := range existing {
+		c.entries[f.key] = c.order.PushFront(&entry{f.key, f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func validKey(key string) bool {
+	if key == "" || len(key) > 200 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the file stored under key and marks it as recently used
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	path := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil { //evicted meanwhile or removed by hand
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && errors.Is(err, os.ErrNotExist) {
+			c.remove(e)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now) //so Open after a restart knows it was used
+	return data, true
+}
+
+// Put stores data under key, evicting the least recently used files when over the limit.
+// Data larger than the whole cache is not stored.
+func (c *Cache) Put(key string, data []byte) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		return err
+	}
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*entry).size
+		e.Value.(*entry).size = int64(len(data))
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[key] = c.order.PushFront(&entry{key, int64(len(data))})
+	}
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Size returns the bytes and number of files in the cache
+func (c *Cache) Size() (bytes int64, files int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size, len(c.entries)
+}
+
+// evict removes least recently used files until the cache fits, c.mu must be held
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		if oldest == nil {
+			return
+		}
+		os.Remove(filepath.Join(c.dir, oldest.Value.(*entry).key))
+		c.remove(oldest)
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	c.size -= e.Value.(*entry).size
+	delete(c.entries, e.Value.(*entry).key)
+	c.order.Remove(e)
+}
@@ -0,0 +1,116 @@
+package diskcache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	c, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+	if err = c.Put("a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Put("a", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := c.Get("a")
+	if !ok || string(data) != "second" {
+		t.Errorf("expected second but got %q, %v", data, ok)
+	}
+	if size, files := c.Size(); size != 6 || files != 1 {
+		t.Errorf("expected 6 bytes in 1 file but got %d in %d", size, files)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := Open(t.TempDir(), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten := bytes.Repeat([]byte("x"), 10)
+	for _, key := range []string{"a", "b", "c"} {
+		if err = c.Put(key, ten); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Get("a") //b is now the oldest
+	if err = c.Put("d", ten); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s: expected cached %v but got %v", key, want, ok)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(c.dir, "b")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the evicted file to be removed, got %v", err)
+	}
+	if size, files := c.Size(); size != 30 || files != 3 {
+		t.Errorf("expected 30 bytes in 3 files but got %d in %d", size, files)
+	}
+}
+
+func TestPutLargerThanCache(t *testing.T) {
+	c, err := Open(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("small", []byte("abc"))
+	if err = c.Put("big", bytes.Repeat([]byte("x"), 11)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("big"); ok {
+		t.Error("expected data larger than the cache not to be stored")
+	}
+	if _, ok := c.Get("small"); !ok {
+		t.Error("expected storing nothing not to evict")
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	c, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "../a", "a/b", ".tmp-1", "a.jpg"} {
+		if err = c.Put(key, []byte("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey but got %v", key, err)
+		}
+	}
+}
+
+func TestOpenKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for n, key := range []string{"older", "newer"} {
+		path := filepath.Join(dir, key)
+		os.WriteFile(path, bytes.Repeat([]byte("x"), 10), 0o644)
+		os.Chtimes(path, old.Add(time.Duration(n)*time.Minute), old.Add(time.Duration(n)*time.Minute))
+	}
+	os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0o644)
+
+	c, err := Open(dir, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("older"); ok {
+		t.Error("expected the least recently used file to be evicted to fit")
+	}
+	if data, ok := c.Get("newer"); !ok || len(data) != 10 {
+		t.Errorf("expected the newer file to be kept, got %q, %v", data, ok)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ".tmp-123")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the unfinished write to be removed, got %v", err)
+	}
+}
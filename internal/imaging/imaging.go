@@ -0,0 +1,119 @@
+// Package imaging checks, cleans and resizes uploaded images with the standard library codecs,
+// and encodes WebP, which the standard library can't. Decoding and encoding again drops EXIF
+// and any other metadata; the EXIF orientation is applied to the pixels first so photos keep
+// facing the right way.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image type, use JPEG, PNG or GIF")
+	ErrTooLarge    = fmt.Errorf("image is larger than %d megapixels", MaxPixels/1_000_000)
+)
+
+const (
+	MaxPixels   = 40_000_000 //decoded size limit, a small file can still decode to gigabytes
+	jpegQuality = 90
+)
+
+// Sniff returns the content type of an image from its first bytes, ignoring what the client claimed.
+// It returns ErrUnsupported for anything but JPEG, PNG and GIF.
+func Sniff(head []byte) (string, error) {
+	switch contentType := http.DetectContentType(head); contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return contentType, nil
+	}
+	return "", ErrUnsupported
+}
+
+// Decode sniffs, size checks and decodes an image, with the EXIF orientation of a JPEG applied.
+// GIFs decode to their first frame. It also returns the sniffed content type.
+func Decode(data []byte) (*image.RGBA, string, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("corrupt image: %w", err)
+	}
+	if config.Width < 1 || config.Height < 1 {
+		return nil, "", errors.New("corrupt image: no pixels")
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("corrupt image: %w", err)
+	}
+	rgba := toRGBA(img)
+	if contentType == "image/jpeg" {
+		rgba = orient(rgba, exifOrientation(data))
+	}
+	return rgba, contentType, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// Encode writes an image decoded from contentType again, JPEGs as JPEG and PNGs and GIFs as PNG,
+// returning the content type used
+func Encode(w io.Writer, img *image.RGBA, contentType string) (string, error) {
+	if contentType == "image/jpeg" {
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return "image/png", png.Encode(w, img)
+}
+
+// EncodeJPEG writes an image as JPEG, transparent areas become white
+func EncodeJPEG(w io.Writer, img *image.RGBA, quality int) error {
+	return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+}
+
+// flatten puts an image with transparent areas on white
+func flatten(img *image.RGBA) *image.RGBA {
+	if img.Opaque() {
+		return img
+	}
+	flat := image.NewRGBA(img.Rect)
+	draw.Draw(flat, flat.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Rect, img, img.Rect.Min, draw.Over)
+	return flat
+}
+
+// Extension is the file name extension for a content type returned by Encode
+func Extension(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
@@ -0,0 +1,182 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gradient is w x h with each pixel's red set to its x and green to its y
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF block with the given orientation after the JPEG SOI marker
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3) //SHORT
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(append(out, app1...), segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestDecodeAppliesOrientationAndStripsExif(t *testing.T) {
+	var b bytes.Buffer
+	jpeg.Encode(&b, gradient(40, 20), &jpeg.Options{Quality: 100})
+	data := withOrientation(b.Bytes(), 6)
+	if exifOrientation(data) != 6 {
+		t.Fatalf("expected orientation 6 but got %d", exifOrientation(data))
+	}
+
+	img, contentType, err := Decode(data)
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("expected a JPEG, got %q %v", contentType, err)
+	}
+	if img.Rect.Dx() != 20 || img.Rect.Dy() != 40 {
+		t.Fatalf("expected a 20x40 image after turning, got %v", img.Rect)
+	}
+
+	var out bytes.Buffer
+	contentType, err = Encode(&out, img, contentType)
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("expected a JPEG, got %q %v", contentType, err)
+	}
+	if bytes.Contains(out.Bytes(), []byte("Exif")) || exifOrientation(out.Bytes()) != 1 {
+		t.Error("expected the EXIF block to be dropped")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := gradient(3, 2)
+	tests := []struct {
+		orientation int
+		w, h        int
+		topLeft     color.RGBA //source pixel that ends up at 0,0
+	}{
+		{1, 3, 2, color.RGBA{0, 0, 0, 255}},
+		{2, 3, 2, color.RGBA{2, 0, 0, 255}},
+		{3, 3, 2, color.RGBA{2, 1, 0, 255}},
+		{4, 3, 2, color.RGBA{0, 1, 0, 255}},
+		{5, 2, 3, color.RGBA{0, 0, 0, 255}},
+		{6, 2, 3, color.RGBA{0, 1, 0, 255}},
+		{7, 2, 3, color.RGBA{2, 1, 0, 255}},
+		{8, 2, 3, color.RGBA{2, 0, 0, 255}},
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		if got.Rect.Dx() != tt.w || got.Rect.Dy() != tt.h || got.RGBAAt(0, 0) != tt.topLeft {
+			t.Errorf("orientation %d: expected %dx%d starting %v, got %v starting %v",
+				tt.orientation, tt.w, tt.h, tt.topLeft, got.Rect, got.RGBAAt(0, 0))
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	hugeGif := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"text", []byte("hello, not an image"), ErrUnsupported},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), ErrUnsupported},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupported},
+		{"decompression bomb", hugeGif, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		if _, _, err := Decode(tt.data); !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, err)
+		}
+	}
+	if _, _, err := Decode([]byte("\x89PNG\r\n\x1a\n truncated")); err == nil {
+		t.Error("expected a truncated PNG to fail")
+	}
+}
+
+func TestEncodeKeepsTransparency(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var b bytes.Buffer
+	contentType, err := Encode(&b, img, "image/gif")
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("expected a PNG, got %q %v", contentType, err)
+	}
+	if _, err = png.Decode(&b); err != nil {
+		t.Error(err)
+	}
+
+	b.Reset()
+	if err = EncodeJPEG(&b, img, 80); err != nil {
+		t.Fatal(err)
+	}
+	flat, _ := jpeg.Decode(&b)
+	if r, _, _, _ := flat.At(1, 1).RGBA(); r < 0xf000 {
+		t.Errorf("expected transparency to become white, got %v", flat.At(1, 1))
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+		ew, eh     int
+	}{
+		{1600, 900, 320, 320, 180},
+		{900, 1600, 320, 180, 320},
+		{500, 500, 160, 160, 160},
+		{100, 50, 320, 100, 50},
+		{4000, 1, 160, 160, 1},
+	}
+	for _, tt := range tests {
+		got := Fit(gradient(tt.w, tt.h), tt.size)
+		if got.Rect.Dx() != tt.ew || got.Rect.Dy() != tt.eh {
+			t.Errorf("Fit(%dx%d, %d): expected %dx%d but got %v", tt.w, tt.h, tt.size, tt.ew, tt.eh, got.Rect)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			v := uint8(0)
+			if x%2 == 1 {
+				v = 200
+			}
+			src.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	got := resize(src, 2, 1)
+	for x := 0; x < 2; x++ {
+		if c := got.RGBAAt(x, 0); c != (color.RGBA{100, 100, 100, 255}) {
+			t.Errorf("expected the average of black and grey at %d, got %v", x, c)
+		}
+	}
+
+	// 3 to 2 splits the middle pixel between both outputs
+	src = image.NewRGBA(image.Rect(0, 0, 3, 1))
+	src.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+	src.SetRGBA(1, 0, color.RGBA{90, 90, 90, 255})
+	src.SetRGBA(2, 0, color.RGBA{180, 180, 180, 255})
+	got = resize(src, 2, 1)
+	if got.RGBAAt(0, 0).R != 30 || got.RGBAAt(1, 0).R != 150 {
+		t.Errorf("expected 30 and 150 but got %v and %v", got.RGBAAt(0, 0), got.RGBAAt(1, 0))
+	}
+}
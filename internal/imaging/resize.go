@@ -0,0 +1,96 @@
+package imaging
+
+import (
+	"image"
+)
+
+// Fit scales an image down to fit within size x size keeping its aspect ratio. Images that
+// already fit are returned as they are, they are never scaled up.
+func Fit(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	dw, dh := size, size
+	if w > h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+	return resize(img, dw, dh)
+}
+
+// resize shrinks with a box filter: each output pixel is the area weighted average of the
+// source pixels it covers. Good for downscaling, which is all thumbnails need.
+func resize(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	xw := boxWeights(sw, dw)
+	yw := boxWeights(sh, dh)
+
+	// rows first into a float buffer of dw x sh, then columns
+	tmp := make([]float64, dw*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for dx, ws := range xw {
+			var r, g, b, a float64
+			for _, w := range ws {
+				p := row[w.index*4:]
+				r += float64(p[0]) * w.weight
+				g += float64(p[1]) * w.weight
+				b += float64(p[2]) * w.weight
+				a += float64(p[3]) * w.weight
+			}
+			t := tmp[(y*dw+dx)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+	for dy, ws := range yw {
+		out := dst.Pix[dy*dst.Stride:]
+		for dx := 0; dx < dw; dx++ {
+			var r, g, b, a float64
+			for _, w := range ws {
+				t := tmp[(w.index*dw+dx)*4:]
+				r += t[0] * w.weight
+				g += t[1] * w.weight
+				b += t[2] * w.weight
+				a += t[3] * w.weight
+			}
+			p := out[dx*4:]
+			p[0], p[1], p[2], p[3] = clamp(r), clamp(g), clamp(b), clamp(a)
+		}
+	}
+	return dst
+}
+
+type weight struct {
+	index  int
+	weight float64
+}
+
+// boxWeights returns, for each of the dst pixels along one axis, the src pixels it covers and by how much
+func boxWeights(src, dst int) [][]weight {
+	scale := float64(src) / float64(dst)
+	weights := make([][]weight, dst)
+	for d := range weights {
+		start, end := float64(d)*scale, float64(d+1)*scale
+		for s := int(start); s < src && float64(s) < end; s++ {
+			cover := min(end, float64(s+1)) - max(start, float64(s))
+			if cover > 0 {
+				weights[d] = append(weights[d], weight{s, cover / scale})
+			}
+		}
+	}
+	return weights
+}
+
+func clamp(v float64) uint8 {
+	v += 0.5
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,790 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+)
+
+// A lossy VP8 key frame encoder, the image data of a WebP file. It follows RFC 6386 with the
+// simplest choices that still compress well: every macroblock is predicted as a whole (DC, V, H or
+// TM for luma and chroma, no 4x4 modes), one quantizer for the frame, one token partition, token
+// probabilities fitted to the image and the normal loop filter.
+
+const (
+	vp8MaxDimension = 16383
+	vp8UniformProb  = 128
+)
+
+// Prediction modes, numbered as the encoder uses them
+const (
+	predDC = iota
+	predTM
+	predV
+	predH
+)
+
+// Token probability planes, section 13.3
+const (
+	planeYAfterY2 = iota
+	planeY2
+	planeUV
+)
+
+var (
+	vp8Zigzag = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Bands  = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// extra bit probabilities of the DCT_CAT3 to DCT_CAT6 tokens, section 13.2
+	vp8CatProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// boolEncoder is the arithmetic coder of section 7.3
+type boolEncoder struct {
+	out      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+func (e *boolEncoder) putBit(bit bool, prob uint8) {
+	split := 1 + ((e.rng - 1) * uint32(prob) >> 8)
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.out = append(e.out, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+func (e *boolEncoder) carry() {
+	i := len(e.out) - 1
+	for i >= 0 && e.out[i] == 255 {
+		e.out[i] = 0
+		i--
+	}
+	e.out[i]++
+}
+
+// putLiteral writes the n low bits of v, most significant first
+func (e *boolEncoder) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.putBit(v>>uint(i)&1 == 1, vp8UniformProb)
+	}
+}
+
+func (e *boolEncoder) bytes() []byte {
+	for range 32 {
+		e.putBit(false, vp8UniformProb)
+	}
+	return e.out
+}
+
+// vp8Block is one 4x4 block of quantized coefficients in zigzag order
+type vp8Block struct {
+	levels [16]int16
+	first  int //1 for luma blocks whose DC went to the Y2 block
+}
+
+func (b *vp8Block) last() int {
+	for n := 15; n >= b.first; n-- {
+		if b.levels[n] != 0 {
+			return n
+		}
+	}
+	return -1
+}
+
+// vp8Macroblock is what the bitstream needs of a macroblock once it is quantized
+type vp8Macroblock struct {
+	yMode, uvMode int
+	skip          bool //every coefficient is zero
+	y2            vp8Block
+	y             [16]vp8Block
+	u, v          [4]vp8Block
+}
+
+// vp8Quant are the DC and AC quantizer steps of the three kinds of block
+type vp8Quant struct {
+	y, y2, uv [2]int32
+}
+
+func newVP8Quant(index int) vp8Quant {
+	q := vp8Quant{
+		y:  [2]int32{vp8DCQuant[index], vp8ACQuant[index]},
+		y2: [2]int32{vp8DCQuant[index] * 2, vp8ACQuant[index] * 155 / 100},
+		uv: [2]int32{vp8DCQuant[min(index, 117)], vp8ACQuant[index]},
+	}
+	q.y2[1] = max(q.y2[1], 8)
+	return q
+}
+
+// vp8Encoder holds the planes of one frame, padded to whole macroblocks, and the reconstruction
+// a decoder will make of them, which later macroblocks are predicted from
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	quantIndex    int
+	filterLevel   int
+	quant         vp8Quant
+
+	y, u, v    []uint8
+	ry, ru, rv []uint8
+	mbs        []vp8Macroblock
+}
+
+func newVP8Encoder(img *image.RGBA, quantIndex, filterLevel int) *vp8Encoder {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	e := &vp8Encoder{
+		width:       w,
+		height:      h,
+		mbw:         (w + 15) / 16,
+		mbh:         (h + 15) / 16,
+		quantIndex:  quantIndex,
+		filterLevel: filterLevel,
+		quant:       newVP8Quant(quantIndex),
+	}
+	ys, cs := e.mbw*16, e.mbw*8
+	e.y, e.ry = make([]uint8, ys*e.mbh*16), make([]uint8, ys*e.mbh*16)
+	e.u, e.ru = make([]uint8, cs*e.mbh*8), make([]uint8, cs*e.mbh*8)
+	e.v, e.rv = make([]uint8, cs*e.mbh*8), make([]uint8, cs*e.mbh*8)
+	e.mbs = make([]vp8Macroblock, e.mbw*e.mbh)
+
+	// pixels past the right and bottom edges repeat the last ones, they are cropped away
+	pixel := func(x, y int) (r, g, b int32) {
+		x, y = min(x, w-1), min(y, h-1)
+		i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+		return int32(img.Pix[i]), int32(img.Pix[i+1]), int32(img.Pix[i+2])
+	}
+	for y := 0; y < e.mbh*16; y++ {
+		for x := 0; x < ys; x++ {
+			r, g, b := pixel(x, y)
+			e.y[y*ys+x] = uint8((16839*r + 33059*g + 6420*b + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for y := 0; y < e.mbh*8; y++ {
+		for x := 0; x < cs; x++ {
+			var r, g, b int32
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := pixel(2*x+d[0], 2*y+d[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			e.u[y*cs+x] = clampUint8((-9719*r - 19081*g + 28800*b + 128<<18 + 1<<17) >> 18)
+			e.v[y*cs+x] = clampUint8((28800*r - 24116*g - 4684*b + 128<<18 + 1<<17) >> 18)
+		}
+	}
+	return e
+}
+
+func clampUint8(v int32) uint8 {
+	return uint8(max(0, min(v, 255)))
+}
+
+// encodeVP8 returns the VP8 frame of an image. quantIndex runs from 0, the finest, to 127.
+func encodeVP8(img *image.RGBA, quantIndex, filterLevel int) ([]byte, error) {
+	if img.Rect.Dx() < 1 || img.Rect.Dy() < 1 {
+		return nil, errors.New("no pixels to encode")
+	}
+	if img.Rect.Dx() > vp8MaxDimension || img.Rect.Dy() > vp8MaxDimension {
+		return nil, errors.New("image is too large for WebP")
+	}
+	return newVP8Encoder(img, quantIndex, filterLevel).encode(), nil
+}
+
+func (e *vp8Encoder) encode() []byte {
+	for mby := range e.mbh {
+		for mbx := range e.mbw {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	probs := vp8DefaultTokenProbs
+	var counts [4][8][3][11][2]uint32
+	e.writeTokens(&tokenWriter{counts: &counts})
+	updated := fitTokenProbs(&probs, &counts)
+
+	tokens := newBoolEncoder()
+	e.writeTokens(&tokenWriter{w: tokens, probs: &probs})
+	first := e.writeHeader(&probs, updated)
+
+	frame := make([]byte, 10, 10+len(first)+len(tokens.out)+8)
+	tag := uint32(len(first))<<5 | 1<<4 //key frame, version 0, shown
+	frame[0], frame[1], frame[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	frame[3], frame[4], frame[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(frame[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(frame[8:], uint16(e.height))
+	frame = append(frame, first...)
+	return append(frame, tokens.bytes()...)
+}
+
+// writeHeader returns the first partition: the frame header and the modes of every macroblock
+func (e *vp8Encoder) writeHeader(probs *[4][8][3][11]uint8, updated *[4][8][3][11]bool) []byte {
+	w := newBoolEncoder()
+	w.putLiteral(0, 1) //color space
+	w.putLiteral(0, 1) //clamping required
+	w.putLiteral(0, 1) //no segmentation
+	w.putLiteral(0, 1) //normal loop filter
+	w.putLiteral(uint32(e.filterLevel), 6)
+	w.putLiteral(0, 3) //sharpness
+	w.putLiteral(0, 1) //no loop filter deltas
+	w.putLiteral(0, 2) //one token partition
+	w.putLiteral(uint32(e.quantIndex), 7)
+	w.putLiteral(0, 5) //no quantizer deltas
+	w.putLiteral(0, 1) //refresh entropy probs, unused for a single frame
+
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l, p := range probs[i][j][k] {
+					w.putBit(updated[i][j][k][l], vp8TokenUpdateProbs[i][j][k][l])
+					if updated[i][j][k][l] {
+						w.putLiteral(uint32(p), 8)
+					}
+				}
+			}
+		}
+	}
+
+	skipped := 0
+	for i := range e.mbs {
+		if e.mbs[i].skip {
+			skipped++
+		}
+	}
+	useSkip := skipped > 0
+	var skipProb uint8
+	w.putLiteral(boolToUint(useSkip), 1)
+	if useSkip {
+		skipProb = fitProb(uint32(len(e.mbs)-skipped), uint32(skipped))
+		w.putLiteral(uint32(skipProb), 8)
+	}
+
+	for i := range e.mbs {
+		mb := &e.mbs[i]
+		if useSkip {
+			w.putBit(mb.skip, skipProb)
+		}
+		w.putBit(true, 145) //a 16x16 mode, not 4x4 ones
+		switch mb.yMode {
+		case predDC:
+			w.putBit(false, 156)
+			w.putBit(false, 163)
+		case predV:
+			w.putBit(false, 156)
+			w.putBit(true, 163)
+		case predH:
+			w.putBit(true, 156)
+			w.putBit(false, 128)
+		case predTM:
+			w.putBit(true, 156)
+			w.putBit(true, 128)
+		}
+		switch mb.uvMode {
+		case predDC:
+			w.putBit(false, 142)
+		case predV:
+			w.putBit(true, 142)
+			w.putBit(false, 114)
+		case predH:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(false, 183)
+		case predTM:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(true, 183)
+		}
+	}
+	return w.bytes()
+}
+
+func boolToUint(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fitProb is the probability of a zero that codes zeros and ones in the fewest bits
+func fitProb(zeros, ones uint32) uint8 {
+	if zeros+ones == 0 {
+		return 128
+	}
+	return uint8(max(1, min(255, 256*zeros/(zeros+ones))))
+}
+
+// bitCost is the number of bits zeros and ones take at probability prob
+func bitCost(zeros, ones uint32, prob uint8) float64 {
+	p := float64(prob) / 256
+	return -float64(zeros)*math.Log2(p) - float64(ones)*math.Log2(1-p)
+}
+
+// fitTokenProbs replaces the token probabilities that are worth updating with ones fitted to counts
+func fitTokenProbs(probs *[4][8][3][11]uint8, counts *[4][8][3][11][2]uint32) *[4][8][3][11]bool {
+	var updated [4][8][3][11]bool
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l, old := range probs[i][j][k] {
+					c := counts[i][j][k][l]
+					fitted := fitProb(c[0], c[1])
+					updateProb := vp8TokenUpdateProbs[i][j][k][l]
+					keep := bitCost(c[0], c[1], old) + bitCost(1, 0, updateProb)
+					update := bitCost(c[0], c[1], fitted) + bitCost(0, 1, updateProb) + 8
+					if update < keep {
+						probs[i][j][k][l] = fitted
+						updated[i][j][k][l] = true
+					}
+				}
+			}
+		}
+	}
+	return &updated
+}
+
+// tokenWriter codes coefficient tokens, or only counts the branches taken when w is nil
+type tokenWriter struct {
+	w      *boolEncoder
+	probs  *[4][8][3][11]uint8
+	counts *[4][8][3][11][2]uint32
+}
+
+func (t *tokenWriter) branch(plane, band, ctx, i int, bit bool) {
+	if t.w == nil {
+		t.counts[plane][band][ctx][i][boolToUint(bit)]++
+		return
+	}
+	t.w.putBit(bit, t.probs[plane][band][ctx][i])
+}
+
+func (t *tokenWriter) fixed(bit bool, prob uint8) {
+	if t.w != nil {
+		t.w.putBit(bit, prob)
+	}
+}
+
+// block codes the tokens of a block as section 13 lays them out and reports whether any
+// coefficient was non-zero, the context of the next blocks to the right and below
+func (t *tokenWriter) block(plane, ctx int, b *vp8Block) uint8 {
+	last := b.last()
+	n := b.first
+	if last < 0 {
+		t.branch(plane, int(vp8Bands[n]), ctx, 0, false)
+		return 0
+	}
+	t.branch(plane, int(vp8Bands[n]), ctx, 0, true)
+	for n < 16 {
+		v := int32(b.levels[n])
+		band := int(vp8Bands[n])
+		n++
+		if v == 0 {
+			t.branch(plane, band, ctx, 1, false)
+			ctx = 0
+			continue
+		}
+		t.branch(plane, band, ctx, 1, true)
+		abs := max(v, -v)
+		if abs == 1 {
+			t.branch(plane, band, ctx, 2, false)
+			ctx = 1
+		} else {
+			t.branch(plane, band, ctx, 2, true)
+			switch {
+			case abs <= 4:
+				t.branch(plane, band, ctx, 3, false)
+				if abs == 2 {
+					t.branch(plane, band, ctx, 4, false)
+				} else {
+					t.branch(plane, band, ctx, 4, true)
+					t.branch(plane, band, ctx, 5, abs == 4)
+				}
+			case abs <= 10:
+				t.branch(plane, band, ctx, 3, true)
+				t.branch(plane, band, ctx, 6, false)
+				if abs <= 6 {
+					t.branch(plane, band, ctx, 7, false)
+					t.fixed(abs == 6, 159)
+				} else {
+					t.branch(plane, band, ctx, 7, true)
+					t.fixed((abs-7)&2 != 0, 165)
+					t.fixed((abs-7)&1 != 0, 145)
+				}
+			default:
+				t.branch(plane, band, ctx, 3, true)
+				t.branch(plane, band, ctx, 6, true)
+				cat := 3
+				for c := range 3 {
+					if abs < 3+(8<<(c+1)) {
+						cat = c
+						break
+					}
+				}
+				t.branch(plane, band, ctx, 8, cat >= 2)
+				t.branch(plane, band, ctx, 9+cat>>1, cat&1 == 1)
+				extra := abs - (3 + 8<<cat)
+				probs := vp8CatProbs[cat]
+				for i, p := range probs {
+					t.fixed(extra>>uint(len(probs)-1-i)&1 == 1, p)
+				}
+			}
+			ctx = 2
+		}
+		t.fixed(v < 0, vp8UniformProb)
+		if n == 16 {
+			break
+		}
+		eob := n > last
+		t.branch(plane, int(vp8Bands[n]), ctx, 0, !eob)
+		if eob {
+			break
+		}
+	}
+	return 1
+}
+
+// vp8Nonzero records which blocks along a macroblock edge had non-zero coefficients
+type vp8Nonzero struct {
+	y    [4]uint8
+	u, v [2]uint8
+	y2   uint8
+}
+
+// writeTokens codes the coefficients of every macroblock in the order a decoder reads them
+func (e *vp8Encoder) writeTokens(t *tokenWriter) {
+	top := make([]vp8Nonzero, e.mbw)
+	for mby := range e.mbh {
+		var left vp8Nonzero
+		for mbx := range e.mbw {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			up := &top[mbx]
+			if mb.skip {
+				left, *up = vp8Nonzero{}, vp8Nonzero{}
+				continue
+			}
+			nz := t.block(planeY2, int(left.y2+up.y2), &mb.y2)
+			left.y2, up.y2 = nz, nz
+			for j := range 4 {
+				for i := range 4 {
+					nz = t.block(planeYAfterY2, int(left.y[j]+up.y[i]), &mb.y[4*j+i])
+					left.y[j], up.y[i] = nz, nz
+				}
+			}
+			for _, c := range []struct {
+				blocks    *[4]vp8Block
+				left, top *[2]uint8
+			}{{&mb.u, &left.u, &up.u}, {&mb.v, &left.v, &up.v}} {
+				for j := range 2 {
+					for i := range 2 {
+						nz = t.block(planeUV, int(c.left[j]+c.top[i]), &c.blocks[2*j+i])
+						c.left[j], c.top[i] = nz, nz
+					}
+				}
+			}
+		}
+	}
+}
+
+// edges returns the reconstructed row above and column left of a block of size n at x, y and
+// the pixel above left, with the values section 12.2 gives past the frame edges
+func edges(plane []uint8, stride, x, y, n int) (above, left []uint8, corner uint8) {
+	above, left = make([]uint8, n), make([]uint8, n)
+	for i := range n {
+		above[i], left[i] = 127, 129
+		if y > 0 {
+			above[i] = plane[(y-1)*stride+x+i]
+		}
+		if x > 0 {
+			left[i] = plane[(y+i)*stride+x-1]
+		}
+	}
+	switch {
+	case y == 0:
+		corner = 127
+	case x == 0:
+		corner = 129
+	default:
+		corner = plane[(y-1)*stride+x-1]
+	}
+	return above, left, corner
+}
+
+// predict fills an n by n block with a prediction from its edges
+func predict(mode int, above, left []uint8, corner uint8, hasAbove, hasLeft bool) []uint8 {
+	n := len(above)
+	pred := make([]uint8, n*n)
+	switch mode {
+	case predDC:
+		sum, count := 0, 0
+		if hasAbove {
+			for _, p := range above {
+				sum += int(p)
+			}
+			count += n
+		}
+		if hasLeft {
+			for _, p := range left {
+				sum += int(p)
+			}
+			count += n
+		}
+		dc := uint8(128)
+		if count > 0 {
+			dc = uint8((sum + count/2) / count)
+		}
+		for i := range pred {
+			pred[i] = dc
+		}
+	case predTM:
+		for j := range n {
+			for i := range n {
+				pred[j*n+i] = clampUint8(int32(left[j]) + int32(above[i]) - int32(corner))
+			}
+		}
+	case predV:
+		for j := range n {
+			copy(pred[j*n:], above)
+		}
+	case predH:
+		for j := range n {
+			for i := range n {
+				pred[j*n+i] = left[j]
+			}
+		}
+	}
+	return pred
+}
+
+// bestPrediction picks the mode whose predictions are closest to the source blocks
+func bestPrediction(sources [][]uint8, stride int, edgesOf func(int) ([]uint8, []uint8, uint8), hasAbove, hasLeft bool) (mode int, preds [][]uint8) {
+	best := -1
+	for m := range 4 {
+		candidate := make([][]uint8, len(sources))
+		sse := 0
+		for s, src := range sources {
+			above, left, corner := edgesOf(s)
+			candidate[s] = predict(m, above, left, corner, hasAbove, hasLeft)
+			n := len(above)
+			for j := range n {
+				for i := range n {
+					d := int(src[j*stride+i]) - int(candidate[s][j*n+i])
+					sse += d * d
+				}
+			}
+		}
+		if best < 0 || sse < best {
+			best, mode, preds = sse, m, candidate
+		}
+	}
+	return mode, preds
+}
+
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+	ys, cs := e.mbw*16, e.mbw*8
+	hasAbove, hasLeft := mby > 0, mbx > 0
+
+	yx, yy := mbx*16, mby*16
+	mode, preds := bestPrediction([][]uint8{e.y[yy*ys+yx:]}, ys, func(int) ([]uint8, []uint8, uint8) {
+		return edges(e.ry, ys, yx, yy, 16)
+	}, hasAbove, hasLeft)
+	mb.yMode = mode
+	e.encodeLuma(mb, preds[0], yx, yy)
+
+	cx, cy := mbx*8, mby*8
+	planes := [2][]uint8{e.ru, e.rv}
+	mode, preds = bestPrediction([][]uint8{e.u[cy*cs+cx:], e.v[cy*cs+cx:]}, cs, func(s int) ([]uint8, []uint8, uint8) {
+		return edges(planes[s], cs, cx, cy, 8)
+	}, hasAbove, hasLeft)
+	mb.uvMode = mode
+	e.encodeChroma(&mb.u, preds[0], e.u, e.ru, cx, cy)
+	e.encodeChroma(&mb.v, preds[1], e.v, e.rv, cx, cy)
+
+	mb.skip = mb.y2.last() < 0
+	for _, blocks := range [][]vp8Block{mb.y[:], mb.u[:], mb.v[:]} {
+		for i := range blocks {
+			mb.skip = mb.skip && blocks[i].last() < 0
+		}
+	}
+}
+
+// encodeLuma quantizes the 16x16 luma of a macroblock, the DC of each 4x4 block going through
+// the Y2 block, and writes the reconstruction
+func (e *vp8Encoder) encodeLuma(mb *vp8Macroblock, pred []uint8, x, y int) {
+	stride := e.mbw * 16
+	var coeffs [16][16]int32
+	var dc [16]int32
+	for n := range 16 {
+		bx, by := x+4*(n%4), y+4*(n/4)
+		var diff [16]int32
+		for j := range 4 {
+			for i := range 4 {
+				p := pred[(4*(n/4)+j)*16+4*(n%4)+i]
+				diff[j*4+i] = int32(e.y[(by+j)*stride+bx+i]) - int32(p)
+			}
+		}
+		coeffs[n] = forwardDCT(diff)
+		dc[n] = coeffs[n][0]
+	}
+
+	y2 := forwardWHT(dc)
+	mb.y2.first = 0
+	y2 = quantizeBlock(&mb.y2, y2, e.quant.y2)
+	dc = inverseWHT(y2)
+	for n := range 16 {
+		mb.y[n].first = 1
+		coeffs[n] = quantizeBlock(&mb.y[n], coeffs[n], e.quant.y)
+		coeffs[n][0] = dc[n]
+		bx, by := x+4*(n%4), y+4*(n/4)
+		var p [16]uint8
+		for j := range 4 {
+			copy(p[j*4:j*4+4], pred[(4*(n/4)+j)*16+4*(n%4):])
+		}
+		reconstruct(e.ry[by*stride+bx:], stride, p, coeffs[n])
+	}
+}
+
+// encodeChroma quantizes one 8x8 chroma plane of a macroblock and writes the reconstruction
+func (e *vp8Encoder) encodeChroma(blocks *[4]vp8Block, pred []uint8, src, recon []uint8, x, y int) {
+	stride := e.mbw * 8
+	for n := range 4 {
+		bx, by := x+4*(n%2), y+4*(n/2)
+		var diff [16]int32
+		var p [16]uint8
+		for j := range 4 {
+			for i := range 4 {
+				p[j*4+i] = pred[(4*(n/2)+j)*8+4*(n%2)+i]
+				diff[j*4+i] = int32(src[(by+j)*stride+bx+i]) - int32(p[j*4+i])
+			}
+		}
+		coeffs := quantizeBlock(&blocks[n], forwardDCT(diff), e.quant.uv)
+		reconstruct(recon[by*stride+bx:], stride, p, coeffs)
+	}
+}
+
+// quantizeBlock stores the quantized levels of coefficients in b and returns the coefficients a
+// decoder gets back. AC coefficients round towards zero a little, small ones cost more than
+// they are worth.
+func quantizeBlock(b *vp8Block, coeffs [16]int32, q [2]int32) [16]int32 {
+	var out [16]int32
+	for n := b.first; n < 16; n++ {
+		z := vp8Zigzag[n]
+		step := q[boolToUint(z > 0)]
+		bias := step / 2
+		if z > 0 {
+			bias = step * 3 / 8
+		}
+		c := coeffs[z]
+		level := min((max(c, -c)+bias)/step, 2047)
+		if c < 0 {
+			level = -level
+		}
+		b.levels[n] = int16(level)
+		out[z] = level * step
+	}
+	return out
+}
+
+// forwardDCT is the transform of section 14.3 the other way round
+func forwardDCT(in [16]int32) (out [16]int32) {
+	var tmp [16]int32
+	for i := range 4 {
+		a := (in[i*4+0] + in[i*4+3]) * 8
+		b := (in[i*4+1] + in[i*4+2]) * 8
+		c := (in[i*4+1] - in[i*4+2]) * 8
+		d := (in[i*4+0] - in[i*4+3]) * 8
+		tmp[i*4+0] = a + b
+		tmp[i*4+2] = a - b
+		tmp[i*4+1] = (c*2217 + d*5352 + 14500) >> 12
+		tmp[i*4+3] = (d*2217 - c*5352 + 7500) >> 12
+	}
+	for i := range 4 {
+		a := tmp[i] + tmp[12+i]
+		b := tmp[4+i] + tmp[8+i]
+		c := tmp[4+i] - tmp[8+i]
+		d := tmp[i] - tmp[12+i]
+		out[i] = (a + b + 7) >> 4
+		out[8+i] = (a - b + 7) >> 4
+		out[4+i] = (c*2217 + d*5352 + 12000) >> 16
+		if d != 0 {
+			out[4+i]++
+		}
+		out[12+i] = (d*2217 - c*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// reconstruct adds the inverse transform of coefficients to a prediction, section 14.3
+func reconstruct(dst []uint8, stride int, pred [16]uint8, coeffs [16]int32) {
+	const c1, c2 = 85627, 35468
+	var m [4][4]int32
+	for i := range 4 {
+		a := coeffs[i] + coeffs[8+i]
+		b := coeffs[i] - coeffs[8+i]
+		c := (coeffs[4+i]*c2)>>16 - (coeffs[12+i]*c1)>>16
+		d := (coeffs[4+i]*c1)>>16 + (coeffs[12+i]*c2)>>16
+		m[i] = [4]int32{a + d, b + c, b - c, a - d}
+	}
+	for j := range 4 {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		for i, r := range [4]int32{a + d, b + c, b - c, a - d} {
+			dst[j*stride+i] = clampUint8(int32(pred[j*4+i]) + r>>3)
+		}
+	}
+}
+
+// wht4 is the one dimensional Walsh-Hadamard transform, its own inverse up to a factor of 4
+func wht4(x0, x1, x2, x3 int32) (int32, int32, int32, int32) {
+	a0, a1, a2, a3 := x0+x3, x1+x2, x1-x2, x0-x3
+	return a0 + a1, a3 + a2, a0 - a1, a3 - a2
+}
+
+// forwardWHT turns the DC coefficients of the 16 luma blocks into the Y2 block
+func forwardWHT(dc [16]int32) (out [16]int32) {
+	var m [16]int32
+	for i := range 4 {
+		m[i], m[4+i], m[8+i], m[12+i] = wht4(dc[i], dc[4+i], dc[8+i], dc[12+i])
+	}
+	for j := range 4 {
+		a, b, c, d := wht4(m[j*4], m[j*4+1], m[j*4+2], m[j*4+3])
+		out[j*4], out[j*4+1], out[j*4+2], out[j*4+3] = a>>1, b>>1, c>>1, d>>1
+	}
+	return out
+}
+
+// inverseWHT is the transform of section 14.3, from the Y2 block back to 16 DC coefficients
+func inverseWHT(in [16]int32) (dc [16]int32) {
+	var m [16]int32
+	for i := range 4 {
+		m[i], m[4+i], m[8+i], m[12+i] = wht4(in[i], in[4+i], in[8+i], in[12+i])
+	}
+	for j := range 4 {
+		a, b, c, d := wht4(m[j*4]+3, m[j*4+1], m[j*4+2], m[j*4+3])
+		dc[j*4], dc[j*4+1], dc[j*4+2], dc[j*4+3] = a>>3, b>>3, c>>3, d>>3
+	}
+	return dc
+}
@@ -0,0 +1,34 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// EncodeWebP writes an image as lossy WebP, transparent areas become white like EncodeJPEG.
+// quality runs from 1 to 100 as for JPEG and is spread over the finer three quarters of the VP8
+// quantizer, where a WebP comes out smaller than a JPEG of the same quality and looks as good.
+func EncodeWebP(w io.Writer, img *image.RGBA, quality int) error {
+	quantIndex := (100 - max(1, min(quality, 100))) * 127 / 100 * 3 / 4
+	frame, err := encodeVP8(flatten(img), quantIndex, quantIndex/2)
+	if err != nil {
+		return err
+	}
+	pad := len(frame) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(frame)+pad))
+	copy(header[8:], "WEBPVP8 ")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(frame)))
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	if _, err = w.Write(frame); err != nil {
+		return err
+	}
+	if pad == 1 {
+		_, err = w.Write([]byte{0})
+	}
+	return err
+}
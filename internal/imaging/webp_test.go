@@ -0,0 +1,109 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/vp8"
+	"golang.org/x/image/webp"
+)
+
+// noisy is a gradient with random speckles, so every kind of coefficient token gets coded
+func noisy(w, h int) *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := gradient(w, h)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if rng.Intn(4) == 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		}
+	}
+	return img
+}
+
+func TestEncodeVP8MatchesDecoder(t *testing.T) {
+	// without the loop filter a decoder must end up with exactly the reconstruction the encoder
+	// predicted from, any difference would smear across the rest of the frame
+	for _, quantIndex := range []int{0, 16, 60, 127} {
+		img := noisy(77, 45)
+		e := newVP8Encoder(img, quantIndex, 0)
+		frame := e.encode()
+
+		d := vp8.NewDecoder()
+		d.Init(bytes.NewReader(frame), len(frame))
+		header, err := d.DecodeFrameHeader()
+		if err != nil {
+			t.Fatalf("quantizer %d: %v", quantIndex, err)
+		}
+		if header.Width != 77 || header.Height != 45 {
+			t.Fatalf("quantizer %d: expected 77x45 but got %dx%d", quantIndex, header.Width, header.Height)
+		}
+		out, err := d.DecodeFrame()
+		if err != nil {
+			t.Fatalf("quantizer %d: %v", quantIndex, err)
+		}
+		for y := range 45 {
+			for x := range 77 {
+				if got, want := out.Y[y*out.YStride+x], e.ry[y*e.mbw*16+x]; got != want {
+					t.Fatalf("quantizer %d: luma at %d,%d decoded as %d, expected %d", quantIndex, x, y, got, want)
+				}
+			}
+		}
+		for y := range 23 {
+			for x := range 39 {
+				c := y*e.mbw*8 + x
+				if out.Cb[y*out.CStride+x] != e.ru[c] || out.Cr[y*out.CStride+x] != e.rv[c] {
+					t.Fatalf("quantizer %d: chroma at %d,%d differs from the reconstruction", quantIndex, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	img := gradient(200, 120)
+	for x := range 50 {
+		for y := range 50 {
+			img.SetRGBA(x, y, color.RGBA{}) //transparent corner
+		}
+	}
+	var b bytes.Buffer
+	if err := EncodeWebP(&b, img, 82); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WEBPVP8 " || len(data)%2 != 0 {
+		t.Fatalf("expected a padded RIFF WebP file, got % x", data[:16])
+	}
+
+	decoded, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("encoded WebP does not decode: %v", err)
+	}
+	out, ok := decoded.(*image.YCbCr)
+	if !ok || out.Rect != img.Rect {
+		t.Fatalf("expected a 200x120 lossy image, got %T %v", decoded, decoded.Bounds())
+	}
+	// VP8 luma is 16 for black to 235 for white
+	luma := func(c color.RGBA) float64 {
+		return 16 + (65.481*float64(c.R)+128.553*float64(c.G)+24.966*float64(c.B))/255
+	}
+	var worst float64
+	for y := 60; y < 120; y++ {
+		for x := 60; x < 200; x++ {
+			worst = max(worst, abs(float64(out.Y[out.YOffset(x, y)])-luma(img.RGBAAt(x, y))))
+		}
+	}
+	if worst > 8 {
+		t.Errorf("expected the gradient to survive, luma is off by up to %.0f", worst)
+	}
+	if y := out.Y[out.YOffset(20, 20)]; y < 230 {
+		t.Errorf("expected transparent areas to turn white, got luma %d", y)
+	}
+}
+
+func abs(v float64) float64 {
+	return max(v, -v)
+}
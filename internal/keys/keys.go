@@ -0,0 +1,258 @@
+// Package keys holds the RSA keys used to sign and verify access tokens.
+//
+// Every key has an id (kid) that is written into the header of the tokens it signs.
+// Tokens are signed with the active key and verified against any loaded key, so a
+// key can be rotated without invalidating tokens that are still in flight:
+//
+//  1. add the new private key to the key directory and reload; it is published in the JWKS
+//  2. make it the active key (it sorts last, or set the active id explicitly) and reload;
+//     a legacy-* key moved in from private_key.pem never sorts after a key added later
+//  3. once the old key has not signed anything for a token lifetime, remove it and reload
+package keys
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// LegacyPrefix starts the id LoadLegacy derives for the key used before key directories
+const LegacyPrefix = "legacy-"
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown key id")
+)
+
+// Key is one RSA key; Private is nil for keys that may only verify
+type Key struct {
+	Id      string
+	Private *rsa.PrivateKey
+	Public  *rsa.PublicKey
+}
+
+// Provider signs with the active key and verifies with any key it holds
+type Provider struct {
+	dir      string
+	activeId string
+
+	mu     sync.RWMutex
+	keys   map[string]Key
+	active string
+}
+
+// New builds a Provider from keys held in memory. An empty activeId picks the
+// last private key by id, legacy ones only if there is no other.
+func New(activeId string, keys ...Key) (*Provider, error) {
+	p := &Provider{activeId: activeId}
+	if err := p.set(keys); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadDir builds a Provider from the *.pem files in dir. The file name without
+// extension is the key id. Private key files can sign, public key files can only
+// verify (e.g. a key published ahead of its activation by another instance).
+func LoadDir(dir string, activeId string) (*Provider, error) {
+	p := &Provider{dir: dir, activeId: activeId}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadLegacy builds a Provider from the single private key used before key directories
+// (./private_key.pem). Its id is derived from the public key, so every instance sharing the
+// file agrees on it and the key can later be moved into a directory under that name.
+func LoadLegacy(privateKeyPath string) (*Provider, error) {
+	data, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParsePEM("", data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", privateKeyPath, err)
+	}
+	if k.Private == nil {
+		return nil, fmt.Errorf("%s: %w: not a private key", privateKeyPath, ErrNoSigningKey)
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.Public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	k.Id = LegacyPrefix + hex.EncodeToString(sum[:6])
+	return New(k.Id, k)
+}
+
+// Reload re-reads the key directory. Keys removed from it are retired and tokens
+// signed by them stop verifying.
+func (p *Provider) Reload() error {
+	if p.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(p.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	var keys []Key
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		k, err := ParsePEM(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, k)
+	}
+	return p.set(keys)
+}
+
+func (p *Provider) set(keys []Key) error {
+	byId := make(map[string]Key, len(keys))
+	var signing []string
+	for _, k := range keys {
+		if k.Id == "" || k.Public == nil {
+			return fmt.Errorf("key %q has no id or public key", k.Id)
+		}
+		if _, dup := byId[k.Id]; dup {
+			return fmt.Errorf("duplicate key id %q", k.Id)
+		}
+		byId[k.Id] = k
+		if k.Private != nil {
+			signing = append(signing, k.Id)
+		}
+	}
+	active := p.activeId
+	if active == "" && len(signing) > 0 {
+		sort.Slice(signing, func(i, j int) bool {
+			li, lj := strings.HasPrefix(signing[i], LegacyPrefix), strings.HasPrefix(signing[j], LegacyPrefix)
+			if li != lj {
+				return li
+			}
+			return signing[i] < signing[j]
+		})
+		active = signing[len(signing)-1]
+	}
+	if k, ok := byId[active]; !ok || k.Private == nil {
+		return fmt.Errorf("%w: %q", ErrNoSigningKey, active)
+	}
+
+	p.mu.Lock()
+	p.keys, p.active = byId, active
+	p.mu.Unlock()
+	return nil
+}
+
+// ParsePEM reads an RSA private key (PKCS#1 or PKCS#8) or public key (PKIX or PKCS#1)
+func ParsePEM(id string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("no PEM block")
+	}
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{Id: id, Private: priv, Public: &priv.PublicKey}, nil
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{Id: id, Public: pub}, nil
+	}
+	pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{Id: id, Public: pub}, nil
+}
+
+// ActiveId returns the id of the key new tokens are signed with
+func (p *Provider) ActiveId() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active
+}
+
+// Sign signs claims with RS256 and the active key, setting the kid header
+func (p *Provider) Sign(claims jwt.Claims) (string, error) {
+	p.mu.RLock()
+	k, ok := p.keys[p.active]
+	p.mu.RUnlock()
+	if !ok || k.Private == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.Id
+	return token.SignedString(k.Private)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key by the token's kid.
+// Tokens without a kid were issued before key ids existed and are checked against the active key.
+func (p *Provider) Keyfunc(t *jwt.Token) (interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		kid = p.active
+	}
+	k, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	return k.Public, nil
+}
+
+// JWK is the public part of a key in RFC 7517 form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every verification key, sorted by id
+func (p *Provider) JWKS() JWKS {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(p.keys))}
+	for _, k := range p.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Kid: k.Id,
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(k.Public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.Public.E)).Bytes()),
+		})
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
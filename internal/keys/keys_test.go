@@ -0,0 +1,162 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeKey(t *testing.T, dir, id string, private bool) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if !private {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("marshal public key: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return key
+}
+
+func verify(p *Provider, token string) error {
+	_, err := jwt.Parse(token, p.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+	return err
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-01", true)
+	p, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	oldToken, err := p.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	writeKey(t, dir, "2026-02", true)
+	if err = p.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if p.ActiveId() != "2026-02" {
+		t.Errorf("expected the newest key to become active but got %q", p.ActiveId())
+	}
+	newToken, err := p.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil || parsed.Header["kid"] != "2026-02" {
+		t.Errorf("expected kid 2026-02 on new tokens but got %v", parsed.Header["kid"])
+	}
+	if err = verify(p, oldToken); err != nil {
+		t.Errorf("token signed before rotation should still verify: %v", err)
+	}
+	if err = verify(p, newToken); err != nil {
+		t.Errorf("token signed after rotation should verify: %v", err)
+	}
+
+	if err = os.Remove(filepath.Join(dir, "2026-01.pem")); err != nil {
+		t.Fatalf("remove key: %v", err)
+	}
+	if err = p.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err = verify(p, oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of a retired key should fail with ErrUnknownKey but got %v", err)
+	}
+}
+
+func TestLoadDirActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "a", true)
+	writeKey(t, dir, "z-published", false)
+
+	p, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p.ActiveId() != "a" {
+		t.Errorf("public-only keys must not become active, got %q", p.ActiveId())
+	}
+	if n := len(p.JWKS().Keys); n != 2 {
+		t.Errorf("expected both keys in the jwks but got %d", n)
+	}
+
+	writeKey(t, dir, LegacyPrefix+"0123456789ab", true)
+	if p, err = LoadDir(dir, ""); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p.ActiveId() != "a" {
+		t.Errorf("expected a legacy key not to sort after a new one, got %q", p.ActiveId())
+	}
+
+	if _, err = LoadDir(dir, "z-published"); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected ErrNoSigningKey for a public-only active key but got %v", err)
+	}
+	if _, err = LoadDir(t.TempDir(), ""); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected ErrNoSigningKey for an empty directory but got %v", err)
+	}
+}
+
+func TestKeyfuncWithoutKid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	p, err := New("", Key{Id: "only", Private: key, Public: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1"}).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err = verify(p, legacy); err != nil {
+		t.Errorf("tokens without kid should verify against the active key: %v", err)
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "private_key", true)
+	p, err := LoadLegacy(filepath.Join(dir, "private_key.pem"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	again, err := LoadLegacy(filepath.Join(dir, "private_key.pem"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p.ActiveId() != again.ActiveId() || len(p.ActiveId()) != len("legacy-")+12 {
+		t.Errorf("expected a stable legacy- id but got %q and %q", p.ActiveId(), again.ActiveId())
+	}
+	token, err := p.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err = verify(p, token); err != nil {
+		t.Errorf("expected the token to verify: %v", err)
+	}
+
+	writeKey(t, dir, "public_key", false)
+	if _, err = LoadLegacy(filepath.Join(dir, "public_key.pem")); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected ErrNoSigningKey for a public key but got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Package lockout throttles repeated login failures per account and per client IP.
+// Failures back off exponentially and enough of them lock the key out for a while.
+// Counters live in a Store so several server instances can share them.
+package lockout
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Entry is the failure state of one key
+type Entry struct {
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+// Store keeps failure counters. Fail must be atomic, concurrent failures of the same
+// key have to all be counted.
+type Store interface {
+	// Get returns the entry of key, a zero Entry if there is none
+	Get(ctx context.Context, key string) (Entry, error)
+	// Fail counts a failure at now, starting over if the last one is older than window
+	Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error)
+	// Block rejects attempts on key until the given time
+	Block(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// Prune drops entries whose last failure and block both ended before the given time
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Policy decides how long a key is blocked after a number of failures
+type Policy struct {
+	// FreeAttempts failures are allowed before any delay
+	FreeAttempts int
+	// BaseDelay doubles with every failure past FreeAttempts up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures block the key for LockoutDuration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// Delay returns how long a key is blocked after its nth failure
+func (p Policy) Delay(failures int) time.Duration {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+var (
+	// DefaultAccountPolicy locks an account for 15 minutes after 10 failures in a row
+	DefaultAccountPolicy = Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	// DefaultIPPolicy is looser since many users can share an address behind NAT
+	DefaultIPPolicy = Policy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+	// ResetAccountPolicy spaces password reset emails to one address a minute apart, then
+	// doubling up to an hour
+	ResetAccountPolicy = Policy{
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Hour,
+		Window:    time.Hour,
+	}
+	// ResetIPPolicy lets one address ask for a few resets before spacing them out
+	ResetIPPolicy = Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// Limiter applies an account and an IP policy on top of a Store
+type Limiter struct {
+	Store   Store
+	Prefix  string //of its keys, so limiters can share a Store
+	Account Policy
+	IP      Policy
+	Now     func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{Store: store, Account: DefaultAccountPolicy, IP: DefaultIPPolicy}
+}
+
+// NewReset throttles password reset requests, each request counting as a failure
+func NewReset(store Store) *Limiter {
+	return &Limiter{Store: store, Prefix: "reset:", Account: ResetAccountPolicy, IP: ResetIPPolicy}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// accountKey is keyed by the submitted email, not the user id, so unknown emails
+// are throttled exactly like real accounts and lockouts say nothing about existence
+func (l *Limiter) accountKey(email string) string {
+	return l.Prefix + "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func (l *Limiter) ipKey(ip string) string {
+	return l.Prefix + "ip:" + ip
+}
+
+// Check returns how long the caller has to wait before trying to log in, 0 if they may now
+func (l *Limiter) Check(ctx context.Context, email string, ip string) (time.Duration, error) {
+	now := l.now()
+	var wait time.Duration
+	for _, key := range []string{l.accountKey(email), l.ipKey(ip)} {
+		e, err := l.Store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if e.BlockedUntil.After(now) {
+			wait = max(wait, e.BlockedUntil.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// Failure counts a failed login against the account and the IP
+func (l *Limiter) Failure(ctx context.Context, email string, ip string) error {
+	now := l.now()
+	for _, k := range []struct {
+		key    string
+		policy Policy
+	}{{l.accountKey(email), l.Account}, {l.ipKey(ip), l.IP}} {
+		e, err := l.Store.Fail(ctx, k.key, now, k.policy.Window)
+		if err != nil {
+			return err
+		}
+		if delay := k.policy.Delay(e.Failures); delay > 0 {
+			if err = l.Store.Block(ctx, k.key, now.Add(delay)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Success clears the account's failures. The IP counter is left alone, otherwise an
+// attacker could reset it by logging into an account of their own between guesses.
+func (l *Limiter) Success(ctx context.Context, email string) error {
+	return l.Store.Reset(ctx, l.accountKey(email))
+}
+
+// Unlock clears the failures and lockout of an account
+func (l *Limiter) Unlock(ctx context.Context, email string) error {
+	return l.Store.Reset(ctx, l.accountKey(email))
+}
+
+// Run prunes forgotten entries every interval, it does not return
+func (l *Limiter) Run(interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		before := l.now().Add(-max(l.Account.Window, l.IP.Window))
+		if n, err := l.Store.Prune(context.Background(), before); err != nil {
+			logger.Error("login throttle pruning failed", "error", err)
+		} else if n > 0 {
+			logger.Info("pruned login throttle entries", "count", n)
+		}
+	}
+}
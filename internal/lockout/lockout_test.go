@@ -0,0 +1,159 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, LockoutAfter: 10, LockoutDuration: time.Hour}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, time.Hour},
+		{25, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.expected {
+			t.Errorf("Delay(%d): expected %v but got %v", tt.failures, tt.expected, got)
+		}
+	}
+}
+
+func testLimiter() (*Limiter, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	l := New(NewMemory())
+	l.Account = Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutAfter: 5, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+	l.IP = Policy{FreeAttempts: 100, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	l.Now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterBackoffAndLockout(t *testing.T) {
+	l, now := testLimiter()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	}
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Fatalf("expected free attempts to not be throttled, got %v", wait)
+	}
+	l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != time.Second {
+		t.Fatalf("expected a 1s backoff, got %v", wait)
+	}
+	// email case and another IP make no difference to the account counter
+	if wait, _ := l.Check(ctx, "JANE@example.com ", "10.9.9.9"); wait != time.Second {
+		t.Fatalf("expected the account to be throttled from any IP, got %v", wait)
+	}
+	l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 15*time.Minute {
+		t.Fatalf("expected a 15m lockout, got %v", wait)
+	}
+	*now = now.Add(16 * time.Minute)
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Fatalf("expected the lockout to be temporary, got %v", wait)
+	}
+}
+
+func TestLimiterUnknownEmailsThrottledAlike(t *testing.T) {
+	l, _ := testLimiter()
+	ctx := context.Background()
+	for _, email := range []string{"real@example.com", "nobody@example.com"} {
+		for i := 0; i < 5; i++ {
+			l.Failure(ctx, email, "10.0.0.1")
+		}
+	}
+	a, _ := l.Check(ctx, "real@example.com", "10.0.0.2")
+	b, _ := l.Check(ctx, "nobody@example.com", "10.0.0.2")
+	if a != b || a == 0 {
+		t.Errorf("expected identical lockouts but got %v and %v", a, b)
+	}
+}
+
+func TestLimiterIPCounter(t *testing.T) {
+	l, _ := testLimiter()
+	l.IP = Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		l.Failure(ctx, "user"+string(rune('a'+i))+"@example.com", "10.0.0.1")
+	}
+	if wait, _ := l.Check(ctx, "fresh@example.com", "10.0.0.1"); wait != time.Second {
+		t.Errorf("expected the IP to be throttled across accounts, got %v", wait)
+	}
+	if wait, _ := l.Check(ctx, "fresh@example.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("expected another IP to be unaffected, got %v", wait)
+	}
+}
+
+func TestLimiterSuccessAndUnlock(t *testing.T) {
+	l, _ := testLimiter()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	}
+	if err := l.Unlock(ctx, "Jane@Example.com"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("expected unlock to clear the lockout, got %v", wait)
+	}
+	for i := 0; i < 2; i++ {
+		l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	}
+	l.Success(ctx, "jane@example.com")
+	l.Failure(ctx, "jane@example.com", "10.0.0.1")
+	if wait, _ := l.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("expected success to reset the account counter, got %v", wait)
+	}
+}
+
+func TestResetLimiterSharesStore(t *testing.T) {
+	store := NewMemory()
+	login, reset := New(store), NewReset(store)
+	ctx := context.Background()
+
+	if wait, _ := reset.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Fatalf("expected the first reset to go through, got %v", wait)
+	}
+	reset.Failure(ctx, "jane@example.com", "10.0.0.1")
+	if wait, _ := reset.Check(ctx, "jane@example.com", "10.0.0.2"); wait <= 0 || wait > time.Minute {
+		t.Errorf("expected a second reset to wait up to a minute, got %v", wait)
+	}
+	if wait, _ := login.Check(ctx, "jane@example.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("expected resets not to throttle logins, got %v", wait)
+	}
+}
+
+func TestMemoryWindowAndPrune(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	m.Fail(ctx, "k", start, time.Hour)
+	m.Fail(ctx, "k", start.Add(time.Minute), time.Hour)
+	e, _ := m.Fail(ctx, "k", start.Add(2*time.Hour), time.Hour)
+	if e.Failures != 1 {
+		t.Errorf("expected failures older than the window to be forgotten, got %d", e.Failures)
+	}
+	m.Fail(ctx, "blocked", start, time.Hour)
+	m.Block(ctx, "blocked", start.Add(5*time.Hour))
+	n, _ := m.Prune(ctx, start.Add(3*time.Hour))
+	if n != 1 {
+		t.Errorf("expected 1 entry pruned but got %d", n)
+	}
+	if e, _ := m.Get(ctx, "blocked"); e.Failures != 1 {
+		t.Error("expected a still blocked entry to be kept")
+	}
+}
@@ -0,0 +1,65 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Store for a single instance and for tests
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]Entry{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[key], nil
+}
+
+func (m *Memory) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[key]
+	if now.Sub(e.LastFailure) > window {
+		e.Failures = 0
+	}
+	e.Failures++
+	e.LastFailure = now
+	m.entries[key] = e
+	return e, nil
+}
+
+func (m *Memory) Block(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[key]
+	e.BlockedUntil = until
+	m.entries[key] = e
+	return nil
+}
+
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Prune(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key, e := range m.entries {
+		if e.LastFailure.Before(before) && e.BlockedUntil.Before(before) {
+			delete(m.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,36 @@
+package lockout
+
+import (
+	"context"
+	"lapbytes/internal/store/queries"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres is a Store shared by every instance using the same database
+type Postgres struct {
+	Pool *pgxpool.Pool
+}
+
+func (p *Postgres) Get(ctx context.Context, key string) (Entry, error) {
+	failures, last, blocked, err := queries.GetLoginThrottle(p.Pool, key)
+	return Entry{Failures: failures, LastFailure: last, BlockedUntil: blocked}, err
+}
+
+func (p *Postgres) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error) {
+	failures, blocked, err := queries.FailLoginThrottle(p.Pool, key, now, now.Add(-window))
+	return Entry{Failures: failures, LastFailure: now, BlockedUntil: blocked}, err
+}
+
+func (p *Postgres) Block(ctx context.Context, key string, until time.Time) error {
+	return queries.BlockLoginThrottle(p.Pool, key, until)
+}
+
+func (p *Postgres) Reset(ctx context.Context, key string) error {
+	return queries.ResetLoginThrottle(p.Pool, key)
+}
+
+func (p *Postgres) Prune(ctx context.Context, before time.Time) (int64, error) {
+	return queries.PruneLoginThrottle(p.Pool, before)
+}
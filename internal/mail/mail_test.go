@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+	if err := m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "body"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 1 || msgs[0].To != "a@example.com" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+}
+
+func TestRejectsHeaderInjection(t *testing.T) {
+	m := &Memory{}
+	for _, msg := range []Message{
+		{To: "", Subject: "s"},
+		{To: "a@example.com\r\nBcc: b@example.com", Subject: "s"},
+		{To: "a@example.com", Subject: "s\nBcc: b@example.com"},
+	} {
+		if err := m.Send(context.Background(), msg); err == nil {
+			t.Errorf("expected %+v to be rejected", msg)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	f := &File{Dir: dir, From: "shop@example.com"}
+	if err := f.Send(context.Background(), Message{To: "a@example.com", Subject: "Reset", Body: "line1\nline2"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one .eml file but got %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, want := range []string{"From: shop@example.com\r\n", "To: a@example.com\r\n", "Subject: Reset\r\n", "line1\r\nline2"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected message to contain %q", want)
+		}
+	}
+}
+
+func TestFormatEncodesSubject(t *testing.T) {
+	out := string(format("x@example.com", Message{To: "a@example.com", Subject: "Bienvenue à LapBytes"}, time.Now()))
+	if !strings.Contains(out, "Subject: =?utf-8?q?") {
+		t.Errorf("expected an encoded subject, got %q", out)
+	}
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"time"
 )
@@ -9,6 +10,7 @@ import (
 type Laptop struct {
 	//Properties
 	Id                       int            `json:"id" db:"id"`
+	Sku                      string         `json:"sku" db:"sku"` //stock keeping unit, empty for products added before SKUs
 	Name                     string         `json:"name" db:"name"`
 	Brand                    string         `json:"brand" db:"brand"`
 	Operating_system         string         `json:"operating_system" db:"operatingsystem"`
@@ -30,10 +32,30 @@ type Laptop struct {
 	Gpu_maker                sql.NullString `json:"gpu_manufacturer" db:"gpumaker"`
 	Has_igpu                 bool           `json:"has_integrated_gpu" db:"hasigpu"`
 
-	Is_in_stock bool      `json:"is_in_stock" db:"isinstock"`
-	In_stock    int       `json:"-" db:"instock"`
-	Created_at  time.Time `json:"-" db:"createdat"`
-	Updated_at  time.Time `json:"-" db:"updatedat"`
+	Is_in_stock bool       `json:"is_in_stock" db:"isinstock"`
+	In_stock    int        `json:"-" db:"instock"`
+	Created_at  time.Time  `json:"-" db:"createdat"`
+	Updated_at  time.Time  `json:"-" db:"updatedat"`
+	Version     int        `json:"version" db:"version"` //bumped on every update, used as the ETag
+	Deleted_at  *time.Time `json:"deleted_at,omitempty" db:"deletedat"`
+
+	Images []ProductImage `json:"images,omitempty"` //in display order, only loaded for a single laptop
+}
+
+// ProductImage is one uploaded picture of a laptop
+type ProductImage struct {
+	Id               int               `json:"id" db:"id"`
+	Product_id       int               `json:"product_id" db:"productid"`
+	Position         int               `json:"position" db:"position"`
+	Key              string            `json:"-" db:"storagekey"`
+	Url              string            `json:"url" db:"url"`
+	Content_type     string            `json:"content_type" db:"contenttype"`
+	Width            int               `json:"width" db:"width"`
+	Height           int               `json:"height" db:"height"`
+	Bytes            int               `json:"bytes" db:"bytes"`
+	Thumbnails       map[string]string `json:"thumbnails" db:"thumbnails"` //size in pixels to URL, filled in by the thumbnail worker
+	Thumbnail_status string            `json:"thumbnail_status" db:"thumbnailstatus"`
+	Created_at       time.Time         `json:"created_at" db:"createdat"`
 }
 type User struct {
 	Id            int       `db:"id"`
@@ -44,14 +66,216 @@ type User struct {
 	Access_level  int       `db:"accesslevel"` //There will be 5 levels of access with 0 being the highest (super user) and 4 the lowest
 	Created_at    time.Time `db:"createdat"`
 	Updated_at    time.Time `db:"updatedat"`
+
+	Email_verified       bool       `db:"emailverified"`
+	Email_verified_at    *time.Time `db:"emailverifiedat"`
+	Verification_sent_at *time.Time `db:"verificationsentat"`
+
+	Totp_enabled bool `db:"totpenabled"`
+
+	Suspended        bool       `db:"suspended"`
+	Suspended_at     *time.Time `db:"suspendedat"`
+	Suspended_reason string     `db:"suspendedreason"`
+}
+
+// UserSummary is how admin endpoints show a user, without credentials
+type UserSummary struct {
+	Id               int        `json:"id" db:"id"`
+	Username         string     `json:"username" db:"username"`
+	Email            string     `json:"email" db:"email"`
+	Access_level     int        `json:"access_level" db:"accesslevel"`
+	Email_verified   bool       `json:"email_verified" db:"emailverified"`
+	Totp_enabled     bool       `json:"totp_enabled" db:"totpenabled"`
+	Suspended        bool       `json:"suspended" db:"suspended"`
+	Suspended_at     *time.Time `json:"suspended_at,omitempty" db:"suspendedat"`
+	Suspended_reason string     `json:"suspended_reason,omitempty" db:"suspendedreason"`
+	Created_at       time.Time  `json:"created_at" db:"createdat"`
+	Deleted_at       *time.Time `json:"deleted_at,omitempty" db:"deletedat"`
 }
 
 type Cart struct {
+	Id         int        `json:"id" db:"id"`
+	User_id    int        `json:"-" db:"userid"`
+	Items      []CartItem `json:"items"`
+	Item_count int        `json:"item_count"`
+	Subtotal   float64    `json:"subtotal"`
+	Created_at time.Time  `json:"-" db:"createdat"`
+	Updated_at time.Time  `json:"-" db:"updatedat"`
+}
+
+// CartItem is a cart line joined with the product's current price and stock
+type CartItem struct {
+	Product_id  int     `json:"product_id" db:"productid"`
+	Name        string  `json:"name" db:"name"`
+	Brand       string  `json:"brand" db:"brand"`
+	Image_url   string  `json:"image_url" db:"imageurl"`
+	Unit_price  float64 `json:"unit_price" db:"price"`
+	Quantity    int     `json:"quantity" db:"quantity"`
+	Line_total  float64 `json:"line_total"`
+	Available   int     `json:"available" db:"instock"`
+	Is_in_stock bool    `json:"is_in_stock" db:"isinstock"`
+	Archived    bool    `json:"archived"` //the product is no longer sold, the line can only be removed
+	Stock_ok    bool    `json:"stock_ok"` //false when the product sold out, was archived or quantity exceeds what is left
+}
+
+type ShippingDetails struct {
+	Name    string `json:"name" db:"shippingname"`
+	Phone   string `json:"phone" db:"shippingphone"`
+	Address string `json:"address" db:"shippingaddress"`
+	City    string `json:"city" db:"shippingcity"`
+}
+
+// Order statuses. An order starts as pending_payment and only moves along OrderTransitions.
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPaid           = "paid"
+	OrderPacked         = "packed"
+	OrderShipped        = "shipped"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+	OrderRefunded       = "refunded"
+)
+
+// OrderTransitions lists the statuses each status may move to, cancelled and refunded are final
+var OrderTransitions = map[string][]string{
+	OrderPendingPayment: {OrderPaid, OrderCancelled},
+	OrderPaid:           {OrderPacked, OrderRefunded},
+	OrderPacked:         {OrderShipped, OrderRefunded},
+	OrderShipped:        {OrderDelivered},
+	OrderDelivered:      {OrderRefunded},
+	OrderCancelled:      {},
+	OrderRefunded:       {},
+}
+
+// CanTransitionOrder reports whether an order in status from may move to status to
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOrderStatus reports whether status is a known order status
+func IsOrderStatus(status string) bool {
+	_, ok := OrderTransitions[status]
+	return ok
+}
+
+// Who moved an order between statuses
+const (
+	ActorUser   = "user"
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// OrderEvent is one recorded status transition of an order
+type OrderEvent struct {
+	From       *string   `json:"from" db:"fromstatus"`
+	To         string    `json:"to" db:"tostatus"`
+	Actor_type string    `json:"actor_type" db:"actortype"`
+	Actor_id   *int      `json:"actor_id,omitempty" db:"actorid"`
+	Note       string    `json:"note,omitempty" db:"note"`
+	Created_at time.Time `json:"created_at" db:"createdat"`
+}
+
+type Order struct {
+	Id         int             `json:"id" db:"id"`
+	User_id    int             `json:"user_id" db:"userid"`
+	Status     string          `json:"status" db:"status"`
+	Total      float64         `json:"total" db:"total"`
+	Shipping   ShippingDetails `json:"shipping"`
+	Items      []OrderItem     `json:"items,omitempty"`
+	History    []OrderEvent    `json:"history,omitempty"`
+	Created_at time.Time       `json:"created_at" db:"createdat"`
+	Updated_at time.Time       `json:"updated_at" db:"updatedat"`
+}
+
+// OrderItem is a snapshot of a product at the time it was ordered
+type OrderItem struct {
+	Product_id int             `json:"product_id" db:"productid"`
+	Name       string          `json:"name" db:"name"`
+	Brand      string          `json:"brand" db:"brand"`
+	Unit_price float64         `json:"unit_price" db:"unitprice"`
+	Quantity   int             `json:"quantity" db:"quantity"`
+	Line_total float64         `json:"line_total"`
+	Specs      json.RawMessage `json:"specs" db:"specs"`
+}
+
+// Payment statuses, mirrored by the payment package
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+	PaymentRefunded  = "refunded"
+
+	PaymentInitiating = "initiating" //recorded before the provider is asked, its reference is a placeholder
+	PaymentRefunding  = "refunding"  //claimed by a refund that is waiting on the provider
+)
+
+// Payment is one attempt to pay for an order through a provider
+type Payment struct {
+	Id         int       `json:"id" db:"id"`
+	Order_id   int       `json:"order_id" db:"orderid"`
+	Provider   string    `json:"provider" db:"provider"`
+	Reference  string    `json:"reference" db:"reference"`
+	Receipt    string    `json:"receipt,omitempty" db:"receipt"`
+	Amount     float64   `json:"amount" db:"amount"`
+	Phone      string    `json:"-" db:"phone"`
+	Status     string    `json:"status" db:"status"`
+	Message    string    `json:"message,omitempty" db:"message"`
+	Created_at time.Time `json:"created_at" db:"createdat"`
+	Updated_at time.Time `json:"updated_at" db:"updatedat"`
+}
+
+// CartMergeItem reports what happened to one guest cart line when it was merged on login
+type CartMergeItem struct {
+	Product_id int    `json:"product_id"`
+	Name       string `json:"name"`
+	Requested  int    `json:"requested"` //guest quantity plus what the user already had
+	Quantity   int    `json:"quantity"`  //quantity now in the user's cart
+	Status     string `json:"status"`    //merged, adjusted or removed
+	Reason     string `json:"reason,omitempty"`
+}
+
+type CartMergeResult struct {
+	Changed bool            `json:"changed"` //true when any line was adjusted or removed
+	Items   []CartMergeItem `json:"items"`
 }
 
 type LoginResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
+	AccessToken    string `json:"access_token"`
+	TokenType      string `json:"token_type"`
+	Email_verified bool   `json:"email_verified"`
+	// Totp_required is set for admin accounts that still have to enrol in two-factor
+	// authentication, their tokens are refused by admin endpoints until they do
+	Totp_required bool             `json:"totp_required,omitempty"`
+	Cart_merge    *CartMergeResult `json:"cart_merge,omitempty"`
+}
+
+// LoginChallengeResponse is returned instead of tokens when the account has two-factor
+// authentication enabled, the challenge is exchanged with a TOTP or recovery code
+type LoginChallengeResponse struct {
+	Totp_required bool      `json:"totp_required"`
+	Challenge     string    `json:"challenge"`
+	Expires_at    time.Time `json:"expires_at"`
+}
+
+// Session is one refresh token. Rotating a token creates the next session in the
+// same family, so a reused token can revoke every session descended from the login.
+type Session struct {
+	Id         int        `json:"id" db:"id"`
+	User_id    int        `json:"user_id" db:"userid"`
+	Family_id  string     `json:"family_id" db:"familyid"`
+	Token_hash string     `json:"-" db:"tokenhash"`
+	User_agent string     `json:"user_agent" db:"useragent"`
+	Ip         string     `json:"ip" db:"ip"`
+	Expires_at time.Time  `json:"expires_at" db:"expiresat"`
+	Used_at    *time.Time `json:"used_at,omitempty" db:"usedat"`
+	Revoked_at *time.Time `json:"revoked_at,omitempty" db:"revokedat"`
+	Mfa        bool       `json:"mfa" db:"mfa"`
+	Created_at time.Time  `json:"created_at" db:"createdat"`
 }
 
 type RefreshHttpOnlyCookie struct {
@@ -63,3 +287,20 @@ type RefreshHttpOnlyCookie struct {
 	SameSite http.SameSite
 	Expires  time.Time
 }
+
+// AuditEntry records one privileged action. Hash covers every other field except Id
+// and includes Prev_hash, so each entry is chained to the one before it.
+type AuditEntry struct {
+	Id          int64           `json:"id" db:"id"`
+	Actor_id    int             `json:"actor_id" db:"actorid"`
+	Actor_level int             `json:"actor_level" db:"actorlevel"`
+	Action      string          `json:"action" db:"action"`
+	Target_type string          `json:"target_type" db:"targettype"`
+	Target_id   string          `json:"target_id" db:"targetid"`
+	Ip          string          `json:"ip" db:"ip"`
+	Before      json.RawMessage `json:"before,omitempty" db:"before"`
+	After       json.RawMessage `json:"after,omitempty" db:"after"`
+	Created_at  time.Time       `json:"created_at" db:"createdat"`
+	Prev_hash   string          `json:"prev_hash" db:"prevhash"`
+	Hash        string          `json:"hash" db:"hash"`
+}
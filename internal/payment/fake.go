@@ -0,0 +1,145 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeSignatureHeader carries the hex HMAC-SHA256 of a fake callback body
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Fake is a deterministic in-process provider for tests and local development.
+// Payments from phone numbers ending in "0000" fail, all others succeed. With
+// AutoSettle the outcome is returned by Initiate, otherwise the payment stays
+// pending until a callback built by CallbackRequest is delivered.
+type Fake struct {
+	Secret     []byte
+	AutoSettle bool
+
+	mu       sync.Mutex
+	seq      int
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	amount  float64
+	outcome Result
+	current Result
+}
+
+type fakeCallback struct {
+	Reference string `json:"reference"`
+	Receipt   string `json:"receipt"`
+	Status    Status `json:"status"`
+	Message   string `json:"message"`
+}
+
+func NewFake(secret []byte, autoSettle bool) *Fake {
+	return &Fake{Secret: secret, AutoSettle: autoSettle, payments: map[string]*fakePayment{}}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Initiate(ctx context.Context, req Request) (Result, error) {
+	if req.Amount <= 0 {
+		return Result{}, fmt.Errorf("invalid amount %.2f", req.Amount)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	ref := fmt.Sprintf("FAKE-%d-%d", req.OrderId, f.seq)
+	outcome := Result{Reference: ref, Receipt: "R" + ref, Status: StatusSucceeded, Message: "paid"}
+	if strings.HasSuffix(req.Phone, "0000") {
+		outcome = Result{Reference: ref, Status: StatusFailed, Message: "insufficient funds"}
+	}
+	p := &fakePayment{amount: req.Amount, outcome: outcome, current: Result{Reference: ref, Status: StatusPending}}
+	if f.AutoSettle {
+		p.current = outcome
+	}
+	f.payments[ref] = p
+	return p.current, nil
+}
+
+// CallbackRequest builds the signed callback the fake gateway would send for a payment
+// and marks the payment settled.
+func (f *Fake) CallbackRequest(target string, reference string) (*http.Request, error) {
+	f.mu.Lock()
+	p, ok := f.payments[reference]
+	if ok {
+		p.current = p.outcome
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownPayment
+	}
+	body, err := json.Marshal(fakeCallback{Reference: reference, Receipt: p.outcome.Receipt, Status: p.outcome.Status, Message: p.outcome.Message})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FakeSignatureHeader, f.sign(body))
+	return req, nil
+}
+
+func (f *Fake) sign(body []byte) string {
+	mac := hmac.New(sha256.New, f.Secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *Fake) ParseCallback(r *http.Request) (Result, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		return Result{}, err
+	}
+	if !hmac.Equal([]byte(f.sign(body)), []byte(r.Header.Get(FakeSignatureHeader))) {
+		return Result{}, ErrInvalidCallback
+	}
+	var cb fakeCallback
+	if err := json.Unmarshal(body, &cb); err != nil || cb.Reference == "" || !cb.Status.Final() {
+		return Result{}, ErrInvalidCallback
+	}
+	return Result{Reference: cb.Reference, Receipt: cb.Receipt, Status: cb.Status, Message: cb.Message}, nil
+}
+
+func (f *Fake) Status(ctx context.Context, reference string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[reference]
+	if !ok {
+		return Result{}, ErrUnknownPayment
+	}
+	return p.current, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paid Result, amount float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reference := paid.Reference
+	p, ok := f.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if p.current.Status != StatusSucceeded {
+		return fmt.Errorf("cannot refund a payment in status %s", p.current.Status)
+	}
+	if amount <= 0 || amount > p.amount {
+		return fmt.Errorf("invalid refund amount %.2f", amount)
+	}
+	p.current = Result{Reference: reference, Status: StatusRefunded, Message: "refunded"}
+	return nil
+}
@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakeCallbackFlow(t *testing.T) {
+	fake := NewFake([]byte("secret"), false)
+	ctx := context.Background()
+
+	res, err := fake.Initiate(ctx, Request{OrderId: 7, Amount: 1500, Phone: "0712345678"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != StatusPending {
+		t.Fatalf("expected pending but got %s", res.Status)
+	}
+
+	req, err := fake.CallbackRequest("http://localhost/api/payments/fake/callback", res.Reference)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cb, err := fake.ParseCallback(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cb.Reference != res.Reference || cb.Status != StatusSucceeded || cb.Receipt == "" {
+		t.Errorf("unexpected callback result %+v", cb)
+	}
+
+	status, err := fake.Status(ctx, res.Reference)
+	if err != nil || status.Status != StatusSucceeded {
+		t.Errorf("expected succeeded status but got %+v %v", status, err)
+	}
+
+	if err := fake.Refund(ctx, cb, 1500); err != nil {
+		t.Fatalf("unexpected refund error: %v", err)
+	}
+	status, _ = fake.Status(ctx, res.Reference)
+	if status.Status != StatusRefunded {
+		t.Errorf("expected refunded but got %s", status.Status)
+	}
+	if err := fake.Refund(ctx, cb, 1500); err == nil {
+		t.Error("expected second refund to fail")
+	}
+}
+
+func TestFakeDeterministicFailure(t *testing.T) {
+	fake := NewFake([]byte("secret"), true)
+	res, err := fake.Initiate(context.Background(), Request{OrderId: 1, Amount: 100, Phone: "0700000000"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != StatusFailed {
+		t.Errorf("expected failed but got %s", res.Status)
+	}
+}
+
+func TestFakeRejectsTamperedCallback(t *testing.T) {
+	fake := NewFake([]byte("secret"), false)
+	res, _ := fake.Initiate(context.Background(), Request{OrderId: 1, Amount: 100, Phone: "0711111111"})
+
+	req, _ := fake.CallbackRequest("http://localhost/cb", res.Reference)
+	req.Header.Set(FakeSignatureHeader, "00")
+	if _, err := fake.ParseCallback(req); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("expected ErrInvalidCallback but got %v", err)
+	}
+
+	if _, err := fake.CallbackRequest("http://localhost/cb", "missing"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected ErrUnknownPayment but got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mpesaStillProcessing is the STK query error code while the customer has not answered yet
+const mpesaStillProcessing = "500.001.1001"
+
+// MPesa is a mobile-money provider using the Daraja STK push flow: Initiate sends a
+// payment prompt to the customer's phone and the result arrives on CallbackURL.
+// BaseURL may point at a local stand-in instead of Safaricom.
+type MPesa struct {
+	BaseURL        string
+	ConsumerKey    string
+	ConsumerSecret string
+	ShortCode      string
+	PassKey        string
+	// CallbackURL must carry ?token=CallbackToken, Daraja does not sign callbacks
+	// so the shared token is how they are verified.
+	CallbackURL   string
+	CallbackToken string
+	// Initiator and SecurityCredential authorise reversals (refunds)
+	Initiator          string
+	SecurityCredential string
+	ReversalResultURL  string
+
+	Client *http.Client
+	Now    func() time.Time
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func (m *MPesa) Name() string { return "mpesa" }
+
+func (m *MPesa) client() *http.Client {
+	if m.Client != nil {
+		return m.Client
+	}
+	return http.DefaultClient
+}
+
+func (m *MPesa) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+// NormalizeMSISDN converts Kenyan phone numbers such as 0712345678 or +254712345678 to 254712345678
+func NormalizeMSISDN(phone string) (string, error) {
+	p := strings.NewReplacer(" ", "", "-", "", "+", "").Replace(phone)
+	if strings.HasPrefix(p, "0") && len(p) == 10 {
+		p = "254" + p[1:]
+	}
+	if len(p) != 12 || !strings.HasPrefix(p, "254") {
+		return "", fmt.Errorf("invalid phone number %q", phone)
+	}
+	if _, err := strconv.ParseUint(p, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid phone number %q", phone)
+	}
+	return p, nil
+}
+
+// accessToken returns a cached OAuth token, refreshing it shortly before it expires
+func (m *MPesa) accessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && m.now().Before(m.tokenExpiry) {
+		return m.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(m.ConsumerKey, m.ConsumerSecret)
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mpesa oauth: status %d", resp.StatusCode)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	seconds, _ := strconv.Atoi(body.ExpiresIn)
+	m.token = body.AccessToken
+	m.tokenExpiry = m.now().Add(time.Duration(seconds)*time.Second - time.Minute)
+	return m.token, nil
+}
+
+// post sends an authorised JSON request and decodes the JSON response into out
+func (m *MPesa) post(ctx context.Context, path string, in any, out any) (int, error) {
+	token, err := m.accessToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("mpesa %s: decoding response: %w", path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func (m *MPesa) password(timestamp string) string {
+	return base64.StdEncoding.EncodeToString([]byte(m.ShortCode + m.PassKey + timestamp))
+}
+
+func (m *MPesa) Initiate(ctx context.Context, req Request) (Result, error) {
+	phone, err := NormalizeMSISDN(req.Phone)
+	if err != nil {
+		return Result{}, err
+	}
+	if req.Amount <= 0 {
+		return Result{}, fmt.Errorf("invalid amount %.2f", req.Amount)
+	}
+	timestamp := m.now().Format("20060102150405")
+	in := map[string]any{
+		"BusinessShortCode": m.ShortCode,
+		"Password":          m.password(timestamp),
+		"Timestamp":         timestamp,
+		"TransactionType":   "CustomerPayBillOnline",
+		"Amount":            int64(math.Ceil(req.Amount)), // M-Pesa only takes whole shillings
+		"PartyA":            phone,
+		"PartyB":            m.ShortCode,
+		"PhoneNumber":       phone,
+		"CallBackURL":       m.CallbackURL,
+		"AccountReference":  fmt.Sprintf("ORDER%d", req.OrderId),
+		"TransactionDesc":   req.Description,
+	}
+	var out struct {
+		CheckoutRequestID   string
+		ResponseCode        string
+		ResponseDescription string
+		CustomerMessage     string
+		ErrorMessage        string `json:"errorMessage"`
+	}
+	status, err := m.post(ctx, "/mpesa/stkpush/v1/processrequest", in, &out)
+	if err != nil {
+		return Result{}, err
+	}
+	if status != http.StatusOK || out.ResponseCode != "0" {
+		return Result{}, fmt.Errorf("mpesa stk push rejected: %d %s%s", status, out.ResponseDescription, out.ErrorMessage)
+	}
+	return Result{Reference: out.CheckoutRequestID, Status: StatusPending, Message: out.CustomerMessage}, nil
+}
+
+type mpesaCallback struct {
+	Body struct {
+		StkCallback struct {
+			CheckoutRequestID string
+			ResultCode        int
+			ResultDesc        string
+			CallbackMetadata  struct {
+				Item []struct {
+					Name  string
+					Value any
+				}
+			}
+		} `json:"stkCallback"`
+	}
+}
+
+func (m *MPesa) ParseCallback(r *http.Request) (Result, error) {
+	if m.CallbackToken == "" || !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(m.CallbackToken)) {
+		return Result{}, ErrInvalidCallback
+	}
+	var cb mpesaCallback
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&cb); err != nil {
+		return Result{}, ErrInvalidCallback
+	}
+	stk := cb.Body.StkCallback
+	if stk.CheckoutRequestID == "" {
+		return Result{}, ErrInvalidCallback
+	}
+	res := Result{Reference: stk.CheckoutRequestID, Status: StatusFailed, Message: stk.ResultDesc}
+	if stk.ResultCode == 0 {
+		res.Status = StatusSucceeded
+		for _, item := range stk.CallbackMetadata.Item {
+			if item.Name == "MpesaReceiptNumber" {
+				res.Receipt = fmt.Sprint(item.Value)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (m *MPesa) Status(ctx context.Context, reference string) (Result, error) {
+	timestamp := m.now().Format("20060102150405")
+	in := map[string]any{
+		"BusinessShortCode": m.ShortCode,
+		"Password":          m.password(timestamp),
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": reference,
+	}
+	var out struct {
+		ResponseCode string
+		ResultCode   string
+		ResultDesc   string
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	status, err := m.post(ctx, "/mpesa/stkpushquery/v1/query", in, &out)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case out.ErrorCode == mpesaStillProcessing:
+		return Result{Reference: reference, Status: StatusPending, Message: out.ErrorMessage}, nil
+	case status != http.StatusOK:
+		return Result{}, fmt.Errorf("mpesa stk query failed: %d %s %s", status, out.ErrorCode, out.ErrorMessage)
+	case out.ResultCode == "0":
+		// the query API does not return the receipt, the callback does
+		return Result{Reference: reference, Status: StatusSucceeded, Message: out.ResultDesc}, nil
+	default:
+		return Result{Reference: reference, Status: StatusFailed, Message: out.ResultDesc}, nil
+	}
+}
+
+// Refund requests a reversal of the M-Pesa transaction. The reversal outcome is
+// delivered asynchronously to ReversalResultURL; acceptance of the request is treated as refunded.
+func (m *MPesa) Refund(ctx context.Context, paid Result, amount float64) error {
+	if paid.Receipt == "" {
+		return fmt.Errorf("mpesa refund needs the transaction receipt of %s", paid.Reference)
+	}
+	in := map[string]any{
+		"Initiator":              m.Initiator,
+		"SecurityCredential":     m.SecurityCredential,
+		"CommandID":              "TransactionReversal",
+		"TransactionID":          paid.Receipt,
+		"Amount":                 int64(math.Ceil(amount)),
+		"ReceiverParty":          m.ShortCode,
+		"RecieverIdentifierType": "11",
+		"ResultURL":              m.ReversalResultURL,
+		"QueueTimeOutURL":        m.ReversalResultURL,
+		"Remarks":                "order refund",
+		"Occasion":               paid.Reference,
+	}
+	var out struct {
+		ResponseCode        string
+		ResponseDescription string
+		ErrorMessage        string `json:"errorMessage"`
+	}
+	status, err := m.post(ctx, "/mpesa/reversal/v1/request", in, &out)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || out.ResponseCode != "0" {
+		return fmt.Errorf("mpesa reversal rejected: %d %s%s", status, out.ResponseDescription, out.ErrorMessage)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMPesa(t *testing.T, handler http.HandlerFunc) *MPesa {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/v1/generate", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "key" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "tok", "expires_in": "3599"})
+	})
+	mux.HandleFunc("/mpesa/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &MPesa{
+		BaseURL:        server.URL,
+		ConsumerKey:    "key",
+		ConsumerSecret: "secret",
+		ShortCode:      "174379",
+		PassKey:        "pass",
+		CallbackURL:    "https://lapbytes.example/api/payments/mpesa/callback?token=cbtoken",
+		CallbackToken:  "cbtoken",
+		Now:            func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+}
+
+func TestNormalizeMSISDN(t *testing.T) {
+	valid := map[string]string{
+		"0712345678":     "254712345678",
+		"+254712345678":  "254712345678",
+		"254 712 345678": "254712345678",
+		"0112345678":     "254112345678",
+	}
+	for in, want := range valid {
+		got, err := NormalizeMSISDN(in)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %s but got %s %v", in, want, got, err)
+		}
+	}
+	for _, in := range []string{"", "12345", "0712abc678", "+1 555 0100 12"} {
+		if _, err := NormalizeMSISDN(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestMPesaInitiate(t *testing.T) {
+	var got map[string]any
+	m := newTestMPesa(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mpesa/stkpush/v1/processrequest" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]string{
+			"CheckoutRequestID": "ws_CO_1",
+			"ResponseCode":      "0",
+			"CustomerMessage":   "Success. Request accepted for processing",
+		})
+	})
+
+	res, err := m.Initiate(context.Background(), Request{OrderId: 12, Amount: 1499.5, Phone: "0712345678"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Reference != "ws_CO_1" || res.Status != StatusPending {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if got["Amount"] != float64(1500) {
+		t.Errorf("expected amount rounded up to 1500 but got %v", got["Amount"])
+	}
+	if got["PhoneNumber"] != "254712345678" || got["AccountReference"] != "ORDER12" {
+		t.Errorf("unexpected request %v", got)
+	}
+	if got["Timestamp"] != "20250102030405" {
+		t.Errorf("unexpected timestamp %v", got["Timestamp"])
+	}
+}
+
+func TestMPesaInitiateRejected(t *testing.T) {
+	m := newTestMPesa(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"errorMessage": "Invalid PhoneNumber"})
+	})
+	if _, err := m.Initiate(context.Background(), Request{OrderId: 1, Amount: 10, Phone: "0712345678"}); err == nil {
+		t.Error("expected error but got nil")
+	}
+}
+
+func TestMPesaParseCallback(t *testing.T) {
+	m := newTestMPesa(t, func(w http.ResponseWriter, r *http.Request) {})
+	body := `{"Body":{"stkCallback":{"MerchantRequestID":"1","CheckoutRequestID":"ws_CO_1","ResultCode":0,"ResultDesc":"ok",
+		"CallbackMetadata":{"Item":[{"Name":"Amount","Value":1500},{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"}]}}}}`
+
+	req := httptest.NewRequest("POST", "/api/payments/mpesa/callback?token=cbtoken", strings.NewReader(body))
+	res, err := m.ParseCallback(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Reference != "ws_CO_1" || res.Status != StatusSucceeded || res.Receipt != "NLJ7RT61SV" {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	failed := `{"Body":{"stkCallback":{"CheckoutRequestID":"ws_CO_2","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`
+	req = httptest.NewRequest("POST", "/api/payments/mpesa/callback?token=cbtoken", strings.NewReader(failed))
+	res, err = m.ParseCallback(req)
+	if err != nil || res.Status != StatusFailed {
+		t.Errorf("expected failed result but got %+v %v", res, err)
+	}
+
+	req = httptest.NewRequest("POST", "/api/payments/mpesa/callback?token=wrong", strings.NewReader(body))
+	if _, err := m.ParseCallback(req); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("expected ErrInvalidCallback but got %v", err)
+	}
+}
+
+func TestMPesaStatus(t *testing.T) {
+	responses := map[string]func(w http.ResponseWriter){
+		"ws_pending": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"errorCode": mpesaStillProcessing, "errorMessage": "The transaction is being processed"})
+		},
+		"ws_paid": func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "ResultCode": "0", "ResultDesc": "processed"})
+		},
+		"ws_cancelled": func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "ResultCode": "1032", "ResultDesc": "cancelled"})
+		},
+	}
+	m := newTestMPesa(t, func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		responses[in["CheckoutRequestID"]](w)
+	})
+
+	want := map[string]Status{"ws_pending": StatusPending, "ws_paid": StatusSucceeded, "ws_cancelled": StatusFailed}
+	for ref, status := range want {
+		res, err := m.Status(context.Background(), ref)
+		if err != nil || res.Status != status {
+			t.Errorf("%s: expected %s but got %+v %v", ref, status, res, err)
+		}
+	}
+}
+
+func TestMPesaRefundNeedsReceipt(t *testing.T) {
+	m := newTestMPesa(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0"})
+	})
+	if err := m.Refund(context.Background(), Result{Reference: "ws_CO_1"}, 100); err == nil {
+		t.Error("expected error without receipt")
+	}
+	if err := m.Refund(context.Background(), Result{Reference: "ws_CO_1", Receipt: "NLJ7RT61SV"}, 100); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
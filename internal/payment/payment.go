@@ -0,0 +1,57 @@
+// Package payment defines the gateway abstraction used by checkout and its providers.
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusRefunded  Status = "refunded"
+)
+
+// Final reports whether no further provider updates are expected for the status
+func (s Status) Final() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusRefunded
+}
+
+var (
+	ErrInvalidCallback = errors.New("payment callback failed verification")
+	ErrUnknownPayment  = errors.New("unknown payment reference")
+)
+
+// Request asks a provider to collect Amount (in KSH) for an order
+type Request struct {
+	OrderId     int
+	Amount      float64
+	Phone       string
+	Description string
+}
+
+// Result is a provider's view of a payment. Reference is the provider's id for the
+// request, Receipt the id of the settled transaction when the provider issues one.
+type Result struct {
+	Reference string
+	Receipt   string
+	Status    Status
+	Message   string
+}
+
+// Provider is a payment gateway. Initiate may settle synchronously (a final Status)
+// or leave the payment pending until ParseCallback or Status reports the outcome.
+type Provider interface {
+	Name() string
+	Initiate(ctx context.Context, req Request) (Result, error)
+	// ParseCallback verifies an asynchronous notification and returns the result it
+	// carries, or ErrInvalidCallback.
+	ParseCallback(r *http.Request) (Result, error)
+	Status(ctx context.Context, reference string) (Result, error)
+	// Refund returns amount of a succeeded payment to the payer
+	Refund(ctx context.Context, paid Result, amount float64) error
+}
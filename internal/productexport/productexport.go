@@ -0,0 +1,223 @@
+// Package productexport writes laptops one at a time as CSV, Excel friendly CSV or JSON lines,
+// with the columns the caller picks. Column names match productimport, so an export can be
+// edited and imported back.
+package productexport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lapbytes/internal/model"
+	"strconv"
+	"strings"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	CSV       Format = "csv"
+	Excel     Format = "excel" //CSV with a BOM, CRLF line endings and formulas defused, opens cleanly in Excel
+	JSONLines Format = "jsonl"
+)
+
+// ParseFormat reads a format name, CSV when empty
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "csv":
+		return CSV, nil
+	case "excel":
+		return Excel, nil
+	case "jsonl", "ndjson":
+		return JSONLines, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, use csv, excel or jsonl", name)
+}
+
+// ContentType is the media type to serve an export with
+func (f Format) ContentType() string {
+	if f == JSONLines {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Extension is the file name extension of an export, with the dot
+func (f Format) Extension() string {
+	if f == JSONLines {
+		return ".jsonl"
+	}
+	return ".csv"
+}
+
+// Column is one exported field of a laptop
+type Column struct {
+	Name  string
+	value func(lp model.Laptop) any //string, float64, int, bool or nil
+}
+
+func nullable(v string, valid bool) any {
+	if !valid {
+		return nil
+	}
+	return v
+}
+
+// columns in their default order
+var columns = []Column{
+	{"id", func(lp model.Laptop) any { return lp.Id }},
+	{"sku", func(lp model.Laptop) any { return lp.Sku }},
+	{"name", func(lp model.Laptop) any { return lp.Name }},
+	{"brand", func(lp model.Laptop) any { return lp.Brand }},
+	{"operating_system", func(lp model.Laptop) any { return lp.Operating_system }},
+	{"operating_system_version", func(lp model.Laptop) any { return lp.Operating_system_version }},
+	{"hdd", func(lp model.Laptop) any { return lp.HDD }},
+	{"ssd", func(lp model.Laptop) any { return lp.SSD }},
+	{"hdd_size", func(lp model.Laptop) any { return lp.HDD_size }},
+	{"ssd_size", func(lp model.Laptop) any { return lp.SSD_size }},
+	{"ram_size", func(lp model.Laptop) any { return lp.Ram_size }},
+	{"cpu_maker", func(lp model.Laptop) any { return lp.CPU_maker }},
+	{"cpu_generation", func(lp model.Laptop) any { return lp.CPU_gen }},
+	{"cpu_model", func(lp model.Laptop) any { return lp.CPU_model }},
+	{"year_of_manufacture", func(lp model.Laptop) any { return lp.YOM }},
+	{"image_url", func(lp model.Laptop) any { return lp.Image_url }},
+	{"price", func(lp model.Laptop) any { return lp.Price }},
+	{"screen_size", func(lp model.Laptop) any { return lp.Screen_size }},
+	{"has_gpu", func(lp model.Laptop) any { return lp.Has_gpu }},
+	{"gpu_model", func(lp model.Laptop) any { return nullable(lp.Gpu_make.String, lp.Gpu_make.Valid) }},
+	{"gpu_manufacturer", func(lp model.Laptop) any { return nullable(lp.Gpu_maker.String, lp.Gpu_maker.Valid) }},
+	{"has_integrated_gpu", func(lp model.Laptop) any { return lp.Has_igpu }},
+	{"is_in_stock", func(lp model.Laptop) any { return lp.Is_in_stock }},
+	{"in_stock", func(lp model.Laptop) any { return lp.In_stock }},
+	{"version", func(lp model.Laptop) any { return lp.Version }},
+}
+
+// Columns picks columns from a comma separated list of names, in the order given. An empty list is every column.
+func Columns(names string) ([]Column, error) {
+	if strings.TrimSpace(names) == "" {
+		return columns, nil
+	}
+	byName := make(map[string]Column, len(columns))
+	for _, c := range columns {
+		byName[c.Name] = c
+	}
+	var picked []Column
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is listed twice", name)
+		}
+		seen[name] = true
+		picked = append(picked, c)
+	}
+	return picked, nil
+}
+
+// Writer writes laptops in one format. Output is buffered, call Flush when done.
+type Writer struct {
+	format  Format
+	columns []Column
+	csv     *csv.Writer
+	buf     *bufio.Writer //JSON lines only, csv.Writer buffers itself
+	record  []string
+}
+
+func NewWriter(w io.Writer, format Format, columns []Column) *Writer {
+	out := &Writer{format: format, columns: columns}
+	if format == JSONLines {
+		out.buf = bufio.NewWriter(w)
+		return out
+	}
+	out.csv = csv.NewWriter(w)
+	out.csv.UseCRLF = format == Excel
+	out.record = make([]string, len(columns))
+	return out
+}
+
+// WriteHeader starts the file: the header row of a CSV, nothing for JSON lines
+func (w *Writer) WriteHeader() error {
+	if w.csv == nil {
+		return nil
+	}
+	for i, c := range w.columns {
+		w.record[i] = c.Name
+	}
+	if w.format == Excel {
+		w.record[0] = "\ufeff" + w.record[0]
+	}
+	return w.csv.Write(w.record)
+}
+
+// Write adds one laptop
+func (w *Writer) Write(lp model.Laptop) error {
+	if w.csv == nil {
+		return w.writeJSON(lp)
+	}
+	for i, c := range w.columns {
+		w.record[i] = w.cell(c.value(lp))
+	}
+	return w.csv.Write(w.record)
+}
+
+// Flush writes out anything buffered
+func (w *Writer) Flush() error {
+	if w.csv == nil {
+		return w.buf.Flush()
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// cell formats a CSV value, the way productimport reads it back
+func (w *Writer) cell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if w.format == Excel {
+			return defuse(v)
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// defuse keeps spreadsheets from running text that looks like a formula
+func defuse(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// writeJSON writes one object with the keys in column order
+func (w *Writer) writeJSON(lp model.Laptop) error {
+	w.buf.WriteByte('{')
+	for i, c := range w.columns {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		value, err := json.Marshal(c.value(lp))
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString(strconv.Quote(c.Name))
+		w.buf.WriteByte(':')
+		w.buf.Write(value)
+	}
+	_, err := w.buf.WriteString("}\n") //bufio errors stick, so this reports any failed write above
+	return err
+}
@@ -0,0 +1,121 @@
+package productexport
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"lapbytes/internal/model"
+	"lapbytes/internal/productimport"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testLaptop() model.Laptop {
+	return model.Laptop{
+		Id: 7, Sku: "LEN-X1", Name: "ThinkPad X1, Gen 11", Brand: "Lenovo",
+		Operating_system: "Windows", Operating_system_version: "11",
+		SSD: true, SSD_size: 512, Ram_size: 16,
+		CPU_maker: "Intel", CPU_gen: "13th", CPU_model: "i7-1365U", YOM: "2023",
+		Image_url: "/static/x1.png", Price: 189999.5, Screen_size: 14,
+		Gpu_maker:   sql.NullString{String: "Intel", Valid: true},
+		Is_in_stock: true, In_stock: 4, Version: 3,
+	}
+}
+
+func TestColumns(t *testing.T) {
+	all, err := Columns("")
+	if err != nil || len(all) != len(columns) {
+		t.Fatalf("expected every column, got %d, %v", len(all), err)
+	}
+	picked, err := Columns(" price, SKU ")
+	if err != nil || len(picked) != 2 || picked[0].Name != "price" || picked[1].Name != "sku" {
+		t.Fatalf("expected price then sku, got %+v, %v", picked, err)
+	}
+	for _, names := range []string{"sku,colour", "sku,sku", "sku,"} {
+		if _, err := Columns(names); err == nil {
+			t.Errorf("expected an error for %q", names)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	cols, _ := Columns("sku,name,price,gpu_model,gpu_manufacturer,in_stock,is_in_stock")
+	var b bytes.Buffer
+	w := NewWriter(&b, CSV, cols)
+	w.WriteHeader()
+	w.Write(testLaptop())
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "sku,name,price,gpu_model,gpu_manufacturer,in_stock,is_in_stock\n" +
+		`LEN-X1,"ThinkPad X1, Gen 11",189999.5,,Intel,4,true` + "\n"
+	if b.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
+
+func TestWriteExcel(t *testing.T) {
+	cols, _ := Columns("sku,name")
+	lp := testLaptop()
+	lp.Name = "=HYPERLINK(\"http://evil\")"
+	var b bytes.Buffer
+	w := NewWriter(&b, Excel, cols)
+	w.WriteHeader()
+	w.Write(lp)
+	w.Flush()
+
+	expected := "\ufeffsku,name\r\nLEN-X1,\"'=HYPERLINK(\"\"http://evil\"\")\"\r\n"
+	if b.String() != expected {
+		t.Errorf("expected %q but got %q", expected, b.String())
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	cols, _ := Columns("sku,price,gpu_model,has_gpu")
+	var b bytes.Buffer
+	w := NewWriter(&b, JSONLines, cols)
+	w.WriteHeader()
+	w.Write(testLaptop())
+	w.Write(testLaptop())
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but got %q", b.String())
+	}
+	expected := `{"sku":"LEN-X1","price":189999.5,"gpu_model":null,"has_gpu":false}`
+	if lines[0] != expected {
+		t.Errorf("expected %s but got %s", expected, lines[0])
+	}
+	var object map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &object); err != nil {
+		t.Errorf("expected valid JSON: %v", err)
+	}
+}
+
+func TestExportImportsBack(t *testing.T) {
+	// every column but id and version, which imports don't take
+	cols, _ := Columns("sku,name,brand,operating_system,operating_system_version,hdd,ssd,hdd_size,ssd_size,ram_size," +
+		"cpu_maker,cpu_generation,cpu_model,year_of_manufacture,image_url,price,screen_size," +
+		"has_gpu,gpu_model,gpu_manufacturer,has_integrated_gpu,is_in_stock,in_stock")
+	formats := map[Format]productimport.Format{CSV: productimport.CSV, Excel: productimport.CSV, JSONLines: productimport.JSONLines}
+	for format, importFormat := range formats {
+		var b bytes.Buffer
+		w := NewWriter(&b, format, cols)
+		w.WriteHeader()
+		w.Write(testLaptop())
+		w.Flush()
+
+		rows, rowErrs, err := productimport.Parse(&b, importFormat)
+		if err != nil || len(rowErrs) > 0 || len(rows) != 1 {
+			t.Fatalf("%s: expected the export to import, got %v %+v", format, err, rowErrs)
+		}
+		got, want := rows[0].Laptop, testLaptop()
+		got.Id, got.Version, got.In_stock = want.Id, want.Version, *rows[0].Stock
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v but got %+v", format, want, got)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+// Package productimport reads and validates laptops for bulk imports from CSV or JSON lines
+// files. Columns and keys use the JSON field names of model.Laptop, plus "in_stock" for the
+// number of units. Rows are matched to existing laptops by SKU.
+package productimport
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lapbytes/internal/model"
+	"lapbytes/internal/store/queries"
+	"math"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Format is the file format of an import
+type Format string
+
+const (
+	CSV       Format = "csv"
+	JSONLines Format = "jsonl"
+)
+
+const (
+	MaxRows      = 10_000
+	MaxSkuLength = 64
+	maxText      = 255 //the products table uses VARCHAR(255)
+	maxPrice     = 99_999_999.99
+	MaxStock     = 1_000_000
+	maxLineBytes = 64 << 10
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidSku reports whether s can be used as a SKU
+func ValidSku(s string) bool {
+	return len(s) <= MaxSkuLength && skuPattern.MatchString(s)
+}
+
+// FormatFor picks the format from a format name, a Content-Type or a file name, in that order
+func FormatFor(name, contentType, fileName string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return CSV, nil
+	case "jsonl", "ndjson", "json":
+		return JSONLines, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q, use csv or jsonl", name)
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "text/csv":
+			return CSV, nil
+		case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+			return JSONLines, nil
+		}
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return CSV, nil
+	case ".jsonl", ".ndjson", ".json":
+		return JSONLines, nil
+	}
+	return "", errors.New("unknown format, use csv or jsonl")
+}
+
+// Row is a valid laptop read from the file
+type Row struct {
+	Line   int //line in the file, for CSV the header is line 1
+	Laptop model.Laptop
+	Stock  *int //units in stock, nil keeps the current stock of an existing laptop
+}
+
+// RowError lists what is wrong with one row
+type RowError struct {
+	Line   int      `json:"line"`
+	Sku    string   `json:"sku,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type field struct {
+	required bool
+	set      func(row *Row, value string) error
+}
+
+func text(dst func(*Row) *string) func(*Row, string) error {
+	return func(row *Row, v string) error {
+		if len(v) > maxText {
+			return fmt.Errorf("must be at most %d characters", maxText)
+		}
+		*dst(row) = v
+		return nil
+	}
+}
+
+func optionalText(dst func(*Row) *sql.NullString) func(*Row, string) error {
+	return func(row *Row, v string) error {
+		if len(v) > maxText {
+			return fmt.Errorf("must be at most %d characters", maxText)
+		}
+		*dst(row) = sql.NullString{String: v, Valid: true}
+		return nil
+	}
+}
+
+func number(dst func(*Row) *float64, positive bool, max float64) func(*Row, string) error {
+	return func(row *Row, v string) error {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 || (positive && n == 0) || n > max {
+			if positive {
+				return fmt.Errorf("must be a number above 0 and at most %g", max)
+			}
+			return fmt.Errorf("must be a number from 0 to %g", max)
+		}
+		*dst(row) = n
+		return nil
+	}
+}
+
+func flag(dst func(*Row) *bool) func(*Row, string) error {
+	return func(row *Row, v string) error {
+		switch strings.ToLower(v) {
+		case "true", "t", "1", "yes", "y":
+			*dst(row) = true
+		case "false", "f", "0", "no", "n":
+			*dst(row) = false
+		default:
+			return errors.New("must be true or false")
+		}
+		return nil
+	}
+}
+
+var fields = map[string]field{
+	"sku": {true, func(row *Row, v string) error {
+		if !ValidSku(v) {
+			return fmt.Errorf("must be 1 to %d letters, digits, '-', '_' or '.'", MaxSkuLength)
+		}
+		row.Laptop.Sku = v
+		return nil
+	}},
+	"name":                     {true, text(func(r *Row) *string { return &r.Laptop.Name })},
+	"brand":                    {true, text(func(r *Row) *string { return &r.Laptop.Brand })},
+	"operating_system":         {true, text(func(r *Row) *string { return &r.Laptop.Operating_system })},
+	"operating_system_version": {true, text(func(r *Row) *string { return &r.Laptop.Operating_system_version })},
+	"hdd":                      {false, flag(func(r *Row) *bool { return &r.Laptop.HDD })},
+	"ssd":                      {false, flag(func(r *Row) *bool { return &r.Laptop.SSD })},
+	"hdd_size":                 {false, number(func(r *Row) *float64 { return &r.Laptop.HDD_size }, false, 1e6)},
+	"ssd_size":                 {false, number(func(r *Row) *float64 { return &r.Laptop.SSD_size }, false, 1e6)},
+	"ram_size":                 {true, number(func(r *Row) *float64 { return &r.Laptop.Ram_size }, true, 1e4)},
+	"cpu_maker":                {true, text(func(r *Row) *string { return &r.Laptop.CPU_maker })},
+	"cpu_generation":           {true, text(func(r *Row) *string { return &r.Laptop.CPU_gen })},
+	"cpu_model":                {true, text(func(r *Row) *string { return &r.Laptop.CPU_model })},
+	"year_of_manufacture":      {true, text(func(r *Row) *string { return &r.Laptop.YOM })},
+	"image_url":                {true, text(func(r *Row) *string { return &r.Laptop.Image_url })},
+	"price":                    {true, number(func(r *Row) *float64 { return &r.Laptop.Price }, true, maxPrice)},
+	"screen_size":              {true, number(func(r *Row) *float64 { return &r.Laptop.Screen_size }, true, 100)},
+	"has_gpu":                  {false, flag(func(r *Row) *bool { return &r.Laptop.Has_gpu })},
+	"gpu_model":                {false, optionalText(func(r *Row) *sql.NullString { return &r.Laptop.Gpu_make })},
+	"gpu_manufacturer":         {false, optionalText(func(r *Row) *sql.NullString { return &r.Laptop.Gpu_maker })},
+	"has_integrated_gpu":       {false, flag(func(r *Row) *bool { return &r.Laptop.Has_igpu })},
+	"is_in_stock":              {false, flag(func(r *Row) *bool { return &r.Laptop.Is_in_stock })},
+	"in_stock": {false, func(row *Row, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > MaxStock {
+			return fmt.Errorf("must be a whole number from 0 to %d", MaxStock)
+		}
+		row.Stock = &n
+		return nil
+	}},
+}
+
+// requiredFields is the sorted list of fields every row needs
+func requiredFields() []string {
+	var names []string
+	for name, f := range fields {
+		if f.required {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildRow validates the values of one row, an empty value counts as missing
+func buildRow(line int, values map[string]string) (Row, []string) {
+	row := Row{Line: line}
+	var problems []string
+	for _, name := range requiredFields() {
+		if values[name] == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := fields[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown field %q", name))
+			continue
+		}
+		if values[name] == "" {
+			continue
+		}
+		if err := f.set(&row, values[name]); err != nil {
+			problems = append(problems, name+" "+err.Error())
+		}
+	}
+	// a stock count decides availability unless the row says otherwise
+	if row.Stock != nil && values["is_in_stock"] == "" {
+		row.Laptop.Is_in_stock = *row.Stock > 0
+	}
+	return row, problems
+}
+
+// Parse reads and validates every row of a file. Rows with problems are left out of rows and
+// reported in rowErrs; err is only set when the file as a whole can't be read.
+func Parse(r io.Reader, format Format) (rows []Row, rowErrs []RowError, err error) {
+	seen := map[string]int{} //sku to the line it was first seen on
+	count := 0
+	yield := func(line int, values map[string]string, problem error) bool {
+		if count++; count > MaxRows {
+			return false
+		}
+		if problem != nil {
+			rowErrs = append(rowErrs, RowError{Line: line, Errors: []string{problem.Error()}})
+			return true
+		}
+		row, problems := buildRow(line, values)
+		if sku := row.Laptop.Sku; sku != "" {
+			if first, ok := seen[sku]; ok {
+				problems = append(problems, fmt.Sprintf("sku is repeated from line %d", first))
+			} else {
+				seen[sku] = line
+			}
+		}
+		if len(problems) > 0 {
+			rowErrs = append(rowErrs, RowError{Line: line, Sku: values["sku"], Errors: problems})
+			return true
+		}
+		rows = append(rows, row)
+		return true
+	}
+
+	switch format {
+	case CSV:
+		err = readCSV(r, yield)
+	case JSONLines:
+		err = readJSONLines(r, yield)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err == nil && count > MaxRows {
+		err = fmt.Errorf("too many rows, at most %d per import", MaxRows)
+	}
+	if err == nil && count == 0 {
+		err = errors.New("the file has no rows")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return rows, rowErrs, nil
+}
+
+func readCSV(r io.Reader, yield func(int, map[string]string, error) bool) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return errors.New("the file is empty")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV: %w", err)
+	}
+	columns := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("unknown column %q", name)
+		}
+		if columns[name] {
+			return fmt.Errorf("column %q appears twice", name)
+		}
+		columns[name] = true
+		header[i] = name
+	}
+	var missing []string
+	for _, name := range requiredFields() {
+		if !columns[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			if !yield(line, nil, fmt.Errorf("has %d columns, the header has %d", len(record), len(header))) {
+				return nil
+			}
+			continue
+		}
+		values := make(map[string]string, len(header))
+		for i, name := range header {
+			values[name] = strings.TrimSpace(record[i])
+		}
+		if !yield(line, values, nil) {
+			return nil
+		}
+	}
+}
+
+func readJSONLines(r io.Reader, yield func(int, map[string]string, error) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if line == 1 {
+			raw = bytes.TrimPrefix(raw, []byte("\ufeff"))
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		values, err := jsonValues(raw)
+		if !yield(line, values, err) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d is longer than %d bytes", line+1, maxLineBytes)
+		}
+		return err
+	}
+	return nil
+}
+
+// jsonValues flattens one JSON object to strings so CSV and JSON rows validate the same way
+func jsonValues(raw []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil || decoder.More() {
+		return nil, errors.New("is not a JSON object")
+	}
+	values := make(map[string]string, len(object))
+	for name, value := range object {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = strings.TrimSpace(v)
+		case json.Number:
+			values[name] = v.String()
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s must be a string, number or boolean", name)
+		}
+	}
+	return values, nil
+}
+
+// BatchSize is how many rows an import sends to the database at a time
+const BatchSize = 500
+
+// PlannedRow is what an import does, or in a dry run would do, with one row
+type PlannedRow struct {
+	Line   int    `json:"line"`
+	Sku    string `json:"sku"`
+	Action string `json:"action"` //create, update or unchanged
+	Id     int    `json:"id,omitempty"`
+}
+
+// Plan reports what an import would do with each row, without writing. Rows the database would
+// reject because another laptop has the same name, price and operating system are returned as
+// conflicts, with the other rows planned as if they weren't there.
+func Plan(pool *pgxpool.Pool, rows []Row) (planned []PlannedRow, conflicts []RowError, err error) {
+	skus := make([]string, len(rows))
+	names := make([]string, len(rows))
+	for i, row := range rows {
+		skus[i], names[i] = row.Laptop.Sku, row.Laptop.Name
+	}
+	stored, err := queries.LaptopsForImport(pool, skus, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	planned, conflicts = plan(rows, stored)
+	return planned, conflicts, nil
+}
+
+// uniqueProduct is what idx_products_name_price_os allows one live laptop to have
+type uniqueProduct struct {
+	name  string
+	cents int64
+	os    string
+}
+
+func uniqueKey(lp model.Laptop) uniqueProduct {
+	return uniqueProduct{lp.Name, cents(lp.Price), lp.Operating_system}
+}
+
+// cents is a price as the DECIMAL(10,2) column stores it
+func cents(price float64) int64 {
+	return int64(math.Round(price * 100))
+}
+
+// plan goes through rows in order as ImportLaptops would, starting from the stored laptops
+func plan(rows []Row, stored []model.Laptop) (planned []PlannedRow, conflicts []RowError) {
+	bySku := map[string]model.Laptop{}
+	owners := map[uniqueProduct]int{} //to the id of the laptop holding it, rows to create get -line
+	for _, lp := range stored {
+		if lp.Sku != "" {
+			bySku[lp.Sku] = lp
+		}
+		owners[uniqueKey(lp)] = lp.Id
+	}
+
+	for _, row := range rows {
+		next := row.Laptop
+		current, exists := bySku[next.Sku]
+		id := -row.Line
+		if exists {
+			id = current.Id
+			next.In_stock = current.In_stock
+		}
+		if row.Stock != nil {
+			next.In_stock = *row.Stock
+		}
+		if owner, taken := owners[uniqueKey(next)]; taken && owner != id {
+			conflicts = append(conflicts, RowError{Line: row.Line, Sku: next.Sku, Errors: []string{queries.ErrDuplicateProduct.Error()}})
+			continue
+		}
+		if exists && owners[uniqueKey(current)] == id {
+			delete(owners, uniqueKey(current))
+		}
+		owners[uniqueKey(next)] = id
+
+		p := PlannedRow{Line: row.Line, Sku: next.Sku, Action: queries.ImportCreated}
+		if exists {
+			p.Id, p.Action = current.Id, queries.ImportUpdated
+			if sameLaptop(current, next) {
+				p.Action = queries.ImportUnchanged
+			}
+		}
+		next.Id = id
+		bySku[next.Sku] = next
+		planned = append(planned, p)
+	}
+	return planned, conflicts
+}
+
+// sameLaptop compares the columns an import writes, so it agrees with the upsert on which rows
+// are left unchanged
+func sameLaptop(a, b model.Laptop) bool {
+	return a.Name == b.Name && a.Brand == b.Brand &&
+		a.Operating_system == b.Operating_system && a.Operating_system_version == b.Operating_system_version &&
+		a.HDD == b.HDD && a.SSD == b.SSD && a.HDD_size == b.HDD_size && a.SSD_size == b.SSD_size &&
+		a.Ram_size == b.Ram_size && a.CPU_maker == b.CPU_maker && a.CPU_gen == b.CPU_gen &&
+		a.CPU_model == b.CPU_model && a.YOM == b.YOM && a.Image_url == b.Image_url &&
+		cents(a.Price) == cents(b.Price) && a.Screen_size == b.Screen_size &&
+		a.Has_gpu == b.Has_gpu && a.Gpu_make == b.Gpu_make && a.Gpu_maker == b.Gpu_maker &&
+		a.Has_igpu == b.Has_igpu && a.Is_in_stock == b.Is_in_stock && a.In_stock == b.In_stock
+}
+
+// Summary counts what an import did
+type Summary struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Import writes every row in one transaction, BatchSize rows at a time, calling progress with the
+// number of rows done. If the database rejects a row nothing is saved and rowErr says which.
+func Import(pool *pgxpool.Pool, rows []Row, progress func(done int)) (summary Summary, rowErr *RowError, err error) {
+	imports := make([]queries.LaptopImport, len(rows))
+	for i, row := range rows {
+		imports[i] = queries.LaptopImport{Laptop: row.Laptop, Stock: row.Stock}
+	}
+	outcomes, err := queries.ImportLaptops(pool, imports, BatchSize, progress)
+	var failed *queries.ImportRowError
+	if errors.As(err, &failed) {
+		row := rows[failed.Index]
+		msg := "could not be saved"
+		if errors.Is(err, queries.ErrDuplicateProduct) || errors.Is(err, queries.ErrDuplicateSku) {
+			msg = failed.Err.Error()
+		}
+		return Summary{}, &RowError{Line: row.Line, Sku: row.Laptop.Sku, Errors: []string{msg}}, err
+	}
+	if err != nil {
+		return Summary{}, nil, err
+	}
+	for _, outcome := range outcomes {
+		switch outcome.Action {
+		case queries.ImportCreated:
+			summary.Created++
+		case queries.ImportUpdated:
+			summary.Updated++
+		default:
+			summary.Unchanged++
+		}
+	}
+	return summary, nil, nil
+}
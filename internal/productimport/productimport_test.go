@@ -0,0 +1,168 @@
+package productimport
+
+import (
+	"fmt"
+	"lapbytes/internal/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const csvHeader = "sku,name,brand,operating_system,operating_system_version,ram_size,cpu_maker,cpu_generation,cpu_model,year_of_manufacture,image_url,price,screen_size,in_stock\n"
+
+func csvRow(sku, price, stock string) string {
+	return sku + ",ThinkPad X1,Lenovo,Windows,11,16,Intel,13th,i7-1365U,2023,/static/x1.png," + price + ",14," + stock + "\n"
+}
+
+func TestParseCSV(t *testing.T) {
+	file := csvHeader + csvRow("LEN-X1-01", "1899.99", "4") + csvRow("LEN-X1-02", "abc", "") + csvRow("LEN-X1-01", "1899.99", "0")
+
+	rows, rowErrs, err := Parse(strings.NewReader(file), CSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 valid row but got %d", len(rows))
+	}
+	row := rows[0]
+	if row.Line != 2 || row.Laptop.Sku != "LEN-X1-01" || row.Laptop.Price != 1899.99 {
+		t.Errorf("unexpected row %+v", row)
+	}
+	if row.Stock == nil || *row.Stock != 4 || !row.Laptop.Is_in_stock {
+		t.Errorf("expected 4 units in stock, got %v %v", row.Stock, row.Laptop.Is_in_stock)
+	}
+
+	if len(rowErrs) != 2 {
+		t.Fatalf("expected 2 row errors but got %+v", rowErrs)
+	}
+	if rowErrs[0].Line != 3 || !strings.HasPrefix(rowErrs[0].Errors[0], "price") {
+		t.Errorf("expected a price error on line 3, got %+v", rowErrs[0])
+	}
+	if rowErrs[1].Line != 4 || !strings.Contains(rowErrs[1].Errors[0], "repeated from line 2") {
+		t.Errorf("expected a repeated sku on line 4, got %+v", rowErrs[1])
+	}
+}
+
+func TestParseJSONLines(t *testing.T) {
+	file := `{"sku":"DEL-XPS-13","name":"XPS 13","brand":"Dell","operating_system":"Windows","operating_system_version":"11","ram_size":16,"cpu_maker":"Intel","cpu_generation":"12th","cpu_model":"i7","year_of_manufacture":"2022","image_url":"/static/xps.png","price":1500,"screen_size":13.4,"has_gpu":false}
+
+{"sku":"DEL-XPS-15","name":"XPS 15"}
+not json
+`
+	rows, rowErrs, err := Parse(strings.NewReader(file), JSONLines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Laptop.Screen_size != 13.4 || rows[0].Stock != nil {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if len(rowErrs) != 2 {
+		t.Fatalf("expected 2 row errors but got %+v", rowErrs)
+	}
+	if rowErrs[0].Line != 3 || rowErrs[0].Sku != "DEL-XPS-15" || len(rowErrs[0].Errors) != 11 {
+		t.Errorf("expected the 11 missing fields on line 3, got %+v", rowErrs[0])
+	}
+	if rowErrs[1].Line != 4 {
+		t.Errorf("expected line 4 to not be JSON, got %+v", rowErrs[1])
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		file   string
+	}{
+		{"empty csv", CSV, ""},
+		{"header only", CSV, csvHeader},
+		{"unknown column", CSV, strings.TrimSuffix(csvHeader, "\n") + ",colour\n"},
+		{"missing column", CSV, "sku,name\nA,B\n"},
+		{"repeated column", CSV, "sku,sku\n"},
+		{"empty jsonl", JSONLines, "\n\n"},
+		{"line too long", JSONLines, `{"name":"` + strings.Repeat("x", maxLineBytes) + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(strings.NewReader(tt.file), tt.format); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseTooManyRows(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(csvHeader)
+	for i := 0; i <= MaxRows; i++ {
+		b.WriteString(csvRow(fmt.Sprintf("SKU-%d", i), "10", "1"))
+	}
+	if _, _, err := Parse(strings.NewReader(b.String()), CSV); err == nil {
+		t.Error("expected an error for more than MaxRows rows")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	file := csvHeader + csvRow("LEN-A", "1899.99", "4") + csvRow("LEN-B", "999", "") + csvRow("LEN-C", "500", "1") +
+		csvRow("LEN-D", "700", "1") + csvRow("LEN-E", "700", "1") + csvRow("LEN-F", "1000", "1")
+	rows, rowErrs, err := Parse(strings.NewReader(file), CSV)
+	if err != nil || len(rowErrs) > 0 {
+		t.Fatalf("unexpected errors: %v %+v", err, rowErrs)
+	}
+	same := rows[0].Laptop
+	same.Id, same.In_stock = 1, 4
+	older := rows[1].Laptop
+	older.Id, older.Price, older.In_stock = 2, 1000, 3
+	noSku := rows[2].Laptop
+	noSku.Id, noSku.Sku = 3, ""
+
+	planned, conflicts := plan(rows, []model.Laptop{same, older, noSku})
+
+	expected := []PlannedRow{
+		{Line: 2, Sku: "LEN-A", Action: "unchanged", Id: 1},
+		{Line: 3, Sku: "LEN-B", Action: "update", Id: 2},
+		{Line: 5, Sku: "LEN-D", Action: "create"},
+		{Line: 7, Sku: "LEN-F", Action: "create"}, //LEN-B left that price on line 3
+	}
+	if !reflect.DeepEqual(planned, expected) {
+		t.Errorf("expected %+v but got %+v", expected, planned)
+	}
+	if len(conflicts) != 2 || conflicts[0].Line != 4 || conflicts[1].Line != 6 {
+		t.Errorf("expected conflicts on lines 4 and 6 but got %+v", conflicts)
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	tests := []struct {
+		name, contentType, fileName string
+		expected                    Format
+		ok                          bool
+	}{
+		{"csv", "", "", CSV, true},
+		{"JSONL", "text/csv", "", JSONLines, true},
+		{"", "text/csv; charset=utf-8", "", CSV, true},
+		{"", "application/x-ndjson", "", JSONLines, true},
+		{"", "", "laptops.csv", CSV, true},
+		{"", "application/octet-stream", "laptops.jsonl", JSONLines, true},
+		{"xml", "", "", "", false},
+		{"", "", "laptops.txt", "", false},
+	}
+	for _, tt := range tests {
+		got, err := FormatFor(tt.name, tt.contentType, tt.fileName)
+		if (err == nil) != tt.ok || got != tt.expected {
+			t.Errorf("FormatFor(%q, %q, %q): expected %q but got %q, %v", tt.name, tt.contentType, tt.fileName, tt.expected, got, err)
+		}
+	}
+}
+
+func TestValidSku(t *testing.T) {
+	for _, sku := range []string{"A", "LEN-X1_2023.v2", strings.Repeat("a", MaxSkuLength)} {
+		if !ValidSku(sku) {
+			t.Errorf("expected %q to be valid", sku)
+		}
+	}
+	for _, sku := range []string{"", "-A", "has space", "a/b", strings.Repeat("a", MaxSkuLength+1)} {
+		if ValidSku(sku) {
+			t.Errorf("expected %q to be invalid", sku)
+		}
+	}
+}
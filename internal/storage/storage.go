@@ -0,0 +1,35 @@
+// Package storage keeps uploaded files such as product images, on the local filesystem or in an
+// S3 compatible bucket. Keys are slash separated paths like "products/12/abc.jpg".
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Store saves, reads and deletes objects by key
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error) //ErrNotFound if missing
+	Delete(ctx context.Context, key string) error               //deleting a missing key is not an error
+}
+
+// ValidKey reports whether key is a relative slash separated path without empty, "." or ".." parts
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+-- Weighted full-text document over the searchable laptop fields.
+-- Generated so every INSERT/UPDATE through the store layer keeps it in sync.
+ALTER TABLE products ADD COLUMN searchvector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(brand, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(cpumodel, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(gpumake, '')), 'C') ||
+    setweight(to_tsvector('simple', coalesce(operatingsystem, '')), 'C') ||
+    setweight(to_tsvector('simple', ramsize::int::text || 'gb'), 'D')
+) STORED;
+
+CREATE INDEX idx_products_searchvector ON products USING GIN (searchvector);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cartitems;
+DROP TABLE IF EXISTS carts;
@@ -0,0 +1,16 @@
+CREATE TABLE carts (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW(),
+    updatedat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE cartitems (
+    id SERIAL PRIMARY KEY,
+    cartid INTEGER NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+    productid INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    createdat TIMESTAMP NOT NULL DEFAULT NOW(),
+    updatedat TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (cartid, productid)
+);
@@ -0,0 +1,4 @@
+DELETE FROM carts WHERE guestid IS NOT NULL;
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_owner_check;
+ALTER TABLE carts DROP COLUMN IF EXISTS guestid;
+ALTER TABLE carts ALTER COLUMN userid SET NOT NULL;
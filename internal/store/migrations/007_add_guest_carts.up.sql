@@ -0,0 +1,4 @@
+-- A cart belongs either to a registered user or to an anonymous guest cookie
+ALTER TABLE carts ALTER COLUMN userid DROP NOT NULL;
+ALTER TABLE carts ADD COLUMN guestid UUID UNIQUE;
+ALTER TABLE carts ADD CONSTRAINT carts_owner_check CHECK ((userid IS NULL) <> (guestid IS NULL));
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_instock_nonnegative;
+DROP TABLE IF EXISTS orderitems;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,29 @@
+CREATE TABLE orders (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id),
+    status VARCHAR(32) NOT NULL DEFAULT 'pending_payment',
+    total DECIMAL(12,2) NOT NULL,
+    shippingname VARCHAR(255) NOT NULL,
+    shippingphone VARCHAR(32) NOT NULL,
+    shippingaddress VARCHAR(500) NOT NULL,
+    shippingcity VARCHAR(255) NOT NULL,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW(),
+    updatedat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_orders_userid ON orders(userid);
+
+-- Order lines keep a snapshot of the product so later edits never change past orders
+CREATE TABLE orderitems (
+    id SERIAL PRIMARY KEY,
+    orderid INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    productid INTEGER REFERENCES products(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    brand VARCHAR(255) NOT NULL,
+    unitprice DECIMAL(10,2) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    specs JSONB NOT NULL
+);
+CREATE INDEX idx_orderitems_orderid ON orderitems(orderid);
+
+-- Last line of defence against overselling
+ALTER TABLE products ADD CONSTRAINT products_instock_nonnegative CHECK (instock >= 0);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orderhistory;
+DROP INDEX IF EXISTS idx_orders_status;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
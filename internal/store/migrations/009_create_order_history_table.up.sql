@@ -0,0 +1,19 @@
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending_payment', 'paid', 'packed', 'shipped', 'delivered', 'cancelled', 'refunded'
+));
+CREATE INDEX idx_orders_status ON orders(status);
+
+CREATE TABLE orderhistory (
+    id SERIAL PRIMARY KEY,
+    orderid INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    fromstatus VARCHAR(32),
+    tostatus VARCHAR(32) NOT NULL,
+    actortype VARCHAR(16) NOT NULL,
+    actorid INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    note TEXT NOT NULL DEFAULT '',
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_orderhistory_orderid ON orderhistory(orderid);
+
+INSERT INTO orderhistory (orderid, fromstatus, tostatus, actortype, actorid, createdat)
+SELECT id, NULL, status, 'user', userid, createdat FROM orders;
@@ -0,0 +1,16 @@
+CREATE TABLE payments (
+    id SERIAL PRIMARY KEY,
+    orderid INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    provider VARCHAR(32) NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    receipt VARCHAR(255) NOT NULL DEFAULT '',
+    amount DECIMAL(12,2) NOT NULL,
+    phone VARCHAR(32) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed', 'refunded')),
+    message TEXT NOT NULL DEFAULT '',
+    createdat TIMESTAMP NOT NULL DEFAULT NOW(),
+    updatedat TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, reference)
+);
+CREATE INDEX idx_payments_orderid ON payments(orderid);
@@ -0,0 +1,15 @@
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    familyid UUID NOT NULL,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    useragent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    expiresat TIMESTAMP NOT NULL,
+    usedat TIMESTAMP,
+    revokedat TIMESTAMP,
+    replacedby INTEGER REFERENCES sessions(id) ON DELETE SET NULL,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_sessions_familyid ON sessions(familyid);
+CREATE INDEX idx_sessions_userid ON sessions(userid);
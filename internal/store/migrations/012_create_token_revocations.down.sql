@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS userrevocations;
+DROP TABLE IF EXISTS revokedtokens;
@@ -0,0 +1,16 @@
+-- Revoked access tokens by jti, kept until the token would have expired anyway
+CREATE TABLE revokedtokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    userid INTEGER NOT NULL,
+    expiresat TIMESTAMP NOT NULL,
+    revokedat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_revokedtokens_revokedat ON revokedtokens(revokedat);
+
+-- Access tokens of a user issued before revokedbefore are rejected (logout everywhere).
+-- No foreign key so the entry outlives a deleted user's tokens.
+CREATE TABLE userrevocations (
+    userid INTEGER PRIMARY KEY,
+    revokedbefore TIMESTAMP NOT NULL,
+    updatedat TIMESTAMP NOT NULL DEFAULT NOW()
+);
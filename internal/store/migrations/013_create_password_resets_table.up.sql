@@ -0,0 +1,9 @@
+CREATE TABLE passwordresets (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    expiresat TIMESTAMP NOT NULL,
+    usedat TIMESTAMP,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_passwordresets_userid ON passwordresets(userid);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS emailverifications;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS verificationsentat,
+    DROP COLUMN IF EXISTS emailverifiedat,
+    DROP COLUMN IF EXISTS emailverified;
@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN emailverified BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN emailverifiedat TIMESTAMP,
+    ADD COLUMN verificationsentat TIMESTAMP;
+
+-- accounts created before verification existed are trusted as they are
+UPDATE users SET emailverified = TRUE, emailverifiedat = NOW();
+
+CREATE TABLE emailverifications (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    expiresat TIMESTAMP NOT NULL,
+    usedat TIMESTAMP,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_emailverifications_userid ON emailverifications(userid);
@@ -0,0 +1,8 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS mfa;
+DROP TABLE IF EXISTS loginchallenges;
+DROP TABLE IF EXISTS totprecoverycodes;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totplaststep,
+    DROP COLUMN IF EXISTS totpenabledat,
+    DROP COLUMN IF EXISTS totpenabled,
+    DROP COLUMN IF EXISTS totpsecret;
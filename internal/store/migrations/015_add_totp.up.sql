@@ -0,0 +1,28 @@
+ALTER TABLE users
+    ADD COLUMN totpsecret VARCHAR(64),
+    ADD COLUMN totpenabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totpenabledat TIMESTAMP,
+    -- last accepted time step, a code can only be used once
+    ADD COLUMN totplaststep BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE totprecoverycodes (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    codehash CHAR(64) NOT NULL,
+    usedat TIMESTAMP,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_totprecoverycodes_userid ON totprecoverycodes(userid);
+
+CREATE TABLE loginchallenges (
+    id SERIAL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expiresat TIMESTAMP NOT NULL,
+    usedat TIMESTAMP,
+    createdat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- refreshed access tokens keep whether the login passed a second factor
+ALTER TABLE sessions ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+-- failure counters for login throttling, keyed by 'account:<email>' or 'ip:<address>'
+CREATE TABLE loginthrottle (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    lastfailureat TIMESTAMPTZ NOT NULL,
+    blockeduntil TIMESTAMPTZ
+);
+CREATE INDEX idx_loginthrottle_lastfailureat ON loginthrottle(lastfailureat);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_users_email_lower;
+DROP INDEX IF EXISTS idx_users_username_lower;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS suspendedreason,
+    DROP COLUMN IF EXISTS suspendedat,
+    DROP COLUMN IF EXISTS suspended;
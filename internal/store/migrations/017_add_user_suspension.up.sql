@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN suspended BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN suspendedat TIMESTAMP,
+    ADD COLUMN suspendedreason VARCHAR(500) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_users_username_lower ON users(LOWER(username));
+CREATE INDEX idx_users_email_lower ON users(LOWER(email));
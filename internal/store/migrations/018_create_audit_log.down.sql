@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS auditlog_append_only ON auditlog;
+DROP FUNCTION IF EXISTS auditlog_append_only();
+DROP TABLE IF EXISTS auditlog;
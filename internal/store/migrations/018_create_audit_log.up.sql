@@ -0,0 +1,28 @@
+-- snapshots are JSON rather than JSONB so they come back byte for byte as hashed
+CREATE TABLE auditlog (
+    id BIGSERIAL PRIMARY KEY,
+    actorid INTEGER NOT NULL,
+    actorlevel INTEGER NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    targettype VARCHAR(32) NOT NULL,
+    targetid VARCHAR(64) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    before JSON,
+    after JSON,
+    createdat TIMESTAMPTZ NOT NULL,
+    prevhash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+CREATE INDEX idx_auditlog_actorid ON auditlog(actorid);
+CREATE INDEX idx_auditlog_target ON auditlog(targettype, targetid);
+CREATE INDEX idx_auditlog_createdat ON auditlog(createdat);
+
+CREATE FUNCTION auditlog_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'auditlog is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auditlog_append_only
+    BEFORE UPDATE OR DELETE ON auditlog
+    FOR EACH ROW EXECUTE FUNCTION auditlog_append_only();
@@ -0,0 +1,2 @@
+-- bumped by every admin edit, clients send it back in If-Match so concurrent edits can't overwrite each other
+ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,7 @@
+-- archived rows come back as live ones
+DROP INDEX IF EXISTS idx_users_deletedat;
+DROP INDEX IF EXISTS idx_products_deletedat;
+DROP INDEX IF EXISTS idx_products_name_price_os;
+CREATE UNIQUE INDEX idx_products_name_price_os ON products (name, price, operatingsystem);
+ALTER TABLE users DROP COLUMN IF EXISTS deletedat;
+ALTER TABLE products DROP COLUMN IF EXISTS deletedat;
@@ -0,0 +1,10 @@
+-- deleted products and users are archived rather than removed, orders keep pointing at them
+ALTER TABLE products ADD COLUMN deletedat TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN deletedat TIMESTAMPTZ;
+
+-- an archived laptop must not stop the same laptop being added again
+DROP INDEX IF EXISTS idx_products_name_price_os;
+CREATE UNIQUE INDEX idx_products_name_price_os ON products (name, price, operatingsystem) WHERE deletedat IS NULL;
+
+CREATE INDEX idx_products_deletedat ON products(deletedat) WHERE deletedat IS NOT NULL;
+CREATE INDEX idx_users_deletedat ON users(deletedat) WHERE deletedat IS NOT NULL;
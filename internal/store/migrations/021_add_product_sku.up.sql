@@ -0,0 +1,3 @@
+-- stable key for bulk imports, older products may not have one
+ALTER TABLE products ADD COLUMN sku VARCHAR(64);
+CREATE UNIQUE INDEX idx_products_sku ON products(sku) WHERE deletedat IS NULL;
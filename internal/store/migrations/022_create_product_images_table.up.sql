@@ -0,0 +1,19 @@
+-- Ordered pictures of a product. products.imageurl keeps the URL of the first one for older clients.
+CREATE TABLE productimages (
+    id SERIAL PRIMARY KEY,
+    productid INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position >= 0),
+    storagekey VARCHAR(512) NOT NULL UNIQUE,
+    url VARCHAR(255) NOT NULL,
+    contenttype VARCHAR(50) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    bytes INTEGER NOT NULL,
+    thumbnails JSONB NOT NULL DEFAULT '{}',
+    thumbnailstatus VARCHAR(16) NOT NULL DEFAULT 'pending',
+    thumbnailclaimedat TIMESTAMPTZ,
+    createdat TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- deferred so a reorder can swap positions within its transaction
+    CONSTRAINT productimages_position_unique UNIQUE (productid, position) DEFERRABLE INITIALLY DEFERRED
+);
+CREATE INDEX idx_productimages_thumbnails_todo ON productimages(id) WHERE thumbnailstatus IN ('pending', 'processing');
@@ -0,0 +1,13 @@
+ALTER TABLE userrevocations
+    ALTER COLUMN revokedbefore TYPE TIMESTAMP,
+    ALTER COLUMN updatedat TYPE TIMESTAMP;
+
+ALTER TABLE revokedtokens
+    ALTER COLUMN expiresat TYPE TIMESTAMP,
+    ALTER COLUMN revokedat TYPE TIMESTAMP;
+
+ALTER TABLE sessions
+    ALTER COLUMN expiresat TYPE TIMESTAMP,
+    ALTER COLUMN usedat TYPE TIMESTAMP,
+    ALTER COLUMN revokedat TYPE TIMESTAMP,
+    ALTER COLUMN createdat TYPE TIMESTAMP;
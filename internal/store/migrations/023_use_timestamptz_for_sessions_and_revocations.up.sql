@@ -0,0 +1,16 @@
+-- TIMESTAMP columns written from Go hold the app's local wall-clock time, which pgx reads back as UTC.
+-- Outside UTC that moved revocation cutoffs and session expiries by the zone offset. Existing values
+-- are taken to be in the server's TimeZone, as NOW() wrote them.
+ALTER TABLE sessions
+    ALTER COLUMN expiresat TYPE TIMESTAMPTZ,
+    ALTER COLUMN usedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN revokedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN createdat TYPE TIMESTAMPTZ;
+
+ALTER TABLE revokedtokens
+    ALTER COLUMN expiresat TYPE TIMESTAMPTZ,
+    ALTER COLUMN revokedat TYPE TIMESTAMPTZ;
+
+ALTER TABLE userrevocations
+    ALTER COLUMN revokedbefore TYPE TIMESTAMPTZ,
+    ALTER COLUMN updatedat TYPE TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+ALTER TABLE passwordresets
+    ALTER COLUMN expiresat TYPE TIMESTAMP,
+    ALTER COLUMN usedat TYPE TIMESTAMP,
+    ALTER COLUMN createdat TYPE TIMESTAMP;
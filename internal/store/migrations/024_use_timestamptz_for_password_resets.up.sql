@@ -0,0 +1,6 @@
+-- expiresat is written from Go; as TIMESTAMP it held local wall-clock time compared against NOW(),
+-- so links expired early or late by the app's zone offset. See 023.
+ALTER TABLE passwordresets
+    ALTER COLUMN expiresat TYPE TIMESTAMPTZ,
+    ALTER COLUMN usedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN createdat TYPE TIMESTAMPTZ;
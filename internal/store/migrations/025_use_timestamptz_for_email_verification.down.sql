@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ALTER COLUMN emailverifiedat TYPE TIMESTAMP,
+    ALTER COLUMN verificationsentat TYPE TIMESTAMP;
+
+ALTER TABLE emailverifications
+    ALTER COLUMN expiresat TYPE TIMESTAMP,
+    ALTER COLUMN usedat TYPE TIMESTAMP,
+    ALTER COLUMN createdat TYPE TIMESTAMP;
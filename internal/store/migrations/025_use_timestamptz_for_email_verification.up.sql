@@ -0,0 +1,10 @@
+-- expiresat is written from Go and verificationsentat is compared with Go's clock; as TIMESTAMP both
+-- were off by the app's zone offset, moving link expiry and the resend throttle. See 023.
+ALTER TABLE emailverifications
+    ALTER COLUMN expiresat TYPE TIMESTAMPTZ,
+    ALTER COLUMN usedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN createdat TYPE TIMESTAMPTZ;
+
+ALTER TABLE users
+    ALTER COLUMN emailverifiedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN verificationsentat TYPE TIMESTAMPTZ;
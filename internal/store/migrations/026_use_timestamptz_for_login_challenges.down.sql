@@ -0,0 +1,4 @@
+ALTER TABLE loginchallenges
+    ALTER COLUMN expiresat TYPE TIMESTAMP,
+    ALTER COLUMN usedat TYPE TIMESTAMP,
+    ALTER COLUMN createdat TYPE TIMESTAMP;
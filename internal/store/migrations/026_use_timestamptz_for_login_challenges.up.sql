@@ -0,0 +1,5 @@
+-- expiresat is written from Go and compared with NOW(); as TIMESTAMP it was off by the app's zone offset. See 023.
+ALTER TABLE loginchallenges
+    ALTER COLUMN expiresat TYPE TIMESTAMPTZ,
+    ALTER COLUMN usedat TYPE TIMESTAMPTZ,
+    ALTER COLUMN createdat TYPE TIMESTAMPTZ;
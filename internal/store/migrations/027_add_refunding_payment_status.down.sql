@@ -0,0 +1,4 @@
+UPDATE payments SET status = 'succeeded' WHERE status = 'refunding';
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'succeeded', 'failed', 'refunded'));
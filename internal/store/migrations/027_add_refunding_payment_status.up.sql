@@ -0,0 +1,4 @@
+-- a refund claims its payment before calling the provider, so two requests can't both send the money back
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'succeeded', 'failed', 'refunding', 'refunded'));
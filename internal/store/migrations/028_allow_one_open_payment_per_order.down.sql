@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_payments_one_open;
+UPDATE payments SET status = 'failed' WHERE status = 'initiating';
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'succeeded', 'failed', 'refunding', 'refunded'));
@@ -0,0 +1,10 @@
+-- a payment is recorded as initiating before the provider is asked, and an order can have only one
+-- initiating or pending payment, so two requests can't both send a payment prompt
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('initiating', 'pending', 'succeeded', 'failed', 'refunding', 'refunded'));
+-- earlier duplicates can't be told apart from abandoned attempts, keep the latest one open
+UPDATE payments p SET status = 'failed', message = 'superseded by a later payment attempt', updatedat = NOW()
+WHERE status = 'pending'
+AND EXISTS (SELECT 1 FROM payments later WHERE later.orderid = p.orderid AND later.status = 'pending' AND later.id > p.id);
+CREATE UNIQUE INDEX idx_payments_one_open ON payments(orderid) WHERE status IN ('initiating', 'pending');
@@ -3,11 +3,14 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"lapbytes/internal/model"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,9 +21,9 @@ func InsertLaptop(pool *pgxpool.Pool, lp model.Laptop) (product_id int, err erro
 	INSERT INTO products (name, brand, operatingsystem, operatingsystemversion, 
     hdd, ssd, hddsize, ssdsize, ramsize, 
     cpumaker, cpugen, cpumodel, yom, imageurl, price, screensize,
-    hasgpu, gpumake, gpumaker, hasigpu, isinstock)
+    hasgpu, gpumake, gpumaker, hasigpu, isinstock, sku, instock)
 	
-	VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,NULLIF($22, ''),$23)
 	
 	RETURNING id
 	`
@@ -47,6 +50,8 @@ func InsertLaptop(pool *pgxpool.Pool, lp model.Laptop) (product_id int, err erro
 		lp.Gpu_maker,
 		lp.Has_igpu,
 		lp.Is_in_stock,
+		lp.Sku,
+		lp.In_stock,
 	).Scan(&product_id)
 	if err != nil {
 		return 0, err
@@ -55,20 +60,150 @@ func InsertLaptop(pool *pgxpool.Pool, lp model.Laptop) (product_id int, err erro
 
 }
 
-// DeleteLaptop removes a laptop from the products table by ID
+var (
+	ErrProductVersionConflict = errors.New("product was changed by someone else")
+	ErrDuplicateProduct       = errors.New("a product with the same name, price and operating system exists")
+	ErrDuplicateSku           = errors.New("a product with the same sku exists")
+)
+
+// productConflict maps unique violations on products to ErrDuplicateSku or ErrDuplicateProduct
+func productConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" { //unique_violation
+		return err
+	}
+	if pgErr.ConstraintName == "idx_products_sku" {
+		return ErrDuplicateSku
+	}
+	return ErrDuplicateProduct
+}
+
+// LaptopPatch is a partial laptop update, nil fields keep their current value
+type LaptopPatch struct {
+	Name                     *string
+	Brand                    *string
+	Operating_system         *string
+	Operating_system_version *string
+	HDD                      *bool
+	SSD                      *bool
+	HDD_size                 *float64
+	SSD_size                 *float64
+	Ram_size                 *float64
+	CPU_maker                *string
+	CPU_gen                  *string
+	CPU_model                *string
+	YOM                      *string
+	Image_url                *string
+	Price                    *float64
+	Screen_size              *float64
+	Has_gpu                  *bool
+	Gpu_make                 *sql.NullString
+	Gpu_maker                *sql.NullString
+	Has_igpu                 *bool
+	Is_in_stock              *bool
+	In_stock                 *int
+	Sku                      *string
+}
+
+// assignments returns the columns the patch sets and their values, in the same order
+func (p LaptopPatch) assignments() (columns []string, values []any) {
+	set := func(column string, isSet bool, value any) {
+		if isSet {
+			columns = append(columns, column)
+			values = append(values, value)
+		}
+	}
+	set("name", p.Name != nil, p.Name)
+	set("brand", p.Brand != nil, p.Brand)
+	set("operatingsystem", p.Operating_system != nil, p.Operating_system)
+	set("operatingsystemversion", p.Operating_system_version != nil, p.Operating_system_version)
+	set("hdd", p.HDD != nil, p.HDD)
+	set("ssd", p.SSD != nil, p.SSD)
+	set("hddsize", p.HDD_size != nil, p.HDD_size)
+	set("ssdsize", p.SSD_size != nil, p.SSD_size)
+	set("ramsize", p.Ram_size != nil, p.Ram_size)
+	set("cpumaker", p.CPU_maker != nil, p.CPU_maker)
+	set("cpugen", p.CPU_gen != nil, p.CPU_gen)
+	set("cpumodel", p.CPU_model != nil, p.CPU_model)
+	set("yom", p.YOM != nil, p.YOM)
+	set("imageurl", p.Image_url != nil, p.Image_url)
+	set("price", p.Price != nil, p.Price)
+	set("screensize", p.Screen_size != nil, p.Screen_size)
+	set("hasgpu", p.Has_gpu != nil, p.Has_gpu)
+	set("gpumake", p.Gpu_make != nil, p.Gpu_make)
+	set("gpumaker", p.Gpu_maker != nil, p.Gpu_maker)
+	set("hasigpu", p.Has_igpu != nil, p.Has_igpu)
+	set("isinstock", p.Is_in_stock != nil, p.Is_in_stock)
+	set("instock", p.In_stock != nil, p.In_stock)
+	set("sku", p.Sku != nil, p.Sku)
+	return columns, values
+}
+
+// UpdateLaptop applies a partial update to a laptop and bumps its version. If version is
+// not 0 the laptop must still be at that version, otherwise ErrProductVersionConflict is
+// returned along with the laptop as it is now. previous is the laptop before the update.
+func UpdateLaptop(pool *pgxpool.Pool, id int, patch LaptopPatch, version int) (previous model.Laptop, updated model.Laptop, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.Laptop{}, model.Laptop{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `SELECT `+laptopColumns+` FROM products WHERE id = $1 AND deletedat IS NULL FOR UPDATE`, id).
+		Scan(laptopScanTargets(&previous)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Laptop{}, model.Laptop{}, ErrProductNotFound
+	}
+	if err != nil {
+		return model.Laptop{}, model.Laptop{}, err
+	}
+	if version != 0 && previous.Version != version {
+		return previous, model.Laptop{}, ErrProductVersionConflict
+	}
+
+	columns, values := patch.assignments()
+	sets := []string{"version = version + 1", "updatedat = NOW()"}
+	args := []any{id}
+	for i, column := range columns {
+		args = append(args, values[i])
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	stmt := fmt.Sprintf(`UPDATE products SET %s WHERE id = $1 RETURNING %s`, strings.Join(sets, ", "), laptopColumns)
+	err = tx.QueryRow(ctx, stmt, args...).Scan(laptopScanTargets(&updated)...)
+	if err != nil {
+		return previous, model.Laptop{}, productConflict(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return previous, model.Laptop{}, err
+	}
+	return previous, updated, nil
+}
+
+// DeleteLaptop archives a laptop by ID. It leaves the catalog and every cart but stays
+// for the orders that reference it until PurgeArchivedLaptops removes it
 func DeleteLaptop(pool *pgxpool.Pool, id int) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 
 	stmt := `
-		DELETE FROM products WHERE id=$1
+		UPDATE products SET deletedat = NOW(), updatedat = NOW() WHERE id=$1 AND deletedat IS NULL
 	`
-	result, err := pool.Exec(context.Background(), stmt, id)
+	result, err := tx.Exec(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
 	if result.RowsAffected() == 0 {
 		return fmt.Errorf("product with id %d not found", id)
 	}
-	return nil
+	if _, err = tx.Exec(ctx, `DELETE FROM cartitems WHERE productid = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 // GetAllUsers retrieves paginated list of users
@@ -76,6 +211,7 @@ func GetAllUsers(pool *pgxpool.Pool, limit, offset int) (users []model.User, err
 	stmt := `
 	SELECT username,email,createdat,accesslevel
 	FROM users
+	WHERE deletedat IS NULL
 	ORDER BY createdat DESC
 	LIMIT $1 OFFSET $2
 	
@@ -106,12 +242,12 @@ func GetAllUsers(pool *pgxpool.Pool, limit, offset int) (users []model.User, err
 
 }
 
-// DeleteUser removes a user from the users by ID
+// DeleteUser archives a user by ID. Their orders keep pointing at them until
+// PurgeArchivedUsers removes users nothing references
 func DeleteUser(pool *pgxpool.Pool, id int) error {
 	stmt := `
-		DELETE
-		FROM users
-		WHERE id=$1
+		UPDATE users SET deletedat = NOW(), updatedat = NOW()
+		WHERE id=$1 AND deletedat IS NULL
 	`
 	result, err := pool.Exec(context.Background(), stmt, id)
 	if err != nil {
@@ -128,23 +264,202 @@ func DeleteUser(pool *pgxpool.Pool, id int) error {
 func GetUser(pool *pgxpool.Pool, id int) (user model.User, err error) {
 
 	stmt := `
-	SELECT username,email,createdat,accesslevel
+	SELECT username,email,createdat,accesslevel,emailverified,emailverifiedat,verificationsentat,totpenabled,
+	suspended,suspendedat,suspendedreason
 	FROM users
-	WHERE id=$1
+	WHERE id=$1 AND deletedat IS NULL
 	
 	`
 	err = pool.QueryRow(context.Background(), stmt, id).Scan(
 		&user.Username,
 		&user.Email,
 		&user.Created_at,
-		&user.Access_level)
+		&user.Access_level,
+		&user.Email_verified,
+		&user.Email_verified_at,
+		&user.Verification_sent_at,
+		&user.Totp_enabled,
+		&user.Suspended,
+		&user.Suspended_at,
+		&user.Suspended_reason)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return model.User{}, fmt.Errorf("user with id %d not found", id)
+			return model.User{}, fmt.Errorf("user with id %d: %w", id, ErrUserNotFound)
 		}
 		return model.User{}, err
 	}
+	user.Id = id
 
 	return user, nil
 }
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUserOutranks  = errors.New("user has a higher access level than the admin")
+	ErrLastSuperuser = errors.New("the last active superuser can't be demoted or suspended")
+)
+
+// lockUserForAdminChange locks a user row and checks the acting admin may change it,
+// a user at a more privileged (lower) level than actorLevel can't be touched
+func lockUserForAdminChange(ctx context.Context, tx pgx.Tx, id int, actorLevel int) (level int, err error) {
+	err = tx.QueryRow(ctx, `SELECT accesslevel FROM users WHERE id = $1 AND deletedat IS NULL FOR UPDATE`, id).Scan(&level)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	if level < actorLevel {
+		return 0, ErrUserOutranks
+	}
+	return level, nil
+}
+
+// ensureAnotherSuperuser fails with ErrLastSuperuser unless an active superuser other than id exists.
+// The superuser rows are locked so two concurrent demotions can't both pass.
+func ensureAnotherSuperuser(ctx context.Context, tx pgx.Tx, id int) error {
+	rows, err := tx.Query(ctx, `SELECT id FROM users WHERE accesslevel = 0 AND NOT suspended AND deletedat IS NULL FOR UPDATE`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var other int
+		if err = rows.Scan(&other); err != nil {
+			return err
+		}
+		if other != id {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return ErrLastSuperuser
+}
+
+// SetAccessLevel changes the access level of a user on behalf of an admin at actorLevel
+// and returns the previous level
+func SetAccessLevel(pool *pgxpool.Pool, id int, level int, actorLevel int) (previous int, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	previous, err = lockUserForAdminChange(ctx, tx, id, actorLevel)
+	if err != nil {
+		return 0, err
+	}
+	if previous == 0 && level != 0 {
+		if err = ensureAnotherSuperuser(ctx, tx, id); err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(ctx, `
+	UPDATE users SET accesslevel = $2, isadmin = $2 <= 1, updatedat = NOW() WHERE id = $1
+	`, id, level)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return previous, nil
+}
+
+// SetUserSuspended suspends or reactivates a user on behalf of an admin at actorLevel
+func SetUserSuspended(pool *pgxpool.Pool, id int, suspended bool, reason string, actorLevel int) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	level, err := lockUserForAdminChange(ctx, tx, id, actorLevel)
+	if err != nil {
+		return err
+	}
+	if suspended && level == 0 {
+		if err = ensureAnotherSuperuser(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	stmt := `
+	UPDATE users SET suspended = FALSE, suspendedat = NULL, suspendedreason = '', updatedat = NOW()
+	WHERE id = $1
+	`
+	args := []any{id}
+	if suspended {
+		stmt = `
+		UPDATE users SET suspended = TRUE, suspendedat = NOW(), suspendedreason = $2, updatedat = NOW()
+		WHERE id = $1
+		`
+		args = append(args, reason)
+	}
+	if _, err = tx.Exec(ctx, stmt, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UserSearch filters SearchUsers, empty fields match everything
+type UserSearch struct {
+	Query        string //matched against email and username
+	Access_level *int
+	Suspended    *bool
+}
+
+// SearchUsers returns a page of users matching a search, newest first, and the total number of matches
+func SearchUsers(pool *pgxpool.Pool, search UserSearch, limit, offset int) (users []model.UserSummary, total int, err error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(search.Query)) + "%"
+	where := `
+	WHERE deletedat IS NULL
+	AND (LOWER(email) LIKE $1 OR LOWER(username) LIKE $1)
+	AND ($2::INTEGER IS NULL OR accesslevel = $2)
+	AND ($3::BOOLEAN IS NULL OR suspended = $3)
+	`
+	ctx := context.Background()
+	err = pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`+where, pattern, search.Access_level, search.Suspended).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	stmt := `
+	SELECT id,username,email,accesslevel,emailverified,totpenabled,suspended,suspendedat,suspendedreason,createdat
+	FROM users` + where + `
+	ORDER BY createdat DESC, id DESC
+	LIMIT $4 OFFSET $5
+	`
+	rows, err := pool.Query(ctx, stmt, pattern, search.Access_level, search.Suspended, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users = []model.UserSummary{}
+	for rows.Next() {
+		var u model.UserSummary
+		if err = rows.Scan(
+			&u.Id,
+			&u.Username,
+			&u.Email,
+			&u.Access_level,
+			&u.Email_verified,
+			&u.Totp_enabled,
+			&u.Suspended,
+			&u.Suspended_at,
+			&u.Suspended_reason,
+			&u.Created_at,
+		); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
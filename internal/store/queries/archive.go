@@ -0,0 +1,177 @@
+// Defines Queries/Db operations related to archived (soft deleted) products and users
+package queries
+
+import (
+	"context"
+	"errors"
+	"lapbytes/internal/model"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotArchived = errors.New("record is not archived")
+
+// ListArchivedLaptops returns a page of archived laptops, most recently archived first, and the total
+func ListArchivedLaptops(pool *pgxpool.Pool, limit, offset int) (laptops []model.Laptop, total int, err error) {
+	rows, err := pool.Query(context.Background(), `
+		SELECT `+laptopColumns+`, deletedat, COUNT(*) OVER()
+		FROM products
+		WHERE deletedat IS NOT NULL
+		ORDER BY deletedat DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	laptops = []model.Laptop{}
+	for rows.Next() {
+		var p model.Laptop
+		if err = rows.Scan(append(laptopScanTargets(&p), &p.Deleted_at, &total)...); err != nil {
+			return nil, 0, err
+		}
+		laptops = append(laptops, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return laptops, total, nil
+}
+
+// RestoreLaptop brings an archived laptop back into the catalog. It fails with ErrDuplicateProduct
+// or ErrDuplicateSku if the same laptop was added again in the meantime.
+func RestoreLaptop(pool *pgxpool.Pool, id int) (laptop model.Laptop, err error) {
+	err = pool.QueryRow(context.Background(), `
+		UPDATE products SET deletedat = NULL, updatedat = NOW(), version = version + 1
+		WHERE id = $1 AND deletedat IS NOT NULL
+		RETURNING `+laptopColumns,
+		id).Scan(laptopScanTargets(&laptop)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Laptop{}, whyNotRestored(pool, "products", id, ErrProductNotFound)
+		}
+		return model.Laptop{}, productConflict(err)
+	}
+	return laptop, nil
+}
+
+// PurgeArchivedLaptops deletes laptops archived before cutoff that no order refers to. It returns
+// how many were deleted and the storage keys of their images, whose files are left to the caller.
+func PurgeArchivedLaptops(pool *pgxpool.Pool, cutoff time.Time) (purged int64, imageKeys []string, err error) {
+	rows, err := pool.Query(context.Background(), `
+		WITH purged AS (
+			DELETE FROM products p
+			WHERE p.deletedat < $1
+			AND NOT EXISTS (SELECT 1 FROM orderitems oi WHERE oi.productid = p.id)
+			RETURNING p.id
+		)
+		SELECT purged.id, pi.storagekey
+		FROM purged LEFT JOIN productimages pi ON pi.productid = purged.id
+	`, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	seen := map[int]bool{}
+	for rows.Next() {
+		var id int
+		var key *string //no images
+		if err = rows.Scan(&id, &key); err != nil {
+			return 0, nil, err
+		}
+		seen[id] = true
+		if key != nil {
+			imageKeys = append(imageKeys, *key)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return int64(len(seen)), imageKeys, nil
+}
+
+// ListArchivedUsers returns a page of archived users, most recently archived first, and the total
+func ListArchivedUsers(pool *pgxpool.Pool, limit, offset int) (users []model.UserSummary, total int, err error) {
+	rows, err := pool.Query(context.Background(), `
+		SELECT id,username,email,accesslevel,emailverified,totpenabled,suspended,suspendedat,suspendedreason,createdat,
+		deletedat, COUNT(*) OVER()
+		FROM users
+		WHERE deletedat IS NOT NULL
+		ORDER BY deletedat DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users = []model.UserSummary{}
+	for rows.Next() {
+		var u model.UserSummary
+		if err = rows.Scan(
+			&u.Id,
+			&u.Username,
+			&u.Email,
+			&u.Access_level,
+			&u.Email_verified,
+			&u.Totp_enabled,
+			&u.Suspended,
+			&u.Suspended_at,
+			&u.Suspended_reason,
+			&u.Created_at,
+			&u.Deleted_at,
+			&total,
+		); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// RestoreUser brings back an archived user. They have to log in again, their sessions ended on deletion.
+func RestoreUser(pool *pgxpool.Pool, id int) error {
+	result, err := pool.Exec(context.Background(),
+		`UPDATE users SET deletedat = NULL, updatedat = NOW() WHERE id = $1 AND deletedat IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return whyNotRestored(pool, "users", id, ErrUserNotFound)
+	}
+	return nil
+}
+
+// PurgeArchivedUsers deletes users archived before cutoff that no order or order history refers to
+func PurgeArchivedUsers(pool *pgxpool.Pool, cutoff time.Time) (int64, error) {
+	result, err := pool.Exec(context.Background(), `
+		DELETE FROM users u
+		WHERE u.deletedat < $1
+		AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.userid = u.id)
+		AND NOT EXISTS (SELECT 1 FROM orderhistory h WHERE h.actorid = u.id)
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// whyNotRestored tells a restore that matched nothing apart: the row is live (ErrNotArchived) or missing (notFound)
+func whyNotRestored(pool *pgxpool.Pool, table string, id int, notFound error) error {
+	var exists bool
+	err := pool.QueryRow(context.Background(), `SELECT TRUE FROM `+table+` WHERE id = $1`, id).Scan(&exists)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrNotArchived
+}
@@ -0,0 +1,175 @@
+// Defines Queries/Db operations related to the audit log
+package queries
+
+import (
+	"context"
+	"errors"
+	"lapbytes/internal/audit"
+	"lapbytes/internal/model"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auditChainLock is the advisory lock serializing appends, so two entries can't
+// chain to the same previous entry
+const auditChainLock = 7_310_019
+
+const auditColumns = `id, actorid, actorlevel, action, targettype, targetid, ip, before, after, createdat, prevhash, hash`
+
+// auditRow scans the nullable JSON snapshots before they become RawMessages
+type auditRow struct {
+	model.AuditEntry
+	before *string
+	after  *string
+}
+
+func (r *auditRow) scanTargets() []any {
+	return []any{
+		&r.Id,
+		&r.Actor_id,
+		&r.Actor_level,
+		&r.Action,
+		&r.Target_type,
+		&r.Target_id,
+		&r.Ip,
+		&r.before,
+		&r.after,
+		&r.Created_at,
+		&r.Prev_hash,
+		&r.Hash,
+	}
+}
+
+func (r *auditRow) entry() model.AuditEntry {
+	e := r.AuditEntry
+	if r.before != nil {
+		e.Before = []byte(*r.before)
+	}
+	if r.after != nil {
+		e.After = []byte(*r.after)
+	}
+	return e
+}
+
+func nullableJSON(b []byte) *string {
+	if len(b) == 0 {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// AppendAuditEntry chains an entry to the end of the audit log and returns it with its id and hashes
+func AppendAuditEntry(pool *pgxpool.Pool, e model.AuditEntry) (model.AuditEntry, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return model.AuditEntry{}, err
+	}
+	e.Prev_hash = audit.Genesis
+	err = tx.QueryRow(ctx, `SELECT hash FROM auditlog ORDER BY id DESC LIMIT 1`).Scan(&e.Prev_hash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return model.AuditEntry{}, err
+	}
+	// Postgres keeps microseconds, the hash must cover the time as it will be read back
+	e.Created_at = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = audit.Hash(e.Prev_hash, e)
+
+	err = tx.QueryRow(ctx, `
+	INSERT INTO auditlog (actorid, actorlevel, action, targettype, targetid, ip, before, after, createdat, prevhash, hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id
+	`, e.Actor_id, e.Actor_level, e.Action, e.Target_type, e.Target_id, e.Ip,
+		nullableJSON(e.Before), nullableJSON(e.After), e.Created_at, e.Prev_hash, e.Hash,
+	).Scan(&e.Id)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return model.AuditEntry{}, err
+	}
+	return e, nil
+}
+
+// AuditFilter narrows ListAuditEntries. Zero values mean "any".
+type AuditFilter struct {
+	ActorId    int
+	Action     string
+	TargetType string
+	TargetId   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// ListAuditEntries returns a page of audit entries, newest first, and the total number of matches
+func ListAuditEntries(pool *pgxpool.Pool, f AuditFilter) (entries []model.AuditEntry, total int, err error) {
+	var since, until *time.Time
+	if !f.Since.IsZero() {
+		since = &f.Since
+	}
+	if !f.Until.IsZero() {
+		until = &f.Until
+	}
+	stmt := `
+		SELECT ` + auditColumns + `, COUNT(*) OVER()
+		FROM auditlog
+		WHERE ($1 = 0 OR actorid = $1)
+		AND ($2 = '' OR action = $2)
+		AND ($3 = '' OR targettype = $3)
+		AND ($4 = '' OR targetid = $4)
+		AND ($5::TIMESTAMPTZ IS NULL OR createdat >= $5)
+		AND ($6::TIMESTAMPTZ IS NULL OR createdat < $6)
+		ORDER BY id DESC
+		LIMIT $7 OFFSET $8
+	`
+	rows, err := pool.Query(context.Background(), stmt,
+		f.ActorId, f.Action, f.TargetType, f.TargetId, since, until, f.Limit, f.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries = []model.AuditEntry{}
+	for rows.Next() {
+		var r auditRow
+		if err = rows.Scan(append(r.scanTargets(), &total)...); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, r.entry())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// AuditEntriesAfter returns up to limit entries with an id above afterId in chain order, for verification
+func AuditEntriesAfter(pool *pgxpool.Pool, afterId int64, limit int) (entries []model.AuditEntry, err error) {
+	rows, err := pool.Query(context.Background(),
+		`SELECT `+auditColumns+` FROM auditlog WHERE id > $1 ORDER BY id LIMIT $2`, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r auditRow
+		if err = rows.Scan(r.scanTargets()...); err != nil {
+			return nil, err
+		}
+		entries = append(entries, r.entry())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,330 @@
+// Defines Queries/Db operations related to shopping carts
+package queries
+
+import (
+	"context"
+	"errors"
+	"lapbytes/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrCartItemNotFound  = errors.New("cart item not found")
+	ErrCartNotFound      = errors.New("cart not found")
+	ErrCartItemLimit     = errors.New("cart line would exceed the maximum quantity")
+)
+
+// Statuses reported for guest cart lines merged on login
+const (
+	MergeStatusMerged   = "merged"
+	MergeStatusAdjusted = "adjusted"
+	MergeStatusRemoved  = "removed"
+)
+
+// GetOrCreateUserCart returns the id of the user's cart, creating it on first use
+func GetOrCreateUserCart(pool *pgxpool.Pool, userId int) (cartId int, err error) {
+	stmt := `
+	INSERT INTO carts (userid) VALUES ($1)
+	ON CONFLICT (userid) DO UPDATE SET updatedat = carts.updatedat
+	RETURNING id
+	`
+	err = pool.QueryRow(context.Background(), stmt, userId).Scan(&cartId)
+	if err != nil {
+		return 0, err
+	}
+	return cartId, nil
+}
+
+// GetOrCreateGuestCart returns the id of the cart for an anonymous guest id, creating it on first use
+func GetOrCreateGuestCart(pool *pgxpool.Pool, guestId string) (cartId int, err error) {
+	stmt := `
+	INSERT INTO carts (guestid) VALUES ($1)
+	ON CONFLICT (guestid) DO UPDATE SET updatedat = NOW()
+	RETURNING id
+	`
+	err = pool.QueryRow(context.Background(), stmt, guestId).Scan(&cartId)
+	if err != nil {
+		return 0, err
+	}
+	return cartId, nil
+}
+
+// FindGuestCart returns the id of an existing guest cart
+func FindGuestCart(pool *pgxpool.Pool, guestId string) (cartId int, err error) {
+	err = pool.QueryRow(context.Background(),
+		`SELECT id FROM carts WHERE guestid = $1`, guestId,
+	).Scan(&cartId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrCartNotFound
+		}
+		return 0, err
+	}
+	return cartId, nil
+}
+
+// MergeGuestCart moves a guest cart into the user's cart and deletes the guest cart.
+//
+// Rules: quantities of the same product are added together and capped at the
+// units left in stock and at maxQuantity (status "adjusted"); products that are
+// sold out or archived are dropped (status "removed"). A line the user already had
+// is never reduced by the merge. A missing guest cart returns an empty result.
+func MergeGuestCart(pool *pgxpool.Pool, guestId string, userId int, maxQuantity int) (result model.CartMergeResult, err error) {
+	ctx := context.Background()
+	result.Items = []model.CartMergeItem{}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx)
+
+	var guestCartId int
+	err = tx.QueryRow(ctx, `SELECT id FROM carts WHERE guestid = $1 FOR UPDATE`, guestId).Scan(&guestCartId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return result, nil
+		}
+		return result, err
+	}
+
+	var userCartId int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO carts (userid) VALUES ($1)
+		ON CONFLICT (userid) DO UPDATE SET updatedat = NOW()
+		RETURNING id
+	`, userId).Scan(&userCartId)
+	if err != nil {
+		return result, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT g.productid, p.name, g.quantity, COALESCE(u.quantity, 0),
+		       CASE WHEN p.isinstock THEN p.instock ELSE 0 END, p.deletedat IS NOT NULL
+		FROM cartitems g
+		JOIN products p ON p.id = g.productid
+		LEFT JOIN cartitems u ON u.cartid = $2 AND u.productid = g.productid
+		WHERE g.cartid = $1
+		ORDER BY g.createdat, g.id
+	`, guestCartId, userCartId)
+	if err != nil {
+		return result, err
+	}
+	type mergeLine struct {
+		item     model.CartMergeItem
+		existing int
+	}
+	var lines []mergeLine
+	for rows.Next() {
+		var l mergeLine
+		var guestQty, available int
+		var archived bool
+		if err = rows.Scan(&l.item.Product_id, &l.item.Name, &guestQty, &l.existing, &available, &archived); err != nil {
+			rows.Close()
+			return result, err
+		}
+		mergeCartLine(&l.item, guestQty, l.existing, available, maxQuantity, archived)
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, l := range lines {
+		if l.item.Quantity > 0 && l.item.Quantity != l.existing {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO cartitems (cartid, productid, quantity)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (cartid, productid)
+				DO UPDATE SET quantity = EXCLUDED.quantity, updatedat = NOW()
+			`, userCartId, l.item.Product_id, l.item.Quantity)
+			if err != nil {
+				return result, err
+			}
+		}
+		if l.item.Status != MergeStatusMerged {
+			result.Changed = true
+		}
+		result.Items = append(result.Items, l.item)
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM carts WHERE id = $1`, guestCartId); err != nil {
+		return result, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// mergeCartLine fills in the quantity and status of a guest line merged into a user's
+// line that already holds existing units
+func mergeCartLine(item *model.CartMergeItem, guestQty, existing, available, maxQuantity int, archived bool) {
+	item.Requested = guestQty + existing
+	limit := min(available, maxQuantity)
+	switch {
+	case archived:
+		item.Quantity = existing
+		item.Status = MergeStatusRemoved
+		item.Reason = "no longer sold"
+	case available <= 0:
+		item.Quantity = existing
+		item.Status = MergeStatusRemoved
+		item.Reason = "out of stock"
+	case item.Requested > limit:
+		item.Quantity = max(limit, existing)
+		item.Status = MergeStatusAdjusted
+		item.Reason = "limited stock"
+	default:
+		item.Quantity = item.Requested
+		item.Status = MergeStatusMerged
+	}
+}
+
+// setCartLineStock works out whether a cart line can be checked out as it is. An archived
+// product is reported with nothing left, the same way checkout sees it.
+func setCartLineStock(item *model.CartItem) {
+	if item.Archived {
+		item.Available = 0
+		item.Is_in_stock = false
+	}
+	item.Stock_ok = item.Is_in_stock && item.Quantity <= item.Available
+}
+
+// GetCart loads a cart with its items priced at the current product prices. Lines whose
+// product was archived are kept so the customer can see and remove them.
+func GetCart(pool *pgxpool.Pool, cartId int) (cart model.Cart, err error) {
+	stmt := `
+	SELECT ci.productid, p.name, p.brand, p.imageurl, p.price, ci.quantity, p.instock, p.isinstock,
+	       p.deletedat IS NOT NULL
+	FROM cartitems ci
+	JOIN products p ON p.id = ci.productid
+	WHERE ci.cartid = $1
+	ORDER BY ci.createdat, ci.id
+	`
+	rows, err := pool.Query(context.Background(), stmt, cartId)
+	if err != nil {
+		return model.Cart{}, err
+	}
+	defer rows.Close()
+
+	cart.Id = cartId
+	cart.Items = []model.CartItem{}
+	for rows.Next() {
+		var item model.CartItem
+		if err = rows.Scan(
+			&item.Product_id,
+			&item.Name,
+			&item.Brand,
+			&item.Image_url,
+			&item.Unit_price,
+			&item.Quantity,
+			&item.Available,
+			&item.Is_in_stock,
+			&item.Archived,
+		); err != nil {
+			return model.Cart{}, err
+		}
+		item.Line_total = item.Unit_price * float64(item.Quantity)
+		setCartLineStock(&item)
+		cart.Items = append(cart.Items, item)
+		cart.Item_count += item.Quantity
+		cart.Subtotal += item.Line_total
+	}
+	if err = rows.Err(); err != nil {
+		return model.Cart{}, err
+	}
+	return cart, nil
+}
+
+// productStock returns how many units of a product are left
+func productStock(pool *pgxpool.Pool, productId int) (int, error) {
+	var inStock int
+	var isInStock bool
+	err := pool.QueryRow(context.Background(),
+		`SELECT instock, isinstock FROM products WHERE id=$1 AND deletedat IS NULL`, productId,
+	).Scan(&inStock, &isInStock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrProductNotFound
+		}
+		return 0, err
+	}
+	if !isInStock {
+		return 0, nil
+	}
+	return inStock, nil
+}
+
+// AddCartItem adds quantity units of a product to the cart, merging with an existing line that
+// may hold at most maxQuantity units. Stock is checked here for early feedback, checkout
+// re-checks it under lock.
+func AddCartItem(pool *pgxpool.Pool, cartId, productId, quantity, maxQuantity int) error {
+	available, err := productStock(pool, productId)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = pool.QueryRow(context.Background(),
+		`SELECT quantity FROM cartitems WHERE cartid=$1 AND productid=$2`, cartId, productId,
+	).Scan(&current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if current+quantity > maxQuantity {
+		return ErrCartItemLimit
+	}
+	if current+quantity > available {
+		return ErrInsufficientStock
+	}
+
+	stmt := `
+	INSERT INTO cartitems (cartid, productid, quantity)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (cartid, productid)
+	DO UPDATE SET quantity = cartitems.quantity + EXCLUDED.quantity, updatedat = NOW()
+	`
+	_, err = pool.Exec(context.Background(), stmt, cartId, productId, quantity)
+	return err
+}
+
+// SetCartItemQuantity replaces the quantity of an existing cart line
+func SetCartItemQuantity(pool *pgxpool.Pool, cartId, productId, quantity int) error {
+	available, err := productStock(pool, productId)
+	if err != nil {
+		return err
+	}
+	if quantity > available {
+		return ErrInsufficientStock
+	}
+	stmt := `
+	UPDATE cartitems SET quantity = $3, updatedat = NOW()
+	WHERE cartid = $1 AND productid = $2
+	`
+	result, err := pool.Exec(context.Background(), stmt, cartId, productId, quantity)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
+// RemoveCartItem deletes a product line from the cart
+func RemoveCartItem(pool *pgxpool.Pool, cartId, productId int) error {
+	result, err := pool.Exec(context.Background(),
+		`DELETE FROM cartitems WHERE cartid = $1 AND productid = $2`, cartId, productId)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package queries
+
+import (
+	"lapbytes/internal/model"
+	"testing"
+)
+
+func TestMergeCartLine(t *testing.T) {
+	tests := []struct {
+		name                                  string
+		guestQty, existing, available, maxQty int
+		archived                              bool
+		expectQty                             int
+		expectStatus                          string
+		expectReason                          string
+	}{
+		{"merged", 2, 1, 10, 99, false, 3, MergeStatusMerged, ""},
+		{"capped by stock", 5, 3, 6, 99, false, 6, MergeStatusAdjusted, "limited stock"},
+		{"capped by line limit", 60, 50, 500, 99, false, 99, MergeStatusAdjusted, "limited stock"},
+		{"never reduces the user's line", 2, 8, 5, 99, false, 8, MergeStatusAdjusted, "limited stock"},
+		{"sold out", 2, 0, 0, 99, false, 0, MergeStatusRemoved, "out of stock"},
+		{"archived while in the cart", 2, 0, 10, 99, true, 0, MergeStatusRemoved, "no longer sold"},
+		{"archived keeps the user's line", 2, 1, 10, 99, true, 1, MergeStatusRemoved, "no longer sold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var item model.CartMergeItem
+			mergeCartLine(&item, tt.guestQty, tt.existing, tt.available, tt.maxQty, tt.archived)
+			if item.Quantity != tt.expectQty {
+				t.Errorf("expected quantity %d but got %d", tt.expectQty, item.Quantity)
+			}
+			if item.Status != tt.expectStatus {
+				t.Errorf("expected status %q but got %q", tt.expectStatus, item.Status)
+			}
+			if item.Reason != tt.expectReason {
+				t.Errorf("expected reason %q but got %q", tt.expectReason, item.Reason)
+			}
+		})
+	}
+}
+
+func TestSetCartLineStock(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     model.CartItem
+		expectOk bool
+	}{
+		{"in stock", model.CartItem{Quantity: 2, Available: 5, Is_in_stock: true}, true},
+		{"more than is left", model.CartItem{Quantity: 6, Available: 5, Is_in_stock: true}, false},
+		{"sold out", model.CartItem{Quantity: 1, Available: 5}, false},
+		{"archived while in the cart", model.CartItem{Quantity: 1, Available: 5, Is_in_stock: true, Archived: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := tt.item
+			setCartLineStock(&item)
+			if item.Stock_ok != tt.expectOk {
+				t.Errorf("expected stock_ok %v but got %v", tt.expectOk, item.Stock_ok)
+			}
+			if item.Archived && (item.Available != 0 || item.Is_in_stock) {
+				t.Errorf("expected an archived line to report nothing left, got %d in stock %v", item.Available, item.Is_in_stock)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Defines Queries/Db operations related to product images and their thumbnails
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Thumbnail states of an image
+const (
+	ThumbnailsPending    = "pending"
+	ThumbnailsProcessing = "processing"
+	ThumbnailsReady      = "ready"
+	ThumbnailsFailed     = "failed"
+)
+
+const MaxProductImages = 20
+
+var (
+	ErrImageNotFound      = errors.New("image not found")
+	ErrTooManyImages      = fmt.Errorf("a product can have at most %d images", MaxProductImages)
+	ErrImageOrderMismatch = errors.New("image_ids must list every image of the product exactly once")
+)
+
+const imageColumns = `id, productid, position, storagekey, url, contenttype, width, height, bytes,
+	thumbnails, thumbnailstatus, createdat`
+
+func imageScanTargets(i *model.ProductImage) []any {
+	return []any{
+		&i.Id,
+		&i.Product_id,
+		&i.Position,
+		&i.Key,
+		&i.Url,
+		&i.Content_type,
+		&i.Width,
+		&i.Height,
+		&i.Bytes,
+		&i.Thumbnails,
+		&i.Thumbnail_status,
+		&i.Created_at,
+	}
+}
+
+// ListProductImages returns the images of a laptop in display order
+func ListProductImages(pool *pgxpool.Pool, productId int) ([]model.ProductImage, error) {
+	return listImages(context.Background(), pool, productId)
+}
+
+// ProductCoverImage returns the first image of a live laptop and the laptop's version, which
+// changes whenever its images do. ErrImageNotFound if it has none.
+func ProductCoverImage(pool *pgxpool.Pool, productId int) (image model.ProductImage, version int, err error) {
+	err = pool.QueryRow(context.Background(), `
+		SELECT `+imageColumns+`, (SELECT version FROM products WHERE id = $1) FROM productimages
+		WHERE productid = $1 AND EXISTS (SELECT 1 FROM products WHERE id = $1 AND deletedat IS NULL)
+		ORDER BY position
+		LIMIT 1
+	`, productId).Scan(append(imageScanTargets(&image), &version)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ProductImage{}, 0, ErrImageNotFound
+	}
+	return image, version, err
+}
+
+// querier is a pool or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func listImages(ctx context.Context, q querier, productId int) ([]model.ProductImage, error) {
+	rows, err := q.Query(ctx,
+		`SELECT `+imageColumns+` FROM productimages WHERE productid = $1 ORDER BY position`, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []model.ProductImage{}
+	for rows.Next() {
+		var i model.ProductImage
+		if err = rows.Scan(imageScanTargets(&i)...); err != nil {
+			return nil, err
+		}
+		images = append(images, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// lockLaptopForImages serialises image changes of one live laptop
+func lockLaptopForImages(ctx context.Context, tx pgx.Tx, productId int) error {
+	var id int
+	err := tx.QueryRow(ctx,
+		`SELECT id FROM products WHERE id = $1 AND deletedat IS NULL FOR UPDATE`, productId).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// syncCoverImage points products.imageurl at the first image and bumps the version, images are part
+// of the product's ETag. If no image is left and imageurl still names removedUrl it is cleared.
+func syncCoverImage(ctx context.Context, tx pgx.Tx, productId int, removedUrl string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE products SET
+			imageurl = COALESCE(
+				(SELECT url FROM productimages WHERE productid = $1 ORDER BY position LIMIT 1),
+				NULLIF(imageurl, $2),
+				''),
+			version = version + 1,
+			updatedat = NOW()
+		WHERE id = $1
+	`, productId, removedUrl)
+	return err
+}
+
+// AddProductImages appends images, already stored under their keys, after the laptop's existing ones
+// and returns all of its images. Thumbnails start pending.
+func AddProductImages(pool *pgxpool.Pool, productId int, images []model.ProductImage) ([]model.ProductImage, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockLaptopForImages(ctx, tx, productId); err != nil {
+		return nil, err
+	}
+	var count int
+	if err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM productimages WHERE productid = $1`, productId).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count+len(images) > MaxProductImages {
+		return nil, ErrTooManyImages
+	}
+	for n, i := range images {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO productimages (productid, position, storagekey, url, contenttype, width, height, bytes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, productId, count+n, i.Key, i.Url, i.Content_type, i.Width, i.Height, i.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = syncCoverImage(ctx, tx, productId, ""); err != nil {
+		return nil, err
+	}
+	all, err := listImages(ctx, tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	return all, tx.Commit(ctx)
+}
+
+// DeleteProductImage removes one image, closing the gap in positions, and returns it so its files can be deleted
+func DeleteProductImage(pool *pgxpool.Pool, productId int, imageId int) (image model.ProductImage, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.ProductImage{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockLaptopForImages(ctx, tx, productId); err != nil {
+		return model.ProductImage{}, err
+	}
+	err = tx.QueryRow(ctx,
+		`DELETE FROM productimages WHERE id = $1 AND productid = $2 RETURNING `+imageColumns,
+		imageId, productId).Scan(imageScanTargets(&image)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ProductImage{}, ErrImageNotFound
+	}
+	if err != nil {
+		return model.ProductImage{}, err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE productimages SET position = position - 1 WHERE productid = $1 AND position > $2`,
+		productId, image.Position)
+	if err != nil {
+		return model.ProductImage{}, err
+	}
+	if err = syncCoverImage(ctx, tx, productId, image.Url); err != nil {
+		return model.ProductImage{}, err
+	}
+	return image, tx.Commit(ctx)
+}
+
+// ReorderProductImages sets the display order; imageIds must hold every image of the laptop once
+func ReorderProductImages(pool *pgxpool.Pool, productId int, imageIds []int) ([]model.ProductImage, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockLaptopForImages(ctx, tx, productId); err != nil {
+		return nil, err
+	}
+	current, err := listImages(ctx, tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	existing := make([]int, len(current))
+	for n, i := range current {
+		existing[n] = i.Id
+	}
+	wanted := slices.Clone(imageIds)
+	slices.Sort(existing)
+	slices.Sort(wanted)
+	if !slices.Equal(existing, wanted) {
+		return nil, ErrImageOrderMismatch
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE productimages p SET position = o.ord - 1
+		FROM unnest($2::INTEGER[]) WITH ORDINALITY AS o(id, ord)
+		WHERE p.id = o.id AND p.productid = $1
+	`, productId, imageIds)
+	if err != nil {
+		return nil, err
+	}
+	if err = syncCoverImage(ctx, tx, productId, ""); err != nil {
+		return nil, err
+	}
+	all, err := listImages(ctx, tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	return all, tx.Commit(ctx)
+}
+
+// staleClaim is how long a worker may hold an image before another one takes it over
+const staleClaim = `INTERVAL '10 minutes'`
+
+// ClaimThumbnailJob marks an image as being processed by the caller. ok is false if it is done,
+// gone or another worker is on it.
+func ClaimThumbnailJob(pool *pgxpool.Pool, imageId int) (image model.ProductImage, ok bool, err error) {
+	err = pool.QueryRow(context.Background(), `
+		UPDATE productimages SET thumbnailstatus = 'processing', thumbnailclaimedat = NOW()
+		WHERE id = $1 AND (thumbnailstatus = 'pending'
+			OR (thumbnailstatus = 'processing' AND thumbnailclaimedat < NOW() - `+staleClaim+`))
+		RETURNING `+imageColumns,
+		imageId).Scan(imageScanTargets(&image)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ProductImage{}, false, nil
+	}
+	if err != nil {
+		return model.ProductImage{}, false, err
+	}
+	return image, true, nil
+}
+
+// CompleteThumbnailJob records the generated thumbnails, ErrImageNotFound if the image was deleted meanwhile
+func CompleteThumbnailJob(pool *pgxpool.Pool, imageId int, thumbnails map[string]string) error {
+	result, err := pool.Exec(context.Background(),
+		`UPDATE productimages SET thumbnailstatus = 'ready', thumbnails = $2, thumbnailclaimedat = NULL WHERE id = $1`,
+		imageId, thumbnails)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// FailThumbnailJob marks an image whose thumbnails can't be made, it is not retried
+func FailThumbnailJob(pool *pgxpool.Pool, imageId int) error {
+	_, err := pool.Exec(context.Background(),
+		`UPDATE productimages SET thumbnailstatus = 'failed', thumbnailclaimedat = NULL WHERE id = $1`, imageId)
+	return err
+}
+
+// PendingThumbnailJobs returns up to limit images still waiting for thumbnails, oldest first,
+// including those claimed by a worker that seems to have died
+func PendingThumbnailJobs(pool *pgxpool.Pool, limit int) ([]int, error) {
+	rows, err := pool.Query(context.Background(), `
+		SELECT id FROM productimages
+		WHERE thumbnailstatus = 'pending'
+			OR (thumbnailstatus = 'processing' AND thumbnailclaimedat < NOW() - `+staleClaim+`)
+		ORDER BY id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,189 @@
+// Defines Queries/Db operations related to bulk product imports
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Import outcomes of a row
+const (
+	ImportCreated   = "create"
+	ImportUpdated   = "update"
+	ImportUnchanged = "unchanged"
+)
+
+// LaptopImport is one row of a bulk import, matched to existing laptops by Laptop.Sku
+type LaptopImport struct {
+	Laptop model.Laptop
+	Stock  *int //nil keeps the stock of an existing laptop, new ones start at 0
+}
+
+// ImportOutcome is what happened to one row
+type ImportOutcome struct {
+	Id     int
+	Action string
+}
+
+// ImportRowError is the row an import failed on. The whole import is rolled back.
+type ImportRowError struct {
+	Index int //position in the rows passed to ImportLaptops
+	Err   error
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+func (e *ImportRowError) Unwrap() error {
+	return e.Err
+}
+
+// LaptopsForImport returns the live laptops, with their units in stock, that have one of the skus
+// or one of the names: those an import could update or collide with on the name, price and
+// operating system unique index
+func LaptopsForImport(pool *pgxpool.Pool, skus []string, names []string) ([]model.Laptop, error) {
+	rows, err := pool.Query(context.Background(),
+		`SELECT `+laptopColumns+`, instock FROM products
+		WHERE (sku = ANY($1) OR name = ANY($2)) AND deletedat IS NULL`, skus, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var laptops []model.Laptop
+	for rows.Next() {
+		var lp model.Laptop
+		if err = rows.Scan(append(laptopScanTargets(&lp), &lp.In_stock)...); err != nil {
+			return nil, err
+		}
+		laptops = append(laptops, lp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return laptops, nil
+}
+
+// Rows whose fields all match are left alone, so their version and ETag don't change
+const upsertLaptopStmt = `
+	INSERT INTO products (sku, name, brand, operatingsystem, operatingsystemversion,
+		hdd, ssd, hddsize, ssdsize, ramsize,
+		cpumaker, cpugen, cpumodel, yom, imageurl, price, screensize,
+		hasgpu, gpumake, gpumaker, hasigpu, isinstock, instock)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+		$18, $19, $20, $21, $22, COALESCE($23::INTEGER, 0))
+	ON CONFLICT (sku) WHERE deletedat IS NULL DO UPDATE SET
+		name = EXCLUDED.name,
+		brand = EXCLUDED.brand,
+		operatingsystem = EXCLUDED.operatingsystem,
+		operatingsystemversion = EXCLUDED.operatingsystemversion,
+		hdd = EXCLUDED.hdd,
+		ssd = EXCLUDED.ssd,
+		hddsize = EXCLUDED.hddsize,
+		ssdsize = EXCLUDED.ssdsize,
+		ramsize = EXCLUDED.ramsize,
+		cpumaker = EXCLUDED.cpumaker,
+		cpugen = EXCLUDED.cpugen,
+		cpumodel = EXCLUDED.cpumodel,
+		yom = EXCLUDED.yom,
+		imageurl = EXCLUDED.imageurl,
+		price = EXCLUDED.price,
+		screensize = EXCLUDED.screensize,
+		hasgpu = EXCLUDED.hasgpu,
+		gpumake = EXCLUDED.gpumake,
+		gpumaker = EXCLUDED.gpumaker,
+		hasigpu = EXCLUDED.hasigpu,
+		isinstock = EXCLUDED.isinstock,
+		instock = COALESCE($23::INTEGER, products.instock),
+		version = products.version + 1,
+		updatedat = NOW()
+	WHERE (products.name, products.brand, products.operatingsystem, products.operatingsystemversion,
+		products.hdd, products.ssd, products.hddsize, products.ssdsize, products.ramsize,
+		products.cpumaker, products.cpugen, products.cpumodel, products.yom, products.imageurl,
+		products.price, products.screensize, products.hasgpu, products.gpumake, products.gpumaker,
+		products.hasigpu, products.isinstock, products.instock)
+	IS DISTINCT FROM (EXCLUDED.name, EXCLUDED.brand, EXCLUDED.operatingsystem, EXCLUDED.operatingsystemversion,
+		EXCLUDED.hdd, EXCLUDED.ssd, EXCLUDED.hddsize, EXCLUDED.ssdsize, EXCLUDED.ramsize,
+		EXCLUDED.cpumaker, EXCLUDED.cpugen, EXCLUDED.cpumodel, EXCLUDED.yom, EXCLUDED.imageurl,
+		EXCLUDED.price, EXCLUDED.screensize, EXCLUDED.hasgpu, EXCLUDED.gpumake, EXCLUDED.gpumaker,
+		EXCLUDED.hasigpu, EXCLUDED.isinstock, COALESCE($23::INTEGER, products.instock))
+	RETURNING id, xmax = 0
+`
+
+// ImportLaptops creates or updates every row by sku in one transaction, sending batchSize rows
+// at a time and calling progress with the number of rows done after each batch. Nothing is
+// saved if a row fails, the error is then an *ImportRowError.
+func ImportLaptops(pool *pgxpool.Pool, rows []LaptopImport, batchSize int, progress func(done int)) (outcomes []ImportOutcome, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	outcomes = make([]ImportOutcome, len(rows))
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+		batch := &pgx.Batch{}
+		for _, row := range rows[start:end] {
+			lp := row.Laptop
+			batch.Queue(upsertLaptopStmt,
+				lp.Sku,
+				lp.Name,
+				lp.Brand,
+				lp.Operating_system,
+				lp.Operating_system_version,
+				lp.HDD,
+				lp.SSD,
+				lp.HDD_size,
+				lp.SSD_size,
+				lp.Ram_size,
+				lp.CPU_maker,
+				lp.CPU_gen,
+				lp.CPU_model,
+				lp.YOM,
+				lp.Image_url,
+				lp.Price,
+				lp.Screen_size,
+				lp.Has_gpu,
+				lp.Gpu_make,
+				lp.Gpu_maker,
+				lp.Has_igpu,
+				lp.Is_in_stock,
+				row.Stock,
+			)
+		}
+		results := tx.SendBatch(ctx, batch)
+		for i := start; i < end; i++ {
+			var inserted bool
+			err = results.QueryRow().Scan(&outcomes[i].Id, &inserted)
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				outcomes[i].Action = ImportUnchanged
+			case err != nil:
+				results.Close()
+				return nil, &ImportRowError{Index: i, Err: productConflict(err)}
+			case inserted:
+				outcomes[i].Action = ImportCreated
+			default:
+				outcomes[i].Action = ImportUpdated
+			}
+		}
+		if err = results.Close(); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(end)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
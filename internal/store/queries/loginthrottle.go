@@ -0,0 +1,60 @@
+// Defines Queries/Db operations related to login failure throttling
+package queries
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetLoginThrottle returns the failure count, last failure and block end of a key, zero values if it has none
+func GetLoginThrottle(pool *pgxpool.Pool, key string) (failures int, lastFailure time.Time, blockedUntil time.Time, err error) {
+	err = pool.QueryRow(context.Background(), `
+	SELECT failures, lastfailureat, COALESCE(blockeduntil, 'epoch') FROM loginthrottle WHERE key = $1
+	`, key).Scan(&failures, &lastFailure, &blockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, time.Time{}, time.Time{}, nil
+	}
+	return failures, lastFailure, blockedUntil, err
+}
+
+// FailLoginThrottle counts a failure for a key, starting over when the last failure is before resetBefore
+func FailLoginThrottle(pool *pgxpool.Pool, key string, now time.Time, resetBefore time.Time) (failures int, blockedUntil time.Time, err error) {
+	stmt := `
+	INSERT INTO loginthrottle (key, failures, lastfailureat)
+	VALUES ($1, 1, $2)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN loginthrottle.lastfailureat < $3 THEN 1 ELSE loginthrottle.failures + 1 END,
+		lastfailureat = $2
+	RETURNING failures, COALESCE(blockeduntil, 'epoch')
+	`
+	err = pool.QueryRow(context.Background(), stmt, key, now, resetBefore).Scan(&failures, &blockedUntil)
+	return failures, blockedUntil, err
+}
+
+// BlockLoginThrottle rejects attempts on a key until the given time
+func BlockLoginThrottle(pool *pgxpool.Pool, key string, until time.Time) error {
+	_, err := pool.Exec(context.Background(), `UPDATE loginthrottle SET blockeduntil = $2 WHERE key = $1`, key, until)
+	return err
+}
+
+// ResetLoginThrottle forgets the failures and block of a key
+func ResetLoginThrottle(pool *pgxpool.Pool, key string) error {
+	_, err := pool.Exec(context.Background(), `DELETE FROM loginthrottle WHERE key = $1`, key)
+	return err
+}
+
+// PruneLoginThrottle deletes keys whose last failure and block both ended before the given time
+func PruneLoginThrottle(pool *pgxpool.Pool, before time.Time) (int64, error) {
+	result, err := pool.Exec(context.Background(), `
+	DELETE FROM loginthrottle
+	WHERE lastfailureat < $1 AND (blockeduntil IS NULL OR blockeduntil < $1)
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,399 @@
+// Defines Queries/Db operations related to orders and checkout
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrCartEmpty         = errors.New("cart is empty")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrInvalidTransition = errors.New("invalid order status transition")
+)
+
+// OrderActor identifies who changed an order. Id is nil for system actors.
+type OrderActor struct {
+	Type string
+	Id   *int
+}
+
+// StockShortage describes a cart line that can no longer be fulfilled
+type StockShortage struct {
+	Product_id int    `json:"product_id"`
+	Name       string `json:"name"`
+	Requested  int    `json:"requested"`
+	Available  int    `json:"available"`
+}
+
+// StockError is returned by checkout when one or more lines exceed the remaining stock.
+// errors.Is(err, ErrInsufficientStock) holds for it.
+type StockError struct {
+	Shortages []StockShortage
+}
+
+func (e *StockError) Error() string {
+	ids := make([]string, len(e.Shortages))
+	for i, s := range e.Shortages {
+		ids[i] = fmt.Sprint(s.Product_id)
+	}
+	return "insufficient stock for products " + strings.Join(ids, ",")
+}
+
+func (e *StockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// CreateOrderFromCart turns the user's cart into a pending order in a single transaction.
+//
+// The cart and every product in it are locked (products in id order so concurrent
+// checkouts cannot deadlock), stock is verified and decremented, products that hit
+// zero are flagged out of stock, prices and specs are snapshotted into the order lines
+// and the cart is emptied. Nothing is written unless every line can be fulfilled.
+func CreateOrderFromCart(pool *pgxpool.Pool, userId int, shipping model.ShippingDetails) (order model.Order, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var cartId int
+	err = tx.QueryRow(ctx, `SELECT id FROM carts WHERE userid = $1 FOR UPDATE`, userId).Scan(&cartId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Order{}, ErrCartEmpty
+		}
+		return model.Order{}, err
+	}
+
+	quantities := map[int]int{}
+	rows, err := tx.Query(ctx, `SELECT productid, quantity FROM cartitems WHERE cartid = $1`, cartId)
+	if err != nil {
+		return model.Order{}, err
+	}
+	var productIds []int
+	for rows.Next() {
+		var productId, quantity int
+		if err = rows.Scan(&productId, &quantity); err != nil {
+			rows.Close()
+			return model.Order{}, err
+		}
+		quantities[productId] = quantity
+		productIds = append(productIds, productId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return model.Order{}, err
+	}
+	if len(productIds) == 0 {
+		return model.Order{}, ErrCartEmpty
+	}
+
+	stmt := fmt.Sprintf(`
+		SELECT %s, instock
+		FROM products
+		WHERE id = ANY($1) AND deletedat IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, laptopColumns)
+	rows, err = tx.Query(ctx, stmt, productIds)
+	if err != nil {
+		return model.Order{}, err
+	}
+	var laptops []model.Laptop
+	for rows.Next() {
+		var lp model.Laptop
+		if err = rows.Scan(append(laptopScanTargets(&lp), &lp.In_stock)...); err != nil {
+			rows.Close()
+			return model.Order{}, err
+		}
+		laptops = append(laptops, lp)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return model.Order{}, err
+	}
+
+	stockErr := &StockError{}
+	for _, lp := range laptops {
+		available := lp.In_stock
+		if !lp.Is_in_stock {
+			available = 0
+		}
+		if quantities[lp.Id] > available {
+			stockErr.Shortages = append(stockErr.Shortages, StockShortage{
+				Product_id: lp.Id,
+				Name:       lp.Name,
+				Requested:  quantities[lp.Id],
+				Available:  available,
+			})
+		}
+	}
+	if len(laptops) != len(productIds) {
+		// a product was removed from the catalog while in the cart
+		found := map[int]bool{}
+		for _, lp := range laptops {
+			found[lp.Id] = true
+		}
+		for _, id := range productIds {
+			if !found[id] {
+				stockErr.Shortages = append(stockErr.Shortages, StockShortage{Product_id: id, Requested: quantities[id]})
+			}
+		}
+	}
+	if len(stockErr.Shortages) > 0 {
+		return model.Order{}, stockErr
+	}
+
+	for _, lp := range laptops {
+		order.Items = append(order.Items, model.OrderItem{
+			Product_id: lp.Id,
+			Name:       lp.Name,
+			Brand:      lp.Brand,
+			Unit_price: lp.Price,
+			Quantity:   quantities[lp.Id],
+			Line_total: lp.Price * float64(quantities[lp.Id]),
+		})
+		order.Total += lp.Price * float64(quantities[lp.Id])
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO orders (userid, total, shippingname, shippingphone, shippingaddress, shippingcity)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, createdat, updatedat
+	`, userId, order.Total, shipping.Name, shipping.Phone, shipping.Address, shipping.City,
+	).Scan(&order.Id, &order.Status, &order.Created_at, &order.Updated_at)
+	if err != nil {
+		return model.Order{}, err
+	}
+	err = recordOrderEvent(ctx, tx, order.Id, nil, order.Status, OrderActor{Type: model.ActorUser, Id: &userId}, "checkout")
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	for i, lp := range laptops {
+		specs, err := json.Marshal(lp)
+		if err != nil {
+			return model.Order{}, err
+		}
+		order.Items[i].Specs = specs
+		_, err = tx.Exec(ctx, `
+			INSERT INTO orderitems (orderid, productid, name, brand, unitprice, quantity, specs)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, order.Id, lp.Id, lp.Name, lp.Brand, lp.Price, quantities[lp.Id], specs)
+		if err != nil {
+			return model.Order{}, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE products
+			SET instock = instock - $2, isinstock = instock - $2 > 0, updatedat = NOW()
+			WHERE id = $1
+		`, lp.Id, quantities[lp.Id])
+		if err != nil {
+			return model.Order{}, err
+		}
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM cartitems WHERE cartid = $1`, cartId); err != nil {
+		return model.Order{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return model.Order{}, err
+	}
+
+	order.User_id = userId
+	order.Shipping = shipping
+	return order, nil
+}
+
+func recordOrderEvent(ctx context.Context, tx pgx.Tx, orderId int, from *string, to string, actor OrderActor, note string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO orderhistory (orderid, fromstatus, tostatus, actortype, actorid, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, orderId, from, to, actor.Type, actor.Id, note)
+	return err
+}
+
+// restocks reports whether moving an order from one status to another puts its units back on sale
+func restocks(from, to string) bool {
+	if to == model.OrderCancelled {
+		return true
+	}
+	return to == model.OrderRefunded && (from == model.OrderPaid || from == model.OrderPacked)
+}
+
+// TransitionOrder moves an order to a new status if the state machine allows it and records
+// the change in the order history. Cancelling, or refunding before shipping, returns the
+// ordered units to stock.
+func TransitionOrder(pool *pgxpool.Pool, orderId int, to string, actor OrderActor, note string) (model.Order, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = transitionOrderTx(ctx, tx, orderId, to, actor, note); err != nil {
+		return model.Order{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return model.Order{}, err
+	}
+	return GetOrder(pool, orderId)
+}
+
+// transitionOrderTx is TransitionOrder inside a caller-owned transaction
+func transitionOrderTx(ctx context.Context, tx pgx.Tx, orderId int, to string, actor OrderActor, note string) error {
+	var from string
+	err := tx.QueryRow(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderId).Scan(&from)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+	if !model.CanTransitionOrder(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	if restocks(from, to) {
+		// lock in id order, like checkout, so the two can't deadlock
+		_, err = tx.Exec(ctx, `
+			SELECT id FROM products
+			WHERE id IN (SELECT productid FROM orderitems WHERE orderid = $1)
+			ORDER BY id
+			FOR UPDATE
+		`, orderId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE products p
+			SET instock = p.instock + oi.quantity, isinstock = TRUE, updatedat = NOW()
+			FROM orderitems oi
+			WHERE oi.orderid = $1 AND p.id = oi.productid
+		`, orderId)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE orders SET status = $2, updatedat = NOW() WHERE id = $1`, orderId, to)
+	if err != nil {
+		return err
+	}
+	return recordOrderEvent(ctx, tx, orderId, &from, to, actor, note)
+}
+
+const orderColumns = `id, userid, status, total, shippingname, shippingphone, shippingaddress, shippingcity, createdat, updatedat`
+
+func orderScanTargets(o *model.Order) []any {
+	return []any{
+		&o.Id,
+		&o.User_id,
+		&o.Status,
+		&o.Total,
+		&o.Shipping.Name,
+		&o.Shipping.Phone,
+		&o.Shipping.Address,
+		&o.Shipping.City,
+		&o.Created_at,
+		&o.Updated_at,
+	}
+}
+
+// GetOrder loads an order with its lines and status history
+func GetOrder(pool *pgxpool.Pool, orderId int) (order model.Order, err error) {
+	ctx := context.Background()
+	err = pool.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, orderId).Scan(orderScanTargets(&order)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Order{}, ErrOrderNotFound
+		}
+		return model.Order{}, err
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT COALESCE(productid, 0), name, brand, unitprice, quantity, specs
+		FROM orderitems WHERE orderid = $1 ORDER BY id
+	`, orderId)
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item model.OrderItem
+		if err = rows.Scan(&item.Product_id, &item.Name, &item.Brand, &item.Unit_price, &item.Quantity, &item.Specs); err != nil {
+			return model.Order{}, err
+		}
+		item.Line_total = item.Unit_price * float64(item.Quantity)
+		order.Items = append(order.Items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return model.Order{}, err
+	}
+
+	rows, err = pool.Query(ctx, `
+		SELECT fromstatus, tostatus, actortype, actorid, note, createdat
+		FROM orderhistory WHERE orderid = $1 ORDER BY createdat, id
+	`, orderId)
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e model.OrderEvent
+		if err = rows.Scan(&e.From, &e.To, &e.Actor_type, &e.Actor_id, &e.Note, &e.Created_at); err != nil {
+			return model.Order{}, err
+		}
+		order.History = append(order.History, e)
+	}
+	if err = rows.Err(); err != nil {
+		return model.Order{}, err
+	}
+	return order, nil
+}
+
+// OrderFilter narrows ListOrders. Zero values mean "any".
+type OrderFilter struct {
+	Status string
+	UserId int
+	Limit  int
+	Offset int
+}
+
+// ListOrders returns a page of orders, newest first, and the total number of matches
+func ListOrders(pool *pgxpool.Pool, f OrderFilter) (orders []model.Order, total int, err error) {
+	stmt := `
+		SELECT ` + orderColumns + `, COUNT(*) OVER()
+		FROM orders
+		WHERE ($1 = '' OR status = $1) AND ($2 = 0 OR userid = $2)
+		ORDER BY createdat DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := pool.Query(context.Background(), stmt, f.Status, f.UserId, f.Limit, f.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o model.Order
+		if err = rows.Scan(append(orderScanTargets(&o), &total)...); err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return orders, total, nil
+}
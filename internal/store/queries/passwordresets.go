@@ -0,0 +1,70 @@
+// Defines Queries/Db operations related to password resets
+package queries
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrResetTokenInvalid = errors.New("password reset token is invalid, used or expired")
+
+// CreatePasswordReset stores a hashed reset token; earlier unused tokens of the user stop working
+func CreatePasswordReset(pool *pgxpool.Pool, userId int, tokenHash string, expiresAt time.Time) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE passwordresets SET usedat = NOW() WHERE userid = $1 AND usedat IS NULL`, userId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+	INSERT INTO passwordresets (userid, tokenhash, expiresat)
+	VALUES ($1, $2, $3)
+	`, userId, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ConsumePasswordReset uses up a reset token and sets the user's new password hash
+func ConsumePasswordReset(pool *pgxpool.Pool, tokenHash string, passwordHash string) (userId int, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var resetId int
+	err = tx.QueryRow(ctx, `
+	SELECT id, userid FROM passwordresets
+	WHERE tokenhash = $1 AND usedat IS NULL AND expiresat > NOW()
+	FOR UPDATE
+	`, tokenHash).Scan(&resetId, &userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrResetTokenInvalid
+		}
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE passwordresets SET usedat = NOW() WHERE id = $1`, resetId); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET passwordhash = $2, updatedat = NOW() WHERE id = $1`, userId, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
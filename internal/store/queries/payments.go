@@ -0,0 +1,230 @@
+// Defines Queries/Db operations related to order payments
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lapbytes/internal/model"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrPaymentNotPaid  = errors.New("payment has not succeeded")
+	ErrPaymentOpen     = errors.New("a payment for this order is already in progress")
+)
+
+const paymentColumns = `id, orderid, provider, reference, receipt, amount, phone, status, message, createdat, updatedat`
+
+func paymentScanTargets(p *model.Payment) []any {
+	return []any{
+		&p.Id,
+		&p.Order_id,
+		&p.Provider,
+		&p.Reference,
+		&p.Receipt,
+		&p.Amount,
+		&p.Phone,
+		&p.Status,
+		&p.Message,
+		&p.Created_at,
+		&p.Updated_at,
+	}
+}
+
+// InsertPayment records a payment started with a provider
+func InsertPayment(pool *pgxpool.Pool, p model.Payment) (model.Payment, error) {
+	stmt := `
+	INSERT INTO payments (orderid, provider, reference, receipt, amount, phone, status, message)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING ` + paymentColumns
+	var out model.Payment
+	err := pool.QueryRow(context.Background(), stmt,
+		p.Order_id, p.Provider, p.Reference, p.Receipt, p.Amount, p.Phone, p.Status, p.Message,
+	).Scan(paymentScanTargets(&out)...)
+	if err != nil {
+		return model.Payment{}, err
+	}
+	return out, nil
+}
+
+// StartPayment records a payment as initiating before the provider is asked for it, with a
+// placeholder reference. It fails with ErrPaymentOpen if the order already has an initiating or
+// pending payment, so concurrent requests can't both start one.
+func StartPayment(pool *pgxpool.Pool, p model.Payment) (model.Payment, error) {
+	p.Reference = "initiating-" + uuid.NewString()
+	p.Status = model.PaymentInitiating
+	out, err := InsertPayment(pool, p)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "idx_payments_one_open" {
+		return model.Payment{}, ErrPaymentOpen
+	}
+	return out, err
+}
+
+// PaymentInitiated moves a payment from StartPayment to pending under the provider's reference
+func PaymentInitiated(pool *pgxpool.Pool, paymentId int, reference, message string) (p model.Payment, err error) {
+	err = pool.QueryRow(context.Background(), `
+		UPDATE payments SET status = $2, reference = $3, message = $4, updatedat = NOW()
+		WHERE id = $1 AND status = $5
+		RETURNING `+paymentColumns,
+		paymentId, model.PaymentPending, reference, message, model.PaymentInitiating,
+	).Scan(paymentScanTargets(&p)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Payment{}, ErrPaymentNotFound
+	}
+	return p, err
+}
+
+// AbandonPayment fails a payment from StartPayment that has been initiating for longer than
+// olderThan, e.g. the provider refused it or the server stopped before it answered. It returns
+// ErrPaymentNotFound if there is no such payment.
+func AbandonPayment(pool *pgxpool.Pool, paymentId int, message string, olderThan time.Duration) (p model.Payment, err error) {
+	err = pool.QueryRow(context.Background(), `
+		UPDATE payments SET status = $2, message = $3, updatedat = NOW()
+		WHERE id = $1 AND status = $4 AND createdat <= NOW() - make_interval(secs => $5)
+		RETURNING `+paymentColumns,
+		paymentId, model.PaymentFailed, message, model.PaymentInitiating, olderThan.Seconds(),
+	).Scan(paymentScanTargets(&p)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Payment{}, ErrPaymentNotFound
+	}
+	return p, err
+}
+
+// GetLatestOrderPayment returns the most recent payment attempt for an order
+func GetLatestOrderPayment(pool *pgxpool.Pool, orderId int) (p model.Payment, err error) {
+	stmt := `SELECT ` + paymentColumns + ` FROM payments WHERE orderid = $1 ORDER BY createdat DESC, id DESC LIMIT 1`
+	err = pool.QueryRow(context.Background(), stmt, orderId).Scan(paymentScanTargets(&p)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Payment{}, ErrPaymentNotFound
+		}
+		return model.Payment{}, err
+	}
+	return p, nil
+}
+
+// PaymentUpdate reports the effect of ApplyPaymentResult
+type PaymentUpdate struct {
+	Payment   model.Payment
+	Applied   bool //false when the payment was already settled, e.g. a repeated callback
+	OrderPaid bool //true when the order moved to paid
+}
+
+// ApplyPaymentResult settles a pending payment with the provider's final status. It is
+// idempotent: results for a payment that is no longer pending change nothing. A succeeded
+// payment moves its order from pending_payment to paid in the same transaction.
+func ApplyPaymentResult(pool *pgxpool.Pool, provider, reference, status, receipt, message string) (update PaymentUpdate, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return update, err
+	}
+	defer tx.Rollback(ctx)
+
+	p := &update.Payment
+	err = tx.QueryRow(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE provider = $1 AND reference = $2 FOR UPDATE`,
+		provider, reference,
+	).Scan(paymentScanTargets(p)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return update, ErrPaymentNotFound
+		}
+		return update, err
+	}
+	if p.Status != model.PaymentPending || status == model.PaymentPending {
+		return update, nil
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE payments SET status = $2, receipt = COALESCE(NULLIF($3, ''), receipt), message = $4, updatedat = NOW()
+		WHERE id = $1
+		RETURNING `+paymentColumns,
+		p.Id, status, receipt, message,
+	).Scan(paymentScanTargets(p)...)
+	if err != nil {
+		return update, err
+	}
+	update.Applied = true
+
+	if status == model.PaymentSucceeded {
+		var orderStatus string
+		err = tx.QueryRow(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, p.Order_id).Scan(&orderStatus)
+		if err != nil {
+			return update, err
+		}
+		// a late payment for a cancelled order is recorded but needs a manual refund
+		if orderStatus == model.OrderPendingPayment {
+			note := fmt.Sprintf("payment %s %s", provider, reference)
+			if err = transitionOrderTx(ctx, tx, p.Order_id, model.OrderPaid, OrderActor{Type: model.ActorSystem}, note); err != nil {
+				return update, err
+			}
+			update.OrderPaid = true
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return update, err
+	}
+	return update, nil
+}
+
+// ClaimRefund moves a succeeded payment to refunding, so only one refund calls the provider for it.
+// It fails with ErrPaymentNotPaid if the payment isn't succeeded, e.g. another refund claimed it.
+func ClaimRefund(pool *pgxpool.Pool, paymentId int) error {
+	result, err := pool.Exec(context.Background(), `
+		UPDATE payments SET status = $2, updatedat = NOW()
+		WHERE id = $1 AND status = $3
+	`, paymentId, model.PaymentRefunding, model.PaymentSucceeded)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrPaymentNotPaid
+	}
+	return nil
+}
+
+// ReleaseRefund puts a payment claimed by ClaimRefund back to succeeded, after the provider refused the refund
+func ReleaseRefund(pool *pgxpool.Pool, paymentId int) error {
+	_, err := pool.Exec(context.Background(), `
+		UPDATE payments SET status = $2, updatedat = NOW()
+		WHERE id = $1 AND status = $3
+	`, paymentId, model.PaymentSucceeded, model.PaymentRefunding)
+	return err
+}
+
+// RefundOrderPayment marks a payment claimed by ClaimRefund refunded and moves its order to refunded
+func RefundOrderPayment(pool *pgxpool.Pool, paymentId int, actor OrderActor, note string) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var orderId int
+	err = tx.QueryRow(ctx, `
+		UPDATE payments SET status = $2, updatedat = NOW()
+		WHERE id = $1 AND status = $3
+		RETURNING orderid
+	`, paymentId, model.PaymentRefunded, model.PaymentRefunding).Scan(&orderId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrPaymentNotPaid
+		}
+		return err
+	}
+	if err = transitionOrderTx(ctx, tx, orderId, model.OrderRefunded, actor, note); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -3,8 +3,11 @@ package queries
 
 import (
 	"context"
+	"fmt"
 	"lapbytes/internal/model"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -13,8 +16,8 @@ func QueryLaptop(pool *pgxpool.Pool, id int) (laptop model.Laptop, err error) {
 	SELECT id, name, brand, operatingsystem, operatingsystemversion, 
            hdd, ssd, hddsize, ssdsize, ramsize, 
            cpumaker, cpugen, cpumodel, yom, imageurl, price, screensize,
-           hasgpu, gpumake, gpumaker, hasigpu, isinstock
-	FROM products WHERE id=$1
+           hasgpu, gpumake, gpumaker, hasigpu, isinstock, version, COALESCE(sku, '')
+	FROM products WHERE id=$1 AND deletedat IS NULL
 `
 
 	err = pool.QueryRow(context.Background(), stmt, id).Scan(
@@ -41,6 +44,8 @@ func QueryLaptop(pool *pgxpool.Pool, id int) (laptop model.Laptop, err error) {
 		&laptop.Gpu_maker,
 		&laptop.Has_igpu,
 		&laptop.Is_in_stock,
+		&laptop.Version,
+		&laptop.Sku,
 	)
 	if err != nil {
 		return model.Laptop{}, err
@@ -53,8 +58,9 @@ func QueryLaptops(pool *pgxpool.Pool, limit int, offset int) (laptops []model.La
 		SELECT id, name, brand, operatingsystem, operatingsystemversion, 
            hdd, ssd, hddsize, ssdsize, ramsize, 
            cpumaker, cpugen, cpumodel, yom, imageurl, price, screensize,
-           hasgpu, gpumake, gpumaker, hasigpu, isinstock
+           hasgpu, gpumake, gpumaker, hasigpu, isinstock, version, COALESCE(sku, '')
 		FROM products 
+		WHERE deletedat IS NULL
 		ORDER BY createdat DESC
 		LIMIT $1 OFFSET $2
 `
@@ -88,6 +94,8 @@ func QueryLaptops(pool *pgxpool.Pool, limit int, offset int) (laptops []model.La
 			&p.Gpu_maker,
 			&p.Has_igpu,
 			&p.Is_in_stock,
+			&p.Version,
+			&p.Sku,
 		)
 		if err != nil {
 			return nil, err
@@ -96,3 +104,430 @@ func QueryLaptops(pool *pgxpool.Pool, limit int, offset int) (laptops []model.La
 	}
 	return laptops, nil
 }
+
+// Markers wrapped around matched terms in LaptopSearchResult.Snippet.
+// They are plain text so callers can escape the snippet before turning them into markup.
+const (
+	HighlightStart = "\u27e6"
+	HighlightStop  = "\u27e7"
+)
+
+// LaptopSearchResult is a laptop matched by a full-text query together with its relevance
+type LaptopSearchResult struct {
+	model.Laptop
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// FullTextSearchLaptops ranks laptops against a free-text query such as "thinkpad i7 16gb".
+// Structured filters in f still apply; an empty f.Sort orders by relevance.
+func FullTextSearchLaptops(pool *pgxpool.Pool, text string, f LaptopFilter) (results []LaptopSearchResult, total int, err error) {
+	orderBy := "rank DESC, id DESC"
+	if f.Sort != "" {
+		var ok bool
+		if orderBy, ok = laptopSortClauses[f.Sort]; !ok {
+			return nil, 0, fmt.Errorf("unsupported sort %q", f.Sort)
+		}
+	}
+	where, args := buildLaptopWhere(f)
+	args = append(args, text)
+	queryArg := len(args)
+	args = append(args, "StartSel="+HighlightStart+", StopSel="+HighlightStop+", HighlightAll=true")
+	optsArg := len(args)
+	args = append(args, f.Limit, f.Offset)
+
+	// headlines are expensive, so only build them for the rows on the requested page
+	stmt := fmt.Sprintf(`
+		SELECT %[1]s, rank,
+		       ts_headline('simple', concat_ws(' ', name, brand, cpumodel, gpumake, operatingsystem), query, $%[2]d),
+		       total
+		FROM (
+			SELECT products.*, ts_rank_cd(searchvector, query) AS rank, query, COUNT(*) OVER() AS total
+			FROM products, websearch_to_tsquery('simple', $%[3]d) AS query
+			%[4]s AND searchvector @@ query
+			ORDER BY %[5]s
+			LIMIT $%[6]d OFFSET $%[7]d
+		) AS matched
+		ORDER BY %[5]s
+`, laptopColumns, optsArg, queryArg, where, orderBy, len(args)-1, len(args))
+
+	rows, err := pool.Query(context.Background(), stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res LaptopSearchResult
+		targets := append(laptopScanTargets(&res.Laptop), &res.Rank, &res.Snippet, &total)
+		if err = rows.Scan(targets...); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, res)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// LaptopFilter holds the optional criteria accepted by SearchLaptops.
+// Nil pointers and empty slices/strings mean "no constraint".
+type LaptopFilter struct {
+	Brands    []string
+	CPUMakers []string
+	GPUMakers []string
+	CPUModel  string
+	CPUGen    string
+	MinRam    *float64
+	MaxRam    *float64
+	MinSSD    *float64
+	MaxSSD    *float64
+	MinHDD    *float64
+	MaxHDD    *float64
+	MinScreen *float64
+	MaxScreen *float64
+	MinPrice  *float64
+	MaxPrice  *float64
+	HasGPU    *bool
+	InStock   *bool
+	Sort      string
+	Limit     int
+	Offset    int
+}
+
+// laptopSortClauses maps the public sort keys to ORDER BY clauses, never interpolate user input directly
+var laptopSortClauses = map[string]string{
+	"":           "createdat DESC, id DESC",
+	"newest":     "createdat DESC, id DESC",
+	"price_asc":  "price ASC, id ASC",
+	"price_desc": "price DESC, id DESC",
+	"name":       "name ASC, id ASC",
+}
+
+// ValidLaptopSort reports whether sort is a supported sort key
+func ValidLaptopSort(sort string) bool {
+	_, ok := laptopSortClauses[sort]
+	return ok
+}
+
+const laptopColumns = `id, name, brand, operatingsystem, operatingsystemversion,
+           hdd, ssd, hddsize, ssdsize, ramsize,
+           cpumaker, cpugen, cpumodel, yom, imageurl, price, screensize,
+           hasgpu, gpumake, gpumaker, hasigpu, isinstock, version, COALESCE(sku, '')`
+
+// laptopScanTargets returns scan destinations matching laptopColumns
+func laptopScanTargets(p *model.Laptop) []any {
+	return []any{
+		&p.Id,
+		&p.Name,
+		&p.Brand,
+		&p.Operating_system,
+		&p.Operating_system_version,
+		&p.HDD,
+		&p.SSD,
+		&p.HDD_size,
+		&p.SSD_size,
+		&p.Ram_size,
+		&p.CPU_maker,
+		&p.CPU_gen,
+		&p.CPU_model,
+		&p.YOM,
+		&p.Image_url,
+		&p.Price,
+		&p.Screen_size,
+		&p.Has_gpu,
+		&p.Gpu_make,
+		&p.Gpu_maker,
+		&p.Has_igpu,
+		&p.Is_in_stock,
+		&p.Version,
+		&p.Sku,
+	}
+}
+
+// buildLaptopWhere turns a filter into a WHERE clause and its positional args.
+// The returned clause always starts with "WHERE" so callers can append to it.
+func buildLaptopWhere(f LaptopFilter) (string, []any) {
+	conds := []string{"deletedat IS NULL"}
+	args := []any{}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if len(f.Brands) > 0 {
+		add("lower(brand) = ANY($%d)", lowerAll(f.Brands))
+	}
+	if len(f.CPUMakers) > 0 {
+		add("lower(cpumaker) = ANY($%d)", lowerAll(f.CPUMakers))
+	}
+	if len(f.GPUMakers) > 0 {
+		add("lower(gpumaker) = ANY($%d)", lowerAll(f.GPUMakers))
+	}
+	if f.CPUModel != "" {
+		add("cpumodel ILIKE $%d", "%"+escapeLike(f.CPUModel)+"%")
+	}
+	if f.CPUGen != "" {
+		add("cpugen ILIKE $%d", "%"+escapeLike(f.CPUGen)+"%")
+	}
+	ranges := []struct {
+		column string
+		min    *float64
+		max    *float64
+	}{
+		{"ramsize", f.MinRam, f.MaxRam},
+		{"ssdsize", f.MinSSD, f.MaxSSD},
+		{"hddsize", f.MinHDD, f.MaxHDD},
+		{"screensize", f.MinScreen, f.MaxScreen},
+		{"price", f.MinPrice, f.MaxPrice},
+	}
+	for _, r := range ranges {
+		if r.min != nil {
+			add(r.column+" >= $%d", *r.min)
+		}
+		if r.max != nil {
+			add(r.column+" <= $%d", *r.max)
+		}
+	}
+	if f.HasGPU != nil {
+		add("hasgpu = $%d", *f.HasGPU)
+	}
+	if f.InStock != nil {
+		add("isinstock = $%d", *f.InStock)
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+func lowerAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchLaptops returns a page of laptops matching the filter and the total number of matches
+func SearchLaptops(pool *pgxpool.Pool, f LaptopFilter) (laptops []model.Laptop, total int, err error) {
+	orderBy, ok := laptopSortClauses[f.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort %q", f.Sort)
+	}
+	where, args := buildLaptopWhere(f)
+	args = append(args, f.Limit, f.Offset)
+	stmt := fmt.Sprintf(`
+		SELECT %s, COUNT(*) OVER() AS total
+		FROM products
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+`, laptopColumns, where, orderBy, len(args)-1, len(args))
+
+	rows, err := pool.Query(context.Background(), stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.Laptop
+		if err = rows.Scan(append(laptopScanTargets(&p), &total)...); err != nil {
+			return nil, 0, err
+		}
+		laptops = append(laptops, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return laptops, total, nil
+}
+
+// EachLaptop calls each for every laptop matching the filter, in its sort order, as rows arrive
+// from the database instead of collecting them, so exports of the whole catalog use constant memory.
+// In_stock is set too. Limit and Offset are ignored. It stops at the first error each returns.
+func EachLaptop(pool *pgxpool.Pool, f LaptopFilter, each func(laptop model.Laptop) error) error {
+	orderBy, ok := laptopSortClauses[f.Sort]
+	if !ok {
+		return fmt.Errorf("unsupported sort %q", f.Sort)
+	}
+	where, args := buildLaptopWhere(f)
+	stmt := fmt.Sprintf(`
+		SELECT %s, instock
+		FROM products
+		%s
+		ORDER BY %s
+`, laptopColumns, where, orderBy)
+
+	rows, err := pool.Query(context.Background(), stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.Laptop
+		if err = rows.Scan(append(laptopScanTargets(&p), &p.In_stock)...); err != nil {
+			return err
+		}
+		if err = each(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// FacetValue is the number of matching laptops sharing one value of a column
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// FacetBucket counts laptops within [Min, Max). A nil Max is an open-ended bucket.
+type FacetBucket struct {
+	Label string   `json:"label"`
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// LaptopFacets is the filter sidebar document for a catalog query
+type LaptopFacets struct {
+	Total     int           `json:"total"`
+	Brand     []FacetValue  `json:"brand"`
+	CPUMaker  []FacetValue  `json:"cpu_maker"`
+	GPUMaker  []FacetValue  `json:"gpu_maker"`
+	RamSize   []FacetBucket `json:"ram_size"`
+	PriceBand []FacetBucket `json:"price"`
+}
+
+type bucketDef struct {
+	label string
+	min   float64
+}
+
+// Bucket lower bounds, each bucket ends where the next one starts.
+// Price bands follow the KSH ranges shown on the storefront.
+var (
+	ramBuckets = []bucketDef{
+		{"Under 8GB", 0},
+		{"8GB", 8},
+		{"16GB", 16},
+		{"32GB", 32},
+		{"64GB+", 64},
+	}
+	priceBuckets = []bucketDef{
+		{"Under KSH 65,000", 0},
+		{"KSH 65,000 - KSH 130,000", 65000},
+		{"KSH 130,000 - KSH 260,000", 130000},
+		{"Above KSH 260,000", 260000},
+	}
+)
+
+// QueryLaptopFacets counts laptops per facet value for the given filter.
+// Each facet ignores its own constraint so the sidebar keeps showing the alternatives
+// to whatever is currently selected; every other filter is applied.
+func QueryLaptopFacets(pool *pgxpool.Pool, f LaptopFilter) (facets LaptopFacets, err error) {
+	batch := &pgx.Batch{}
+
+	where, args := buildLaptopWhere(f)
+	batch.Queue(`SELECT COUNT(*) FROM products `+where, args...).QueryRow(func(row pgx.Row) error {
+		return row.Scan(&facets.Total)
+	})
+
+	valueFacets := []struct {
+		column string
+		clear  func(*LaptopFilter)
+		dest   *[]FacetValue
+	}{
+		{"brand", func(lf *LaptopFilter) { lf.Brands = nil }, &facets.Brand},
+		{"cpumaker", func(lf *LaptopFilter) { lf.CPUMakers = nil }, &facets.CPUMaker},
+		{"gpumaker", func(lf *LaptopFilter) { lf.GPUMakers = nil }, &facets.GPUMaker},
+	}
+	for _, vf := range valueFacets {
+		own := f
+		vf.clear(&own)
+		where, args := buildLaptopWhere(own)
+		stmt := fmt.Sprintf(`
+			SELECT %[1]s, COUNT(*)
+			FROM products
+			%[2]s AND %[1]s IS NOT NULL
+			GROUP BY %[1]s
+			ORDER BY COUNT(*) DESC, %[1]s ASC
+		`, vf.column, where)
+		dest := vf.dest
+		batch.Queue(stmt, args...).Query(func(rows pgx.Rows) error {
+			*dest = []FacetValue{}
+			for rows.Next() {
+				var v FacetValue
+				if err := rows.Scan(&v.Value, &v.Count); err != nil {
+					return err
+				}
+				*dest = append(*dest, v)
+			}
+			return rows.Err()
+		})
+	}
+
+	bucketFacets := []struct {
+		column string
+		defs   []bucketDef
+		clear  func(*LaptopFilter)
+		dest   *[]FacetBucket
+	}{
+		{"ramsize", ramBuckets, func(lf *LaptopFilter) { lf.MinRam, lf.MaxRam = nil, nil }, &facets.RamSize},
+		{"price", priceBuckets, func(lf *LaptopFilter) { lf.MinPrice, lf.MaxPrice = nil, nil }, &facets.PriceBand},
+	}
+	for _, bf := range bucketFacets {
+		own := f
+		bf.clear(&own)
+		where, args := buildLaptopWhere(own)
+		// width_bucket returns 1 for the first bound, 0 only for values below it
+		bounds := make([]float64, len(bf.defs))
+		for i, d := range bf.defs {
+			bounds[i] = d.min
+		}
+		args = append(args, bounds)
+		stmt := fmt.Sprintf(`
+			SELECT width_bucket(%s::float8, $%d::float8[]) AS bucket, COUNT(*)
+			FROM products
+			%s
+			GROUP BY bucket
+		`, bf.column, len(args), where)
+		defs, dest := bf.defs, bf.dest
+		batch.Queue(stmt, args...).Query(func(rows pgx.Rows) error {
+			*dest = newFacetBuckets(defs)
+			for rows.Next() {
+				var bucket, count int
+				if err := rows.Scan(&bucket, &count); err != nil {
+					return err
+				}
+				if bucket >= 1 && bucket <= len(defs) {
+					(*dest)[bucket-1].Count += count
+				}
+			}
+			return rows.Err()
+		})
+	}
+
+	if err = pool.SendBatch(context.Background(), batch).Close(); err != nil {
+		return LaptopFacets{}, err
+	}
+	return facets, nil
+}
+
+func newFacetBuckets(defs []bucketDef) []FacetBucket {
+	buckets := make([]FacetBucket, len(defs))
+	for i, d := range defs {
+		buckets[i] = FacetBucket{Label: d.label, Min: d.min}
+		if i+1 < len(defs) {
+			max := defs[i+1].min
+			buckets[i].Max = &max
+		}
+	}
+	return buckets
+}
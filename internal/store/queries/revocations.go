@@ -0,0 +1,101 @@
+// Defines Queries/Db operations related to access token revocation
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RevokedToken is a single revoked access token
+type RevokedToken struct {
+	Jti        string
+	Expires_at time.Time
+	Revoked_at time.Time
+}
+
+// UserRevocation rejects every access token of a user issued before Revoked_before
+type UserRevocation struct {
+	User_id        int
+	Revoked_before time.Time
+	Updated_at     time.Time
+}
+
+// RevokeToken adds an access token to the revocation list
+func RevokeToken(pool *pgxpool.Pool, jti string, userId int, expiresAt time.Time) error {
+	stmt := `
+	INSERT INTO revokedtokens (jti, userid, expiresat)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := pool.Exec(context.Background(), stmt, jti, userId, expiresAt)
+	return err
+}
+
+// RevokeUserTokens revokes every access token of a user issued before the given time
+func RevokeUserTokens(pool *pgxpool.Pool, userId int, before time.Time) error {
+	stmt := `
+	INSERT INTO userrevocations (userid, revokedbefore)
+	VALUES ($1, $2)
+	ON CONFLICT (userid) DO UPDATE
+	SET revokedbefore = GREATEST(userrevocations.revokedbefore, EXCLUDED.revokedbefore), updatedat = NOW()
+	`
+	_, err := pool.Exec(context.Background(), stmt, userId, before)
+	return err
+}
+
+// RevokedTokensSince returns unexpired revoked tokens recorded after since
+func RevokedTokensSince(pool *pgxpool.Pool, since time.Time) ([]RevokedToken, error) {
+	stmt := `
+	SELECT jti, expiresat, revokedat FROM revokedtokens
+	WHERE revokedat > $1 AND expiresat > NOW()
+	`
+	rows, err := pool.Query(context.Background(), stmt, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []RevokedToken
+	for rows.Next() {
+		var t RevokedToken
+		if err = rows.Scan(&t.Jti, &t.Expires_at, &t.Revoked_at); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// UserRevocationsSince returns user wide revocations changed after since
+func UserRevocationsSince(pool *pgxpool.Pool, since time.Time) ([]UserRevocation, error) {
+	stmt := `
+	SELECT userid, revokedbefore, updatedat FROM userrevocations
+	WHERE updatedat > $1
+	`
+	rows, err := pool.Query(context.Background(), stmt, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []UserRevocation
+	for rows.Next() {
+		var u UserRevocation
+		if err = rows.Scan(&u.User_id, &u.Revoked_before, &u.Updated_at); err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, u)
+	}
+	return revocations, rows.Err()
+}
+
+// PruneRevokedTokens deletes revocations of tokens that have expired on their own
+func PruneRevokedTokens(pool *pgxpool.Pool) (int64, error) {
+	result, err := pool.Exec(context.Background(), `DELETE FROM revokedtokens WHERE expiresat <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,133 @@
+// Defines Queries/Db operations related to refresh token sessions
+package queries
+
+import (
+	"context"
+	"errors"
+	"lapbytes/internal/model"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrSessionRevoked     = errors.New("session revoked")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+const sessionColumns = `id, userid, familyid, tokenhash, useragent, ip, expiresat, usedat, revokedat, mfa, createdat`
+
+func sessionScanTargets(s *model.Session) []any {
+	return []any{
+		&s.Id,
+		&s.User_id,
+		&s.Family_id,
+		&s.Token_hash,
+		&s.User_agent,
+		&s.Ip,
+		&s.Expires_at,
+		&s.Used_at,
+		&s.Revoked_at,
+		&s.Mfa,
+		&s.Created_at,
+	}
+}
+
+const insertSessionStmt = `
+	INSERT INTO sessions (userid, familyid, tokenhash, useragent, ip, expiresat, mfa)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + sessionColumns
+
+// CreateSession stores a new refresh token session, Token_hash must already be hashed
+func CreateSession(pool *pgxpool.Pool, s model.Session) (model.Session, error) {
+	var out model.Session
+	err := pool.QueryRow(context.Background(), insertSessionStmt,
+		s.User_id, s.Family_id, s.Token_hash, s.User_agent, s.Ip, s.Expires_at, s.Mfa,
+	).Scan(sessionScanTargets(&out)...)
+	if err != nil {
+		return model.Session{}, err
+	}
+	return out, nil
+}
+
+// RotateSession exchanges the session holding tokenHash for a new one in the same family.
+// Presenting a token that was already rotated revokes the whole family and returns
+// ErrRefreshTokenReused, since either the legitimate client or an attacker holds a stolen copy.
+func RotateSession(pool *pgxpool.Pool, tokenHash string, newHash string, expiresAt time.Time) (model.Session, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var current model.Session
+	err = tx.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE tokenhash = $1 FOR UPDATE`, tokenHash).
+		Scan(sessionScanTargets(&current)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Session{}, ErrSessionNotFound
+		}
+		return model.Session{}, err
+	}
+
+	switch {
+	case current.Revoked_at != nil:
+		return model.Session{}, ErrSessionRevoked
+	case current.Used_at != nil:
+		if err = revokeSessionFamily(ctx, tx, current.Family_id); err != nil {
+			return model.Session{}, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return model.Session{}, err
+		}
+		return current, ErrRefreshTokenReused
+	case time.Now().After(current.Expires_at):
+		return model.Session{}, ErrSessionExpired
+	}
+
+	var next model.Session
+	err = tx.QueryRow(ctx, insertSessionStmt,
+		current.User_id, current.Family_id, newHash, current.User_agent, current.Ip, expiresAt, current.Mfa,
+	).Scan(sessionScanTargets(&next)...)
+	if err != nil {
+		return model.Session{}, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE sessions SET usedat = NOW(), replacedby = $2 WHERE id = $1`, current.Id, next.Id)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return model.Session{}, err
+	}
+	return next, nil
+}
+
+func revokeSessionFamily(ctx context.Context, tx pgx.Tx, familyId string) error {
+	_, err := tx.Exec(ctx, `UPDATE sessions SET revokedat = NOW() WHERE familyid = $1 AND revokedat IS NULL`, familyId)
+	return err
+}
+
+// RevokeSessionByToken revokes the session family of a refresh token, e.g. on logout
+func RevokeSessionByToken(pool *pgxpool.Pool, tokenHash string) error {
+	stmt := `
+	UPDATE sessions SET revokedat = NOW()
+	WHERE revokedat IS NULL
+	AND familyid = (SELECT familyid FROM sessions WHERE tokenhash = $1)
+	`
+	_, err := pool.Exec(context.Background(), stmt, tokenHash)
+	return err
+}
+
+// RevokeUserSessions revokes every refresh session of a user
+func RevokeUserSessions(pool *pgxpool.Pool, userId int) (int64, error) {
+	result, err := pool.Exec(context.Background(),
+		`UPDATE sessions SET revokedat = NOW() WHERE userid = $1 AND revokedat IS NULL`, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
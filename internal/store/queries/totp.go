@@ -0,0 +1,174 @@
+// Defines Queries/Db operations related to TOTP two-factor authentication
+package queries
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrTotpAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTotpNotPending        = errors.New("no two-factor enrolment in progress")
+	ErrLoginChallengeInvalid = errors.New("login challenge is invalid, used or expired")
+)
+
+// GetTotp returns the TOTP secret of a user, whether it is enabled and the last accepted step
+func GetTotp(pool *pgxpool.Pool, userId int) (secret string, enabled bool, lastStep int64, err error) {
+	err = pool.QueryRow(context.Background(), `
+	SELECT COALESCE(totpsecret, ''), totpenabled, totplaststep FROM users WHERE id = $1
+	`, userId).Scan(&secret, &enabled, &lastStep)
+	return secret, enabled, lastStep, err
+}
+
+// SetTotpSecret starts an enrolment by storing a secret that is not enabled yet
+func SetTotpSecret(pool *pgxpool.Pool, userId int, secret string) error {
+	result, err := pool.Exec(context.Background(), `
+	UPDATE users SET totpsecret = $2, totplaststep = 0
+	WHERE id = $1 AND NOT totpenabled
+	`, userId, secret)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTotpAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTotp finishes an enrolment and replaces the user's recovery codes, codeHashes must already be hashed
+func EnableTotp(pool *pgxpool.Pool, userId int, step int64, codeHashes []string) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+	UPDATE users SET totpenabled = TRUE, totpenabledat = NOW(), totplaststep = $2, updatedat = NOW()
+	WHERE id = $1 AND NOT totpenabled AND totpsecret IS NOT NULL
+	`, userId, step)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTotpNotPending
+	}
+	if err = replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId int, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM totprecoverycodes WHERE userid = $1`, userId); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(ctx, `INSERT INTO totprecoverycodes (userid, codehash) VALUES ($1, $2)`, userId, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisableTotp removes the secret and recovery codes of a user
+func DisableTotp(pool *pgxpool.Pool, userId int) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+	UPDATE users SET totpsecret = NULL, totpenabled = FALSE, totpenabledat = NULL, totplaststep = 0, updatedat = NOW()
+	WHERE id = $1
+	`, userId)
+	if err != nil {
+		return err
+	}
+	if err = replaceRecoveryCodes(ctx, tx, userId, nil); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTotpStep records step as the last accepted one, reporting false if it or a later step was already used
+func UseTotpStep(pool *pgxpool.Pool, userId int, step int64) (bool, error) {
+	result, err := pool.Exec(context.Background(),
+		`UPDATE users SET totplaststep = $2 WHERE id = $1 AND totplaststep < $2`, userId, step)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode uses up an unused recovery code of a user, reporting false if there is none
+func UseRecoveryCode(pool *pgxpool.Pool, userId int, codeHash string) (bool, error) {
+	result, err := pool.Exec(context.Background(), `
+	UPDATE totprecoverycodes SET usedat = NOW()
+	WHERE id = (
+		SELECT id FROM totprecoverycodes
+		WHERE userid = $1 AND codehash = $2 AND usedat IS NULL
+		LIMIT 1
+	)
+	`, userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(pool *pgxpool.Pool, userId int) (count int, err error) {
+	err = pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM totprecoverycodes WHERE userid = $1 AND usedat IS NULL`, userId).Scan(&count)
+	return count, err
+}
+
+// CreateLoginChallenge stores the hashed challenge a password login hands out when a second factor is needed
+func CreateLoginChallenge(pool *pgxpool.Pool, userId int, tokenHash string, expiresAt time.Time) error {
+	_, err := pool.Exec(context.Background(), `
+	INSERT INTO loginchallenges (userid, tokenhash, expiresat)
+	VALUES ($1, $2, $3)
+	`, userId, tokenHash, expiresAt)
+	return err
+}
+
+// GetLoginChallenge returns the user of an unused, unexpired challenge with fewer than maxAttempts failures
+func GetLoginChallenge(pool *pgxpool.Pool, tokenHash string, maxAttempts int) (userId int, err error) {
+	err = pool.QueryRow(context.Background(), `
+	SELECT userid FROM loginchallenges
+	WHERE tokenhash = $1 AND usedat IS NULL AND expiresat > NOW() AND attempts < $2
+	`, tokenHash, maxAttempts).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrLoginChallengeInvalid
+	}
+	return userId, err
+}
+
+// FailLoginChallenge counts a wrong code against a challenge
+func FailLoginChallenge(pool *pgxpool.Pool, tokenHash string) error {
+	_, err := pool.Exec(context.Background(),
+		`UPDATE loginchallenges SET attempts = attempts + 1 WHERE tokenhash = $1`, tokenHash)
+	return err
+}
+
+// CompleteLoginChallenge uses up a challenge, it fails if the challenge was used concurrently
+func CompleteLoginChallenge(pool *pgxpool.Pool, tokenHash string) error {
+	result, err := pool.Exec(context.Background(),
+		`UPDATE loginchallenges SET usedat = NOW() WHERE tokenhash = $1 AND usedat IS NULL`, tokenHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrLoginChallengeInvalid
+	}
+	return nil
+}
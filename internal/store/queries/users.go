@@ -31,17 +31,35 @@ func InsertUser(pool *pgxpool.Pool, user model.User) (userId int, err error) {
 	return userId, nil
 }
 
-func GetUserHash(pool *pgxpool.Pool, email string) (string, error) {
+// GetUserHash returns the user record, including the password hash, for an email
+func GetUserHash(pool *pgxpool.Pool, email string) (user model.User, err error) {
 	//Sanitize before bringing it here
-	var passwordhash string
 	stmt := `
-	SELECT passwordhash FROM users WHERE email=$1
+	SELECT id,username,email,passwordhash,isadmin,accesslevel,createdat,updatedat,
+	emailverified,emailverifiedat,verificationsentat,totpenabled,
+	suspended,suspendedat,suspendedreason
+	FROM users WHERE email=$1 AND deletedat IS NULL
 	`
-	err := pool.QueryRow(context.Background(), stmt, email).Scan(&passwordhash)
+	err = pool.QueryRow(context.Background(), stmt, email).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.Password_hash,
+		&user.Is_admin,
+		&user.Access_level,
+		&user.Created_at,
+		&user.Updated_at,
+		&user.Email_verified,
+		&user.Email_verified_at,
+		&user.Verification_sent_at,
+		&user.Totp_enabled,
+		&user.Suspended,
+		&user.Suspended_at,
+		&user.Suspended_reason)
 	if err != nil {
-		return "", err
+		return model.User{}, err
 	}
 
-	return passwordhash, nil
+	return user, nil
 
 }
@@ -0,0 +1,83 @@
+// Defines Queries/Db operations related to email verification
+package queries
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrVerificationTokenInvalid = errors.New("verification token is invalid, used or expired")
+
+// CreateEmailVerification stores a hashed verification token and records when it was sent.
+// Earlier unused tokens of the user stop working.
+func CreateEmailVerification(pool *pgxpool.Pool, userId int, tokenHash string, expiresAt time.Time) error {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE emailverifications SET usedat = NOW() WHERE userid = $1 AND usedat IS NULL`, userId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+	INSERT INTO emailverifications (userid, tokenhash, expiresat)
+	VALUES ($1, $2, $3)
+	`, userId, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE users SET verificationsentat = NOW() WHERE id = $1`, userId); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ConsumeEmailVerification uses up a verification token and marks the user's email verified
+func ConsumeEmailVerification(pool *pgxpool.Pool, tokenHash string) (userId int, err error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var verificationId int
+	err = tx.QueryRow(ctx, `
+	SELECT id, userid FROM emailverifications
+	WHERE tokenhash = $1 AND usedat IS NULL AND expiresat > NOW()
+	FOR UPDATE
+	`, tokenHash).Scan(&verificationId, &userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrVerificationTokenInvalid
+		}
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE emailverifications SET usedat = NOW() WHERE id = $1`, verificationId); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+	UPDATE users SET emailverified = TRUE, emailverifiedat = NOW(), updatedat = NOW()
+	WHERE id = $1 AND NOT emailverified
+	`, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+// IsEmailVerified reports whether a user has verified their email
+func IsEmailVerified(pool *pgxpool.Pool, userId int) (verified bool, err error) {
+	err = pool.QueryRow(context.Background(), `SELECT emailverified FROM users WHERE id = $1`, userId).Scan(&verified)
+	return verified, err
+}
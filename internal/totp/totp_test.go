@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 seed, last 6 of the 8 digit codes
+func TestRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		got, err := CodeAt(secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("code at %d: %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("at %d expected %s but got %s", v.unix, v.code, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := CodeAt(secret, Step(now))
+	previous, _ := CodeAt(secret, Step(now)-1)
+	old, _ := CodeAt(secret, Step(now)-3)
+
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("expected current code to validate at step %d, got %d %v", Step(now), step, ok)
+	}
+	if _, ok := Validate(secret, previous, now); !ok {
+		t.Error("expected the previous step to be accepted for clock drift")
+	}
+	if _, ok := Validate(secret, old, now); ok {
+		t.Error("expected a code three steps old to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("expected a short code to be rejected")
+	}
+	if _, ok := Validate(secret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("expected spaces in the code to be ignored")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("LapBytes", "jane@example.com", "ABC")
+	for _, want := range []string{"otpauth://totp/LapBytes:jane@example.com?", "secret=ABC", "issuer=LapBytes", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("expected %q in %q", want, uri)
+		}
+	}
+}
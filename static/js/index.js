@@ -36,12 +36,16 @@
         const stockBadge = laptop.is_in_stock ? 
             `<span class="badge in-stock">In Stock</span>` : 
             `<span class="badge out-of-stock">Out of Stock</span>`;
-        const imageUrl = laptop.image_url || '';
+        // uploaded images are served resized; older products point at images hosted elsewhere
+        const uploaded = (laptop.image_url || '').startsWith('/media/');
+        // the version in the URL lets the browser keep resized images for good
+        const imageUrl = uploaded ? `/img/${laptop.id}/card?v=${laptop.version}` : (laptop.image_url || '');
+        const imageSrcset = uploaded ? ` srcset="/img/${laptop.id}/card?v=${laptop.version} 1x, /img/${laptop.id}/detail?v=${laptop.version} 2x"` : '';
         
         return `
             <div class="product-card" onclick="window.location.href='/product/${laptop.id}'" style="cursor: pointer;">
                 <div class="product-image">
-                    ${imageUrl ? `<img src="${imageUrl}" alt="${name}" onerror="this.style.display='none'">` : ''}
+                    ${imageUrl ? `<img src="${imageUrl}"${imageSrcset} alt="${name}" loading="lazy" onerror="this.style.display='none'">` : ''}
                     <div class="product-badges">${stockBadge}</div>
                 </div>
                 <div class="product-info">
@@ -135,11 +139,32 @@
         }
     }
     
-    function addToCart(laptopId) {
+    function updateCartCount(cart) {
         const cartCount = document.querySelector('.cart-count');
-        if (cartCount) {
-            const currentCount = parseInt(cartCount.textContent) || 0;
-            cartCount.textContent = currentCount + 1;
+        if (cartCount && cart) {
+            cartCount.textContent = cart.item_count || 0;
+        }
+    }
+    
+    async function addToCart(laptopId) {
+        const token = localStorage.getItem('access_token');
+        try {
+            const response = await fetch(`${API_BASE}/cart`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...(token ? { 'Authorization': `Bearer ${token}` } : {})
+                },
+                body: JSON.stringify({ product_id: laptopId, quantity: 1 })
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                alert(data.error || 'Could not add to cart');
+                return;
+            }
+            updateCartCount(data.cart);
+        } catch (error) {
+            alert('Could not add to cart. Please try again.');
         }
     }
     